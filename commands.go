@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cs249-dht/control"
+	"cs249-dht/server"
+)
+
+// command is a client subcommand.
+type command struct {
+	usage   string
+	summary string
+	args    int // positional arguments it takes
+	run     func(ctx context.Context, api control.API, args []string) (any, error)
+}
+
+var commands = map[string]command{
+	"put": {"put <key> <value|@file>", "store a value on the k closest nodes", 2,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			value := []byte(args[1])
+			if path, ok := strings.CutPrefix(args[1], "@"); ok {
+				var err error
+				if value, err = os.ReadFile(path); err != nil {
+					return nil, err
+				}
+			}
+			return api.Put(ctx, args[0], value)
+		}},
+	"get": {"get <key>", "read the value stored under key", 1,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			return api.Get(ctx, args[0])
+		}},
+	"delete": {"delete <key>", "retract a value the node published, leaving tombstones on its replicas", 1,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			return api.Delete(ctx, args[0])
+		}},
+	"ping": {"ping <ip:port>", "ping a node and show its ID and round trip time", 1,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			return api.Ping(ctx, args[0])
+		}},
+	"lookup": {"lookup <id>", "find the k closest nodes to a hex ID", 1,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			return api.Lookup(ctx, args[0])
+		}},
+	"trace": {"trace <id>", "look up a hex ID and show every round of the lookup", 1,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			return api.Trace(ctx, args[0])
+		}},
+	"routes": {"routes", "show the routing table", 0,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			return api.Routes(ctx)
+		}},
+	"node": {"node", "show the node's identity and a summary of its state", 0,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			return api.Node(ctx)
+		}},
+	"store": {"store ls", "list the keys stored on the node", 1,
+		func(ctx context.Context, api control.API, args []string) (any, error) {
+			if args[0] != "ls" {
+				return nil, fmt.Errorf("unknown store command %q, wanted ls", args[0])
+			}
+			return api.Store(ctx)
+		}},
+}
+
+// commandOrder is the order usage lists the commands in.
+var commandOrder = []string{"put", "get", "delete", "ping", "lookup", "trace", "routes", "store", "node"}
+
+// runCommand runs a client subcommand and returns the exit code.
+func runCommand(name string, cmd command, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	apiAddr := fs.String("api", "", "use the control API of the running node at this address (host:port or unix:/path) instead of starting one")
+	jsonOut := fs.Bool("json", false, "print the result as JSON")
+	dotOut := fs.Bool("dot", false, "print a lookup trace as a Graphviz graph (trace only)")
+	timeout := fs.Duration("timeout", 30*time.Second, "give up after this long, 0 = no limit")
+	configFile := fs.String("config", "", "JSON, YAML or TOML file with the settings of the short-lived node")
+	configFlags := server.BindConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [flags]\n\n%s. Without -api, a short-lived node joins the\nnetwork through -bootstrap (default 127.0.0.1:8090) to do it.\n\nflags:\n",
+			os.Args[0], cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	// flags may come before, between or after the arguments
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != cmd.args {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	var api control.API
+	if *apiAddr != "" {
+		api = control.NewClient(*apiAddr)
+	} else {
+		srv, err := startEphemeral(ctx, *configFile, configFlags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 1
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdownCtx)
+		}()
+		api = control.Local{Server: srv}
+	}
+
+	result, err := cmd.run(ctx, api, positional)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	if trace, ok := result.(*server.LookupTrace); ok && *dotOut {
+		trace.WriteDOT(os.Stdout)
+	} else if *dotOut {
+		fmt.Fprintf(os.Stderr, "%s: -dot only applies to trace\n", name)
+		return 2
+	} else if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		control.WriteText(os.Stdout, result)
+	}
+	return 0
+}
+
+// startEphemeral starts a node that joins the network for one command. It
+// takes a free port unless one is configured, and tells its contacts when
+// it leaves so they don't keep it in their routing tables.
+func startEphemeral(ctx context.Context, configFile string, flags server.ConfigFlags) (*server.Server, error) {
+	cfg, err := server.LoadConfig(configFile, os.Environ(), flags)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Bootstrap) == 0 {
+		cfg.Bootstrap = []string{"127.0.0.1:8090"}
+	}
+	if cfg.Port == server.DefaultConfig().Port {
+		if cfg.Port, err = freePort(cfg.IP); err != nil {
+			return nil, err
+		}
+	}
+	cfg.NotifyLeave = true
+	// a config file shared with a long-running node must not get its state
+	cfg.StateFile = ""
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.Start(ctx); err != nil {
+		srv.Close()
+		return nil, err
+	}
+	return srv, nil
+}
+
+// freePort returns a UDP port on ip that nothing is bound to right now.
+func freePort(ip string) (int, error) {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
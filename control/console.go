@@ -0,0 +1,339 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"cs249-dht/node"
+	"cs249-dht/server"
+)
+
+// ErrQuit is returned by Console.Exec for the quit command.
+var ErrQuit = errors.New("quit")
+
+// Console runs the commands typed at the -interactive prompt against a node
+// in this process, which keeps serving RPCs meanwhile. To show the node's
+// log records in between, give the node a logger that writes to the Console
+// at Level (see server.NewLogger); the log command changes Level.
+type Console struct {
+	Local Local
+	// how long one command may take, 0 = no limit
+	Timeout time.Duration
+	// least severe log records shown, server.LevelOff until "log on"
+	Level *slog.LevelVar
+
+	// guards Out, which Run swaps for the terminal
+	mu  sync.Mutex
+	Out io.Writer
+}
+
+// NewConsole returns a Console for local that writes to out.
+func NewConsole(local Local, out io.Writer) *Console {
+	c := &Console{Local: local, Out: out, Timeout: 30 * time.Second, Level: new(slog.LevelVar)}
+	c.Level.Set(server.LevelOff)
+	return c
+}
+
+// Write writes p to the console's output. It is safe to call while the
+// console runs, between the output of commands.
+func (c *Console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Out.Write(p)
+}
+
+// setOut replaces Out, returning the one it had.
+func (c *Console) setOut(out io.Writer) io.Writer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.Out
+	c.Out = out
+	return old
+}
+
+// consoleCommand is a command of the console.
+type consoleCommand struct {
+	usage   string
+	summary string
+	// arguments it takes; with rest the last one runs to the end of the line
+	minArgs, maxArgs int
+	rest             bool
+	run              func(c *Console, ctx context.Context, args []string) error
+}
+
+var consoleCommands = map[string]consoleCommand{
+	"put": {"put <key> <value>", "store a value on the k closest nodes", 2, 2, true,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Put(ctx, args[0], []byte(args[1])))
+		}},
+	"get": {"get <key>", "read the value stored under key", 1, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Get(ctx, args[0]))
+		}},
+	"delete": {"delete <key>", "retract a value this node published", 1, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Delete(ctx, args[0]))
+		}},
+	"lookup": {"lookup <id>", "find the k closest nodes to a hex ID", 1, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Lookup(ctx, args[0]))
+		}},
+	"trace": {"trace <id>", "look up a hex ID and show every round of the lookup", 1, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Trace(ctx, args[0]))
+		}},
+	"ping": {"ping <ip:port>", "ping a node and show its ID and round trip time", 1, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Ping(ctx, args[0]))
+		}},
+	"buckets": {"buckets", "show the routing table", 0, 0, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Routes(ctx))
+		}},
+	"store": {"store", "list the keys stored on this node", 0, 0, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Store(ctx))
+		}},
+	"node": {"node", "show this node's identity and a summary of its state", 0, 0, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.show(c.Local.Node(ctx))
+		}},
+	"add": {"add <ip:port>", "ping a node and add it to the routing table", 1, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.add(ctx, args[0])
+		}},
+	"remove": {"remove <id-prefix|ip:port>", "drop a contact from the routing table", 1, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.remove(args[0])
+		}},
+	"log": {"log [on|off|debug|info|warn|error]", "show or hide the node's log records, or show them from a level up", 0, 1, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return c.setLogs(args)
+		}},
+	// help lists this table, so Exec runs it
+	"help": {"help", "list the commands", 0, 0, false, nil},
+	"quit": {"quit", "leave the console and shut the node down", 0, 0, false,
+		func(c *Console, ctx context.Context, args []string) error {
+			return ErrQuit
+		}},
+}
+
+// consoleOrder is the order help lists the commands in.
+var consoleOrder = []string{"put", "get", "delete", "lookup", "trace", "ping", "buckets", "store", "node", "add", "remove", "log", "help", "quit"}
+
+// Exec runs one line typed at the console. It returns ErrQuit when the line
+// asks to leave, and the command's error if it failed.
+func (c *Console) Exec(ctx context.Context, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	name := fields[0]
+	if name == "exit" {
+		name = "quit"
+	}
+	cmd, ok := consoleCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q, try help", fields[0])
+	}
+
+	args := fields[1:]
+	if cmd.rest && len(args) > cmd.maxArgs {
+		// keep the spacing of a value typed with spaces in it
+		value := strings.TrimSpace(line)
+		for _, f := range fields[:cmd.maxArgs] {
+			value = strings.TrimSpace(strings.TrimPrefix(value, f))
+		}
+		args = append(args[:cmd.maxArgs-1], value)
+	}
+	if len(args) < cmd.minArgs || len(args) > cmd.maxArgs {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	}
+
+	if cmd.run == nil {
+		c.help()
+		return nil
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	return cmd.run(c, ctx, args)
+}
+
+func (c *Console) show(result any, err error) error {
+	if err != nil {
+		return err
+	}
+	WriteText(c, result)
+	return nil
+}
+
+func (c *Console) help() {
+	for _, name := range consoleOrder {
+		cmd := consoleCommands[name]
+		fmt.Fprintf(c, "  %-28s %s\n", cmd.usage, cmd.summary)
+	}
+}
+
+func (c *Console) add(ctx context.Context, addr string) error {
+	ping, err := c.Local.Ping(ctx, addr)
+	if err != nil {
+		return err
+	}
+	peer, err := c.contact(ping.ID)
+	if err != nil {
+		// a full bucket keeps its live contacts and queues the newcomer
+		fmt.Fprintf(c, "%s:%d id=%s answered but its bucket is full, kept as a replacement\n", ping.IP, ping.Port, ping.ID)
+		return nil
+	}
+	fmt.Fprintf(c, "Added %s:%d id=%s\n", peer.IP(), peer.Port(), peer.HexID())
+	return nil
+}
+
+func (c *Console) remove(which string) error {
+	peer, err := c.contact(which)
+	if err != nil {
+		return err
+	}
+	c.Local.Server.Router.RemoveContact(peer)
+	fmt.Fprintf(c, "Removed %s:%d id=%s\n", peer.IP(), peer.Port(), peer.HexID())
+	return nil
+}
+
+// contact finds the one contact in the routing table whose ip:port is which
+// or whose hex ID starts with it.
+func (c *Console) contact(which string) (node.Node, error) {
+	var matches []node.Node
+	for _, n := range c.Local.Server.Router.Contacts() {
+		if fmt.Sprintf("%s:%d", n.IP(), n.Port()) == which || strings.HasPrefix(n.HexID(), strings.ToLower(which)) {
+			matches = append(matches, n)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return node.Node{}, fmt.Errorf("no contact matches %q", which)
+	case 1:
+		return matches[0], nil
+	}
+	return node.Node{}, fmt.Errorf("%d contacts match %q, give more of the ID", len(matches), which)
+}
+
+func (c *Console) setLogs(args []string) error {
+	if len(args) == 1 {
+		name := args[0]
+		if name == "on" {
+			name = "info"
+		}
+		level, err := server.ParseLogLevel(name)
+		if err != nil {
+			return err
+		}
+		c.Level.Set(level)
+	}
+	fmt.Fprintf(c, "Node log level is %s\n", server.FormatLogLevel(c.Level.Level()))
+	return nil
+}
+
+// Run reads commands from in until quit, end of input or ctx is done. On a
+// terminal it shows a prompt with line editing and completion of command
+// names; otherwise it reads one command per line.
+func (c *Console) Run(ctx context.Context, in *os.File) error {
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	var readLine func() (string, error)
+
+	if term.IsTerminal(int(in.Fd())) {
+		state, err := term.MakeRaw(int(in.Fd()))
+		if err != nil {
+			return err
+		}
+		defer term.Restore(int(in.Fd()), state)
+
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{in, c.Out}, "dht> ")
+		t.AutoCompleteCallback = completeCommand
+		// the terminal redraws the prompt around output written through it
+		defer c.setOut(c.setOut(t))
+		readLine = t.ReadLine
+	} else {
+		scanner := bufio.NewScanner(in)
+		readLine = func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+	}
+
+	fmt.Fprintln(c, "Type help for the commands, quit or Ctrl-D to leave.")
+	go func() {
+		for {
+			line, err := readLine()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case line := <-lines:
+			err := c.Exec(ctx, line)
+			if err == ErrQuit {
+				return nil
+			}
+			if err != nil {
+				fmt.Fprintf(c, "error: %v\n", err)
+			}
+		}
+	}
+}
+
+// completeCommand completes the command name on a tab at the end of the
+// first word.
+func completeCommand(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || pos != len(line) || strings.Contains(line, " ") {
+		return "", 0, false
+	}
+	var names []string
+	for name := range consoleCommands {
+		if strings.HasPrefix(name, line) {
+			names = append(names, name)
+		}
+	}
+	if len(names) != 1 {
+		return "", 0, false
+	}
+	return names[0] + " ", len(names[0]) + 1, true
+}
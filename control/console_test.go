@@ -0,0 +1,86 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"cs249-dht/server"
+)
+
+func TestConsoleExec(t *testing.T) {
+	boot, joined := startNetwork(t, 21041)
+	var out bytes.Buffer
+	console := NewConsole(Local{Server: boot}, &out)
+	ctx := context.Background()
+
+	for _, line := range []string{"put greeting hello  there", "get greeting"} {
+		if err := console.Exec(ctx, line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	if !strings.Contains(out.String(), "hello  there\n") {
+		t.Errorf("got %q, wanted the value with its spacing", out.String())
+	}
+
+	id := joined.Self.HexID()
+	if err := console.Exec(ctx, "remove "+id[:8]); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if got := boot.Router.Size(); got != 0 {
+		t.Errorf("got %d contacts after remove, wanted 0", got)
+	}
+	if err := console.Exec(ctx, "add 127.0.0.1:21042"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if boot.Router.IsNewNode(joined.Self) {
+		t.Errorf("got the joined node missing after add, wanted it back in the routing table")
+	}
+
+	for _, line := range []string{"frobnicate", "get", "log maybe", "remove ffffffffffff"} {
+		if err := console.Exec(ctx, line); err == nil {
+			t.Errorf("%s: got nil error, wanted error", line)
+		}
+	}
+	if err := console.Exec(ctx, "exit"); err != ErrQuit {
+		t.Errorf("got %v for exit, wanted ErrQuit", err)
+	}
+}
+
+func TestConsoleLogLevel(t *testing.T) {
+	boot, _ := startNetwork(t, 21051)
+
+	// the node logs each answered ping at debug level
+	for _, tc := range []struct {
+		script string
+		logs   int
+	}{
+		{"ping 127.0.0.1:21052\nlog on\nping 127.0.0.1:21052\nquit\nnode\n", 0},
+		{"log debug\nping 127.0.0.1:21052\n", 1},
+	} {
+		in, script, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		script.WriteString(tc.script)
+		script.Close()
+
+		var out bytes.Buffer
+		console := NewConsole(Local{Server: boot}, &out)
+		boot.SetLogger(server.NewLogger(console, "text", console.Level))
+		err = console.Run(context.Background(), in)
+		in.Close()
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+
+		if got := strings.Count(out.String(), "msg=\"ping answered\""); got != tc.logs {
+			t.Errorf("%q: got %d ping records in %q, wanted %d", tc.script, got, out.String(), tc.logs)
+		}
+		if !strings.Contains(out.String(), "rtt=") || strings.Contains(out.String(), "stored keys") {
+			t.Errorf("%q: got %q, wanted the ping result and nothing after quit", tc.script, out.String())
+		}
+	}
+}
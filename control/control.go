@@ -0,0 +1,271 @@
+// Package control drives a node from the outside: the operations the
+// command line tool offers, run either on a Server in this process (Local)
+// or on a running node through its HTTP control API (Client, served by
+// Handler). Both return the same JSON-friendly results.
+package control
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/server"
+	"cs249-dht/storage"
+)
+
+// ErrInvalidArgument is wrapped by the errors for malformed requests, which
+// the HTTP API answers with 400 Bad Request.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// API is what can be asked of a node.
+type API interface {
+	Put(ctx context.Context, key string, value []byte) (PutResult, error)
+	Get(ctx context.Context, key string) (GetResult, error)
+	Delete(ctx context.Context, key string) (DeleteResult, error)
+	Ping(ctx context.Context, addr string) (PingResult, error)
+	Lookup(ctx context.Context, id string) (LookupResult, error)
+	Trace(ctx context.Context, id string) (*server.LookupTrace, error)
+	Routes(ctx context.Context) (RoutesResult, error)
+	Store(ctx context.Context) (StoreResult, error)
+	Node(ctx context.Context) (NodeInfo, error)
+}
+
+// Contact is a node as the API reports it.
+type Contact struct {
+	ID   string `json:"id"`
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+func contactOf(n node.Node) Contact {
+	return Contact{ID: n.HexID(), IP: n.IP(), Port: n.Port()}
+}
+
+func contactsOf(nodes []node.Node) []Contact {
+	contacts := make([]Contact, 0, len(nodes))
+	for _, n := range nodes {
+		contacts = append(contacts, contactOf(n))
+	}
+	return contacts
+}
+
+// PutResult reports a replicated write.
+type PutResult struct {
+	Key      string    `json:"key"`
+	Acks     int       `json:"acks"`
+	Quorum   int       `json:"quorum"`
+	Replicas []Contact `json:"replicas"`
+}
+
+// GetResult is the value a read resolved, base64 encoded in JSON.
+type GetResult struct {
+	Key         string `json:"key"`
+	Value       []byte `json:"value"`
+	Version     string `json:"version"`
+	Replies     int    `json:"replies"`
+	Repaired    int    `json:"repaired"`
+	Conflicting bool   `json:"conflicting"`
+}
+
+// DeleteResult reports how many remote replicas accepted a delete.
+type DeleteResult struct {
+	Key   string `json:"key"`
+	Nodes int    `json:"nodes"`
+}
+
+// PingResult is the node that answered a ping and the round trip time.
+type PingResult struct {
+	Contact
+	RTTMillis float64 `json:"rtt_ms"`
+}
+
+// LookupResult is the closest nodes to Target that a lookup found.
+type LookupResult struct {
+	Target string    `json:"target"`
+	Nodes  []Contact `json:"nodes"`
+}
+
+// Bucket is one k-bucket of the routing table: the range of IDs it covers
+// in hex, its contacts oldest first and the replacements waiting for a slot.
+type Bucket struct {
+	Lower        string    `json:"lower"`
+	Upper        string    `json:"upper"`
+	LastUpdated  time.Time `json:"last_updated"`
+	Contacts     []Contact `json:"contacts"`
+	Replacements []Contact `json:"replacements"`
+}
+
+// RoutesResult is the node's routing table.
+type RoutesResult struct {
+	Self    Contact  `json:"self"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// StoredKey describes one value in the node's local store.
+type StoredKey struct {
+	Key       string    `json:"key"`
+	Bytes     int       `json:"bytes"`
+	Publisher string    `json:"publisher,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	StoredAt  time.Time `json:"stored_at"`
+}
+
+// StoreResult lists the node's local store, oldest first.
+type StoreResult struct {
+	Keys []StoredKey `json:"keys"`
+}
+
+// NodeInfo is the node's identity and a summary of its state.
+type NodeInfo struct {
+	Contact
+	// S/Kademlia identity, empty for a plain node
+	Secure    bool   `json:"secure"`
+	PublicKey string `json:"public_key,omitempty"`
+
+	K          int `json:"k"`
+	Alpha      int `json:"alpha"`
+	Contacts   int `json:"contacts"`
+	StoredKeys int `json:"stored_keys"`
+}
+
+// Local runs the API on a Server in this process.
+type Local struct {
+	Server *server.Server
+}
+
+func (l Local) Put(ctx context.Context, key string, value []byte) (PutResult, error) {
+	stored, err := l.Server.StoreValue(ctx, key, value)
+	result := PutResult{Key: key, Acks: stored.Acks, Quorum: stored.Quorum, Replicas: []Contact{}}
+	for _, r := range stored.Replicas {
+		if r.Err == nil {
+			result.Replicas = append(result.Replicas, contactOf(r.Node))
+		}
+	}
+	return result, err
+}
+
+func (l Local) Get(ctx context.Context, key string) (GetResult, error) {
+	read, err := l.Server.ReadValue(ctx, key)
+	if err != nil && len(read.Replies) == 0 {
+		return GetResult{}, err
+	}
+	// a value from fewer replicas than the read quorum is still a value
+	return GetResult{
+		Key:         key,
+		Value:       read.Value,
+		Version:     read.Winner.Version.String(),
+		Replies:     len(read.Replies),
+		Repaired:    len(read.Repaired),
+		Conflicting: read.Conflicting,
+	}, nil
+}
+
+func (l Local) Delete(ctx context.Context, key string) (DeleteResult, error) {
+	n, err := l.Server.Delete(ctx, key)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	return DeleteResult{Key: key, Nodes: n}, nil
+}
+
+func (l Local) Ping(ctx context.Context, addr string) (PingResult, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return PingResult{}, fmt.Errorf("%w: address %q: %v", ErrInvalidArgument, addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return PingResult{}, fmt.Errorf("%w: address %q: bad port", ErrInvalidArgument, addr)
+	}
+	peer, rtt, err := l.Server.Ping(ctx, host, port)
+	if err != nil {
+		return PingResult{}, err
+	}
+	return PingResult{Contact: contactOf(peer), RTTMillis: float64(rtt.Microseconds()) / 1000}, nil
+}
+
+func (l Local) Lookup(ctx context.Context, id string) (LookupResult, error) {
+	target, ok := new(big.Int).SetString(id, 16)
+	if !ok {
+		return LookupResult{}, fmt.Errorf("%w: bad hex node ID %q", ErrInvalidArgument, id)
+	}
+	nodes, err := l.Server.LookupNodes(ctx, target)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	return LookupResult{Target: id, Nodes: contactsOf(nodes)}, nil
+}
+
+func (l Local) Trace(ctx context.Context, id string) (*server.LookupTrace, error) {
+	target, ok := new(big.Int).SetString(id, 16)
+	if !ok {
+		return nil, fmt.Errorf("%w: bad hex node ID %q", ErrInvalidArgument, id)
+	}
+	trace, _, err := l.Server.TraceLookup(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return trace, nil
+}
+
+func (l Local) Routes(ctx context.Context) (RoutesResult, error) {
+	result := RoutesResult{Self: contactOf(l.Server.Self)}
+	for _, b := range l.Server.Router.Buckets() {
+		result.Buckets = append(result.Buckets, Bucket{
+			Lower:        b.Lower.Text(16),
+			Upper:        b.Upper.Text(16),
+			LastUpdated:  b.LastUpdated,
+			Contacts:     contactsOf(b.Contacts),
+			Replacements: contactsOf(b.Replacements),
+		})
+	}
+	return result, nil
+}
+
+func (l Local) Store(ctx context.Context) (StoreResult, error) {
+	result := StoreResult{Keys: []StoredKey{}}
+	for _, key := range l.Server.Store.Keys() {
+		item, ok := l.Server.Store.Get(key)
+		if !ok {
+			continue // expired or evicted since Keys
+		}
+		stored := StoredKey{
+			Key:       key,
+			Bytes:     len(item.Value),
+			Publisher: item.Publisher,
+			StoredAt:  item.StoredAt,
+		}
+		if item.Version != (storage.Version{}) {
+			stored.Version = item.Version.String()
+		}
+		result.Keys = append(result.Keys, stored)
+	}
+	return result, nil
+}
+
+func (l Local) Node(ctx context.Context) (NodeInfo, error) {
+	info := NodeInfo{
+		Contact:    contactOf(l.Server.Self),
+		K:          l.Server.Protocol.Routing.K,
+		Alpha:      l.Server.Protocol.Alpha,
+		Contacts:   l.Server.Router.Size(),
+		StoredKeys: l.Server.Store.Stats().Keys,
+	}
+	if l.Server.Identity != nil {
+		info.Secure = true
+		info.PublicKey = hex.EncodeToString(l.Server.Identity.PublicKey)
+	}
+	return info, nil
+}
+
+// Metrics serves the node's metrics in the Prometheus text format.
+func (l Local) Metrics() http.Handler {
+	return l.Server.Metrics.Registry
+}
@@ -0,0 +1,278 @@
+package control
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cs249-dht/server"
+)
+
+// startNetwork starts a bootstrap node and one node joined to it.
+func startNetwork(t *testing.T, basePort int) (*server.Server, *server.Server) {
+	var nodes []*server.Server
+	for i := 0; i < 2; i++ {
+		cfg := server.DefaultConfig()
+		cfg.Port = basePort + i
+		if i > 0 {
+			cfg.Bootstrap = []string{"127.0.0.1:" + strconv.Itoa(basePort)}
+		}
+		srv, err := server.New(cfg)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { srv.Close() })
+		if err := srv.Start(context.Background()); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		nodes = append(nodes, srv)
+	}
+	time.Sleep(300 * time.Millisecond)
+	return nodes[0], nodes[1]
+}
+
+func TestClientMatchesLocal(t *testing.T) {
+	boot, joined := startNetwork(t, 21001)
+	local := Local{Server: boot}
+	api := httptest.NewServer(Handler(local))
+	defer api.Close()
+	client := NewClient(api.URL)
+	ctx := context.Background()
+
+	put, err := client.Put(ctx, "greeting", []byte("hello"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if put.Acks != 1 || len(put.Replicas) != 1 || put.Replicas[0].ID != joined.Self.HexID() {
+		t.Errorf("got %d acks from %v, wanted the joined node's", put.Acks, put.Replicas)
+	}
+
+	got, err := client.Get(ctx, "greeting")
+	if err != nil || string(got.Value) != "hello" {
+		t.Errorf("got %q, %v, wanted hello", got.Value, err)
+	}
+	if _, err := client.Get(ctx, "missing"); err == nil {
+		t.Errorf("got nil error for a missing key, wanted error")
+	}
+
+	ping, err := client.Ping(ctx, "127.0.0.1:21002")
+	if err != nil || ping.ID != joined.Self.HexID() {
+		t.Errorf("got %+v, %v, wanted the joined node to answer", ping, err)
+	}
+
+	lookup, err := client.Lookup(ctx, "1")
+	if err != nil || len(lookup.Nodes) == 0 {
+		t.Errorf("got %+v, %v, wanted the closest nodes", lookup, err)
+	}
+	if _, err := client.Lookup(ctx, "not-hex"); err == nil {
+		t.Errorf("got nil error for a bad ID, wanted error")
+	}
+
+	// over HTTP or in process, the results are the same
+	for _, call := range []func(API) (any, error){
+		func(a API) (any, error) { return a.Routes(ctx) },
+		func(a API) (any, error) { return a.Store(ctx) },
+	} {
+		remote, err := call(client)
+		if err != nil {
+			t.Fatalf("client: %v", err)
+		}
+		direct, _ := call(local)
+		if !reflect.DeepEqual(normalize(remote), normalize(direct)) {
+			t.Errorf("got %+v over HTTP, wanted %+v", remote, direct)
+		}
+	}
+}
+
+// normalize drops the monotonic clock reading, which JSON doesn't carry.
+func normalize(result any) any {
+	switch r := result.(type) {
+	case StoreResult:
+		for i := range r.Keys {
+			r.Keys[i].StoredAt = r.Keys[i].StoredAt.Round(0).UTC()
+		}
+		return r
+	case RoutesResult:
+		for i := range r.Buckets {
+			r.Buckets[i].LastUpdated = r.Buckets[i].LastUpdated.Round(0).UTC()
+		}
+		return r
+	}
+	return result
+}
+
+func TestUnixSocketAPI(t *testing.T) {
+	boot, _ := startNetwork(t, 21011)
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	listener, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	api := &http.Server{Handler: Handler(Local{Server: boot})}
+	go api.Serve(listener)
+	defer api.Close()
+
+	if _, err := Listen("unix:" + path); err == nil {
+		t.Errorf("got nil error listening on a socket in use, wanted error")
+	}
+
+	client := NewClient("unix:" + path)
+	ctx := context.Background()
+	info, err := client.Node(ctx)
+	if err != nil || info.ID != boot.Self.HexID() || info.Contacts != 1 {
+		t.Errorf("got %+v, %v, wanted the bootstrap node with one contact", info, err)
+	}
+
+	if _, err := client.Put(ctx, "doomed", []byte("v")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	deleted, err := client.Delete(ctx, "doomed")
+	if err != nil || deleted.Nodes != 1 {
+		t.Errorf("got %+v, %v, wanted the delete accepted by the other node", deleted, err)
+	}
+
+	routes, err := client.Routes(ctx)
+	if err != nil || len(routes.Buckets) == 0 || routes.Buckets[0].Replacements == nil {
+		t.Errorf("got %+v, %v, wanted buckets with their replacement lists", routes, err)
+	}
+}
+
+func TestListenOnlyLoopback(t *testing.T) {
+	if _, err := Listen("0.0.0.0:21021"); err == nil {
+		t.Errorf("got nil error for a wildcard address, wanted error")
+	}
+	listener, err := Listen("127.0.0.1:21021")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	listener.Close()
+}
+
+func TestBadRequestStatus(t *testing.T) {
+	boot, _ := startNetwork(t, 21031)
+	api := httptest.NewServer(Handler(Local{Server: boot}))
+	defer api.Close()
+
+	for _, path := range []string{"/lookup/zz", "/ping?addr=nowhere"} {
+		method := http.MethodGet
+		if strings.HasPrefix(path, "/ping") {
+			method = http.MethodPost
+		}
+		req, _ := http.NewRequest(method, api.URL+path, nil)
+		req.Header.Set(RequestHeader, "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, wanted 400", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	boot, _ := startNetwork(t, 21071)
+	api := httptest.NewServer(Handler(Local{Server: boot}))
+	defer api.Close()
+
+	resp, err := http.Get(api.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "dht_routing_contacts 1\n") {
+		t.Errorf("got status %d and %q, wanted the node's metrics", resp.StatusCode, body)
+	}
+
+	// a remote API has no metrics of its own to serve
+	proxy := httptest.NewServer(Handler(NewClient(api.URL)))
+	defer proxy.Close()
+	resp, err = http.Get(proxy.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d through a Client, wanted 404", resp.StatusCode)
+	}
+}
+
+func TestTraceEndpoint(t *testing.T) {
+	boot, joined := startNetwork(t, 21081)
+	api := httptest.NewServer(Handler(Local{Server: joined}))
+	defer api.Close()
+	ctx := context.Background()
+
+	trace, err := NewClient(api.URL).Trace(ctx, "1")
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if trace.Kind != "node" || len(trace.Rounds) == 0 || trace.Rounds[0].Queries[0].ID != boot.Self.HexID() {
+		t.Errorf("got %+v, wanted a node lookup that queried the bootstrap node", trace)
+	}
+
+	resp, err := http.Get(api.URL + "/trace/1?format=dot")
+	if err != nil {
+		t.Fatalf("GET /trace: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/vnd.graphviz" || !strings.HasPrefix(string(body), "digraph lookup {") {
+		t.Errorf("got %s %q, wanted a Graphviz graph", ct, body)
+	}
+
+	resp, err = http.Get(api.URL + "/trace/1?format=svg")
+	if err != nil {
+		t.Fatalf("GET /trace: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown format, wanted 400", resp.StatusCode)
+	}
+}
+
+func TestBrowserRequestsRefused(t *testing.T) {
+	boot, _ := startNetwork(t, 21091)
+	api := httptest.NewServer(Handler(Local{Server: boot}))
+	defer api.Close()
+
+	do := func(method, host string, header bool) int {
+		req, _ := http.NewRequest(method, api.URL+"/ping?addr=nowhere", nil)
+		if host != "" {
+			req.Host = host
+		}
+		if header {
+			req.Header.Set(RequestHeader, "1")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// a cross-site form post can't set the header
+	if got := do(http.MethodPost, "", false); got != http.StatusForbidden {
+		t.Errorf("got status %d for a POST without %s, wanted 403", got, RequestHeader)
+	}
+	// a rebound DNS name reaches us with its own Host
+	if got := do(http.MethodGet, "evil.example:80", true); got != http.StatusForbidden {
+		t.Errorf("got status %d for a foreign Host, wanted 403", got)
+	}
+	for _, host := range []string{"", "localhost:8190", "[::1]:8190"} {
+		if got := do(http.MethodPost, host, true); got != http.StatusBadRequest {
+			t.Errorf("host %q: got status %d, wanted the request through to a 400", host, got)
+		}
+	}
+}
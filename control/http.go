@@ -0,0 +1,305 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"cs249-dht/server"
+)
+
+// MaxValueSize caps the body of a PUT to the control API.
+const MaxValueSize = 64 << 20
+
+// RequestHeader must be set on every PUT, POST and DELETE to the control
+// API. A web page can't add it to a cross-site request without a CORS
+// preflight, which the API never answers.
+const RequestHeader = "X-Dht-Control"
+
+// Handler serves api over HTTP:
+//
+//	GET    /node                                 -> NodeInfo
+//	PUT    /values/{key}   body is the value     -> PutResult
+//	GET    /values/{key}                         -> GetResult
+//	DELETE /values/{key}                         -> DeleteResult
+//	POST   /ping?addr=ip:port                    -> PingResult
+//	GET    /lookup/{id}    id in hex             -> LookupResult
+//	GET    /trace/{id}     id in hex             -> server.LookupTrace
+//	GET    /trace/{id}?format=dot                   the trace as a Graphviz graph
+//	GET    /routes                               -> RoutesResult
+//	GET    /store                                -> StoreResult
+//	GET    /metrics        Prometheus text format, if api is a MetricsSource
+//
+// Failures are answered with a non-2xx status and {"error": "..."}: 400 for
+// a malformed request, 502 when the operation failed. Each request runs
+// under the HTTP request's context.
+//
+// Requests are refused with 403 unless their Host is a loopback name, so a
+// page served from a rebound DNS name can't reach the API, and PUT, POST and
+// DELETE also need RequestHeader.
+func Handler(api API) http.Handler {
+	mux := http.NewServeMux()
+	if source, ok := api.(MetricsSource); ok {
+		mux.Handle("GET /metrics", source.Metrics())
+	}
+	mux.HandleFunc("GET /node", func(w http.ResponseWriter, r *http.Request) {
+		result, err := api.Node(r.Context())
+		writeResult(w, result, err)
+	})
+	mux.HandleFunc("PUT /values/{key}", func(w http.ResponseWriter, r *http.Request) {
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxValueSize))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		result, err := api.Put(r.Context(), r.PathValue("key"), value)
+		writeResult(w, result, err)
+	})
+	mux.HandleFunc("GET /values/{key}", func(w http.ResponseWriter, r *http.Request) {
+		result, err := api.Get(r.Context(), r.PathValue("key"))
+		writeResult(w, result, err)
+	})
+	mux.HandleFunc("DELETE /values/{key}", func(w http.ResponseWriter, r *http.Request) {
+		result, err := api.Delete(r.Context(), r.PathValue("key"))
+		writeResult(w, result, err)
+	})
+	mux.HandleFunc("POST /ping", func(w http.ResponseWriter, r *http.Request) {
+		addr := r.URL.Query().Get("addr")
+		if addr == "" {
+			writeError(w, http.StatusBadRequest, errors.New("missing addr"))
+			return
+		}
+		result, err := api.Ping(r.Context(), addr)
+		writeResult(w, result, err)
+	})
+	mux.HandleFunc("GET /lookup/{id}", func(w http.ResponseWriter, r *http.Request) {
+		result, err := api.Lookup(r.Context(), r.PathValue("id"))
+		writeResult(w, result, err)
+	})
+	mux.HandleFunc("GET /trace/{id}", func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "dot" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown trace format %q, wanted json or dot", format))
+			return
+		}
+		trace, err := api.Trace(r.Context(), r.PathValue("id"))
+		if err != nil || format != "dot" {
+			writeResult(w, trace, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		trace.WriteDOT(w)
+	})
+	mux.HandleFunc("GET /routes", func(w http.ResponseWriter, r *http.Request) {
+		result, err := api.Routes(r.Context())
+		writeResult(w, result, err)
+	})
+	mux.HandleFunc("GET /store", func(w http.ResponseWriter, r *http.Request) {
+		result, err := api.Store(r.Context())
+		writeResult(w, result, err)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loopbackHost(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q is not a loopback name", r.Host))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Header.Get(RequestHeader) == "" {
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s needs the %s header", r.Method, RequestHeader))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// loopbackHost reports whether the Host header host names this machine:
+// localhost, a loopback IP, or unix, which Client uses over a Unix socket.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if strings.EqualFold(host, "localhost") || host == "unix" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// MetricsSource is an API that can also be scraped for the node's metrics.
+type MetricsSource interface {
+	Metrics() http.Handler
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func writeResult(w http.ResponseWriter, result any, err error) {
+	if errors.Is(err, ErrInvalidArgument) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorBody{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Listen opens the socket the control API is served on. addr is either a
+// Unix socket, written unix:/path/to/socket, or a host:port that must be on
+// the loopback interface: the API is unauthenticated, so it is never
+// offered to the network. A stale Unix socket left by a crashed node is
+// replaced.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := unixPath(addr); ok {
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("control API socket %s is in use", path)
+			}
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("control API address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("control API address %q: only loopback addresses or unix sockets are allowed", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// unixPath returns the socket path of a unix:/path address.
+func unixPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, "unix:")
+}
+
+// Client calls the control API of a running node.
+type Client struct {
+	// base URL of the API, e.g. http://127.0.0.1:8190
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient returns a Client for the API at addr: a host:port, a full
+// http:// URL or a unix:/path socket, as given to Listen.
+func NewClient(addr string) *Client {
+	if path, ok := unixPath(addr); ok {
+		dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		return &Client{
+			BaseURL: "http://unix",
+			HTTP:    &http.Client{Transport: &http.Transport{DialContext: dial}},
+		}
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{BaseURL: strings.TrimSuffix(addr, "/"), HTTP: http.DefaultClient}
+}
+
+// call sends a request to path and decodes the JSON reply into out.
+func (c *Client) call(ctx context.Context, method, path string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(RequestHeader, "1")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var failure errorBody
+		if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return errors.New(failure.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: decoding reply: %w", method, path, err)
+	}
+	return nil
+}
+
+func (c *Client) Put(ctx context.Context, key string, value []byte) (PutResult, error) {
+	var result PutResult
+	err := c.call(ctx, http.MethodPut, "/values/"+url.PathEscape(key), bytes.NewReader(value), &result)
+	return result, err
+}
+
+func (c *Client) Get(ctx context.Context, key string) (GetResult, error) {
+	var result GetResult
+	err := c.call(ctx, http.MethodGet, "/values/"+url.PathEscape(key), nil, &result)
+	return result, err
+}
+
+func (c *Client) Delete(ctx context.Context, key string) (DeleteResult, error) {
+	var result DeleteResult
+	err := c.call(ctx, http.MethodDelete, "/values/"+url.PathEscape(key), nil, &result)
+	return result, err
+}
+
+func (c *Client) Ping(ctx context.Context, addr string) (PingResult, error) {
+	var result PingResult
+	err := c.call(ctx, http.MethodPost, "/ping?addr="+url.QueryEscape(addr), nil, &result)
+	return result, err
+}
+
+func (c *Client) Lookup(ctx context.Context, id string) (LookupResult, error) {
+	var result LookupResult
+	err := c.call(ctx, http.MethodGet, "/lookup/"+url.PathEscape(id), nil, &result)
+	return result, err
+}
+
+func (c *Client) Trace(ctx context.Context, id string) (*server.LookupTrace, error) {
+	var result server.LookupTrace
+	if err := c.call(ctx, http.MethodGet, "/trace/"+url.PathEscape(id), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) Routes(ctx context.Context) (RoutesResult, error) {
+	var result RoutesResult
+	err := c.call(ctx, http.MethodGet, "/routes", nil, &result)
+	return result, err
+}
+
+func (c *Client) Store(ctx context.Context) (StoreResult, error) {
+	var result StoreResult
+	err := c.call(ctx, http.MethodGet, "/store", nil, &result)
+	return result, err
+}
+
+func (c *Client) Node(ctx context.Context) (NodeInfo, error) {
+	var result NodeInfo
+	err := c.call(ctx, http.MethodGet, "/node", nil, &result)
+	return result, err
+}
@@ -0,0 +1,87 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cs249-dht/server"
+)
+
+// WriteText writes a result of the API for people to read.
+func WriteText(w io.Writer, result any) {
+	switch r := result.(type) {
+	case PutResult:
+		fmt.Fprintf(w, "Stored %q on %d replicas (quorum %d)\n", r.Key, r.Acks, r.Quorum)
+		printContacts(w, r.Replicas)
+	case GetResult:
+		w.Write(r.Value)
+		if !strings.HasSuffix(string(r.Value), "\n") {
+			fmt.Fprintln(w)
+		}
+	case DeleteResult:
+		fmt.Fprintf(w, "Deleted %q from %d nodes\n", r.Key, r.Nodes)
+	case PingResult:
+		fmt.Fprintf(w, "%s:%d id=%s rtt=%.2fms\n", r.IP, r.Port, r.ID, r.RTTMillis)
+	case LookupResult:
+		printContacts(w, r.Nodes)
+	case *server.LookupTrace:
+		fmt.Fprintf(w, "%s lookup of %s: %d rounds in %.1fms\n", r.Kind, r.Target, len(r.Rounds), r.DurationMillis)
+		for _, round := range r.Rounds {
+			fmt.Fprintf(w, "Round %d path %d, closest at %d bits, %d candidates\n", round.Round, round.Path, round.ClosestBits, len(round.Heap))
+			for _, q := range round.Queries {
+				fmt.Fprintf(w, "  %s %s d=%d bits %s", q.Addr, shortID(q.ID), q.DistanceBits, q.Outcome)
+				if q.Outcome == "answered" {
+					fmt.Fprintf(w, " in %.2fms with %d contacts", q.RTTMillis, len(q.Returned))
+				}
+				if q.Err != "" {
+					fmt.Fprintf(w, ": %s", q.Err)
+				}
+				fmt.Fprintln(w)
+			}
+		}
+		fmt.Fprintln(w, "Result:")
+		for _, n := range r.Result {
+			fmt.Fprintf(w, "- %s id=%s d=%d bits\n", n.Addr, n.ID, n.DistanceBits)
+		}
+	case RoutesResult:
+		fmt.Fprintf(w, "Self %s:%d id=%s\n", r.Self.IP, r.Self.Port, r.Self.ID)
+		for _, b := range r.Buckets {
+			fmt.Fprintf(w, "Bucket [%s, %s) %d contacts, %d replacements, updated %s\n",
+				b.Lower, b.Upper, len(b.Contacts), len(b.Replacements), b.LastUpdated.Format(time.RFC3339))
+			printContacts(w, b.Contacts)
+			for _, c := range b.Replacements {
+				fmt.Fprintf(w, "  replacement %s:%d id=%s\n", c.IP, c.Port, c.ID)
+			}
+		}
+	case StoreResult:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tBYTES\tVERSION\tSTORED")
+		for _, k := range r.Keys {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", k.Key, k.Bytes, k.Version, k.StoredAt.Format(time.RFC3339))
+		}
+		tw.Flush()
+	case NodeInfo:
+		fmt.Fprintf(w, "%s:%d id=%s\n", r.IP, r.Port, r.ID)
+		if r.Secure {
+			fmt.Fprintf(w, "S/Kademlia public key %s\n", r.PublicKey)
+		}
+		fmt.Fprintf(w, "k=%d alpha=%d, %d contacts, %d stored keys\n", r.K, r.Alpha, r.Contacts, r.StoredKeys)
+	}
+}
+
+// shortID is the first 8 hex digits of id, enough to tell nodes apart.
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func printContacts(w io.Writer, contacts []Contact) {
+	for _, c := range contacts {
+		fmt.Fprintf(w, "- %s:%d id=%s\n", c.IP, c.Port, c.ID)
+	}
+}
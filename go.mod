@@ -2,4 +2,11 @@ module cs249-dht
 
 go 1.24.3
 
-require github.com/matheusoliveira/go-ordered-map v0.2.0
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/matheusoliveira/go-ordered-map v0.2.0
+	golang.org/x/term v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.31.0 // indirect
@@ -1,73 +1,56 @@
+// Command cs249-dht runs a DHT node, or talks to the network as a client.
+//
+//	cs249-dht [serve] [flags]          run a node (the default)
+//	cs249-dht put <key> <value|@file>  store a value
+//	cs249-dht get <key>                read a value
+//	cs249-dht delete <key>             retract a value this node published
+//	cs249-dht ping <ip:port>           ping a node
+//	cs249-dht lookup <id>              find the closest nodes to a hex ID
+//	cs249-dht trace <id>               the same, recording every round (-dot for Graphviz)
+//	cs249-dht routes                   show the routing table
+//	cs249-dht store ls                 list the locally stored keys
+//	cs249-dht node                     show the node's identity
+//
+// The client commands start a short-lived node that joins the network for
+// the one operation, or with -api use a running node's control API.
 package main
 
 import (
-	"flag"
 	"fmt"
-	"log"
-	"math/big"
+	"os"
+	"strings"
 )
 
 func main() {
-
-	isBootstrap := flag.Bool("b", false, "is boostrap node")
-	port := flag.Int("p", 8090, "port number")
-	bootstrapIP := flag.String("ba", "127.0.0.1", "bootstrap ip address")
-	bootstrapPort := flag.Int("bp", 8090, "bootstrap node port number")
-
-	lookupTargetHex := flag.String("lookup", "", "hex node ID to lookup")
-
-	flag.Parse()
-
-	server, err := NewServer("127.0.0.1", *port)
-	if err != nil {
-		log.Fatalf("Error creating LocalNode: %v", err)
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		serve(args)
+		return
 	}
 
-	if !*isBootstrap {
-		fmt.Printf("Starting JOINING node on port %d\n", *port)
-		server.PingBootstrap(*bootstrapIP, *bootstrapPort)
-		fmt.Printf("After PingBootstrap, router has %d buckets, bucket[0] size=%d\n",
-			len(server.Router.buckets),
-			server.Router.buckets[0].Len(),
-		)
-		bootstrap_node := server.Router.buckets[0].GetNodes()[0]
-		fmt.Printf("Running LookupNodes for bootstrap node...")
-			nodes, err := server.LookupNodes(bootstrap_node.nodeID)
-			if err != nil {
-				fmt.Printf("LookupNodes for bootstrap error: %v\n", err)
-			} else {
-				fmt.Println("LookupNodes for bootstrap returned:")
-				for _, n := range nodes {
-					fmt.Printf("- %s:%d id=%s\n", n.ipAddr, n.port, n.HexID())
-				}
-			}
-
-		// targetID := ln.Self.nodeID // e.g. lookup our own ID as a test
-		// nodes, err := ln.LookupNodes(targetID)
-
-		// If user requested a lookup, do one
-		if *lookupTargetHex != "" {
-			targetID := new(big.Int)
-			if _, ok := targetID.SetString(*lookupTargetHex, 16); !ok {
-				log.Fatalf("invalid lookup target hex: %s", *lookupTargetHex)
-			}
-
-			fmt.Printf("Running LookupNodes for targetID=%s...\n", *lookupTargetHex)
-			nodes, err := server.LookupNodes(targetID)
-			if err != nil {
-				fmt.Printf("LookupNodes error: %v\n", err)
-			} else {
-				fmt.Println("LookupNodes returned:")
-				for _, n := range nodes {
-					fmt.Printf("- %s:%d id=%s\n", n.ipAddr, n.port, n.HexID())
-				}
-			}
+	name, args := args[0], args[1:]
+	switch name {
+	case "serve":
+		serve(args)
+	case "help":
+		usage()
+	default:
+		cmd, ok := commands[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+			usage()
+			os.Exit(2)
 		}
-	} else {
-		fmt.Printf("Starting BOOTSTRAP node on port %d\n", *port)
+		os.Exit(runCommand(name, cmd, args))
 	}
+}
 
-	// Block forever handling RPCs
-	server.Run()
-
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %-28s %s\n", "serve", "run a node (the default without a command)")
+	for _, name := range commandOrder {
+		cmd := commands[name]
+		fmt.Fprintf(os.Stderr, "  %-28s %s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -help for its flags.\n", os.Args[0])
 }
@@ -0,0 +1,353 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format, so a node can be scraped without
+// pulling in a client library. Metrics may carry labels; their values are
+// given positionally, in the order the label names were registered.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is something a Registry can write out.
+type metric interface {
+	header() (name, help, kind string)
+	samples() []sample
+}
+
+type sample struct {
+	suffix string // _bucket, _sum or _count for histograms
+	labels []labelPair
+	value  float64
+}
+
+type labelPair struct {
+	name, value string
+}
+
+// Registry holds a set of metrics and serves them over HTTP.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(m metric) {
+	name, _, _ := m.header()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the Prometheus text format, in the order
+// they were registered.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range metrics {
+		name, help, kind := m.header()
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+		for _, s := range m.samples() {
+			b.WriteString(name + s.suffix)
+			if len(s.labels) > 0 {
+				b.WriteByte('{')
+				for i, l := range s.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", l.name, escapeLabel(l.value))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP answers a scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func pairs(names, values []string) []labelPair {
+	if len(values) != len(names) {
+		panic(fmt.Sprintf("metrics: got %d label values for labels %v", len(values), names))
+	}
+	labels := make([]labelPair, len(names))
+	for i := range names {
+		labels[i] = labelPair{names[i], values[i]}
+	}
+	return labels
+}
+
+// series keys label values; \xff can't appear in valid UTF-8.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// Counter is a count that only goes up, one per combination of label values.
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	series map[string][]string
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		series: make(map[string][]string),
+	}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the series with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series with the given
+// label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.name + " decreased")
+	}
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s takes labels %v", c.name, c.labels))
+	}
+	key := seriesKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.series[key]; !ok {
+		c.series[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += v
+}
+
+// Value returns the count of the series with the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[seriesKey(labelValues)]
+}
+
+func (c *Counter) header() (string, string, string) {
+	return c.name, c.help, "counter"
+}
+
+func (c *Counter) samples() []sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]sample, 0, len(c.values))
+	for _, key := range sortedKeys(c.series) {
+		out = append(out, sample{labels: pairs(c.labels, c.series[key]), value: c.values[key]})
+	}
+	return out
+}
+
+// Histogram counts observations into cumulative buckets, one set per
+// combination of label values.
+type Histogram struct {
+	name, help string
+	labels     []string
+	bounds     []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative; the last is +Inf
+	sum         float64
+	count       uint64
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds,
+// which must be increasing; a +Inf bucket is always added.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not increasing")
+	}
+	h := &Histogram{
+		name:   name,
+		help:   help,
+		labels: labels,
+		bounds: buckets,
+		series: make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the series with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s takes labels %v", h.name, h.labels))
+	}
+	key := seriesKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.bounds)+1),
+		}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.bounds, v)]++
+	s.sum += v
+	s.count++
+}
+
+// Count returns how many values the series with the given label values has
+// observed.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[seriesKey(labelValues)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) header() (string, string, string) {
+	return h.name, h.help, "histogram"
+}
+
+func (h *Histogram) samples() []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []sample
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		labels := pairs(h.labels, s.labelValues)
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+			le := math.Inf(1)
+			if i < len(h.bounds) {
+				le = h.bounds[i]
+			}
+			bucketLabels := append(append([]labelPair(nil), labels...), labelPair{"le", formatValue(le)})
+			out = append(out, sample{suffix: "_bucket", labels: bucketLabels, value: float64(cumulative)})
+		}
+		out = append(out,
+			sample{suffix: "_sum", labels: labels, value: s.sum},
+			sample{suffix: "_count", labels: labels, value: float64(s.count)})
+	}
+	return out
+}
+
+// ExponentialBuckets returns count bucket bounds, the first start and each
+// factor times the one before.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// LinearBuckets returns count bucket bounds, the first start and each width
+// more than the one before.
+func LinearBuckets(start, width float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start + float64(i)*width
+	}
+	return buckets
+}
+
+// CollectFunc reports the current values of a metric read at scrape time,
+// calling emit once per series.
+type CollectFunc func(emit func(value float64, labelValues ...string))
+
+// funcMetric is a gauge or counter whose values come from a CollectFunc.
+type funcMetric struct {
+	name, help, kind string
+	labels           []string
+	collect          CollectFunc
+}
+
+// NewGaugeFunc registers a gauge whose values collect reports on each
+// scrape, for state that lives elsewhere such as the size of a table.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect CollectFunc) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", labels: labels, collect: collect})
+}
+
+// NewCounterFunc is NewGaugeFunc for a count kept elsewhere that only goes
+// up.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect CollectFunc) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", labels: labels, collect: collect})
+}
+
+func (f *funcMetric) header() (string, string, string) {
+	return f.name, f.help, f.kind
+}
+
+func (f *funcMetric) samples() []sample {
+	var out []sample
+	f.collect(func(value float64, labelValues ...string) {
+		out = append(out, sample{labels: pairs(f.labels, labelValues), value: value})
+	})
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	sent := r.NewCounter("rpcs_sent_total", "RPCs sent.", "type")
+	sent.Inc("ping")
+	sent.Add(2, "find_node")
+	sent.Inc("ping")
+	rtt := r.NewHistogram("rtt_seconds", "Round trip time.", []float64{0.1, 1})
+	rtt.Observe(0.05)
+	rtt.Observe(0.1)
+	rtt.Observe(5)
+	r.NewGaugeFunc("keys", "Stored keys.", []string{"kind"}, func(emit func(float64, ...string)) {
+		emit(3, `a "quoted" kind`)
+	})
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	wanted := `# HELP rpcs_sent_total RPCs sent.
+# TYPE rpcs_sent_total counter
+rpcs_sent_total{type="find_node"} 2
+rpcs_sent_total{type="ping"} 2
+# HELP rtt_seconds Round trip time.
+# TYPE rtt_seconds histogram
+rtt_seconds_bucket{le="0.1"} 2
+rtt_seconds_bucket{le="1"} 2
+rtt_seconds_bucket{le="+Inf"} 3
+rtt_seconds_sum 5.15
+rtt_seconds_count 3
+# HELP keys Stored keys.
+# TYPE keys gauge
+keys{kind="a \"quoted\" kind"} 3
+`
+	if got := b.String(); got != wanted {
+		t.Errorf("got\n%s\nwanted\n%s", got, wanted)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("hits_total", "Hits.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %q, wanted the Prometheus text format", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "hits_total 1\n") {
+		t.Errorf("got %q, wanted the counter", rec.Body.String())
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("c_total", "C.", "type")
+	for name, f := range map[string]func(){
+		"registered twice":  func() { r.NewCounter("c_total", "C again.") },
+		"wrong label count": func() { c.Inc() },
+		"negative add":      func() { c.Add(-1, "x") },
+		"unsorted buckets":  func() { r.NewHistogram("h", "H.", []float64{2, 1}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: got no panic, wanted one", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,6 @@
+package node
+
+const NODE_ID_BUFFER_SIZE = 32 // 20 bytes in 160-bit node ID, but we are using sha-256 so change to 32 bytes
+const NODE_ID_BIT_SIZE = 32 * 8
+const CRYPTO_STATIC_DIFFICULTY = 12  // leading zero bits of H(H(public key))
+const CRYPTO_DYNAMIC_DIFFICULTY = 16 // leading zero bits of H(node ID xor nonce)
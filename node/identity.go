@@ -0,0 +1,150 @@
+package node
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// PuzzleDifficulty holds the number of leading zero bits required by the
+// S/Kademlia static and dynamic crypto puzzles.
+//   - Static:  H(H(publicKey)) must start with Static zero bits
+//   - Dynamic: H(nodeID xor nonce) must start with Dynamic zero bits
+type PuzzleDifficulty struct {
+	Static  int
+	Dynamic int
+}
+
+func DefaultPuzzleDifficulty() PuzzleDifficulty {
+	return PuzzleDifficulty{
+		Static:  CRYPTO_STATIC_DIFFICULTY,
+		Dynamic: CRYPTO_DYNAMIC_DIFFICULTY,
+	}
+}
+
+// NodeProof is what a node presents to prove its ID was derived from its
+// public key and that it paid for it with the crypto puzzles.
+type NodeProof struct {
+	PublicKey ed25519.PublicKey
+	Nonce     []byte
+}
+
+// Identity is the keypair (plus puzzle solution) a node signs its RPCs with.
+type Identity struct {
+	NodeProof
+	PrivateKey ed25519.PrivateKey
+}
+
+// GenerateIdentity creates a new Ed25519 keypair satisfying the static puzzle,
+// derives the node ID from ip/port and the public key (using the extra bytes of
+// NewNodeFromIPAndport), then solves the dynamic puzzle for that ID.
+func GenerateIdentity(ip string, port int, diff PuzzleDifficulty) (*Identity, Node, error) {
+	var pub ed25519.PublicKey
+	var priv ed25519.PrivateKey
+
+	// static puzzle: keep generating keys until H(H(pub)) is cheap enough
+	for {
+		var err error
+		pub, priv, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, Node{}, fmt.Errorf("generate ed25519 key: %w", err)
+		}
+		if staticPuzzleBits(pub) >= diff.Static {
+			break
+		}
+	}
+
+	self, err := NewNodeFromIPAndport(ip, port, pub)
+	if err != nil {
+		return nil, Node{}, err
+	}
+
+	// dynamic puzzle: find a nonce such that H(nodeID xor nonce) is cheap enough
+	nonce := make([]byte, NODE_ID_BUFFER_SIZE)
+	if _, err := rand.Read(nonce[:NODE_ID_BUFFER_SIZE-8]); err != nil {
+		return nil, Node{}, fmt.Errorf("generate nonce: %w", err)
+	}
+	for counter := uint64(0); ; counter++ {
+		binary.BigEndian.PutUint64(nonce[NODE_ID_BUFFER_SIZE-8:], counter)
+		if dynamicPuzzleBits(self.nodeID, nonce) >= diff.Dynamic {
+			break
+		}
+	}
+
+	identity := &Identity{
+		NodeProof: NodeProof{
+			PublicKey: pub,
+			Nonce:     nonce,
+		},
+		PrivateKey: priv,
+	}
+	self.proof = &identity.NodeProof
+
+	return identity, self, nil
+}
+
+// VerifyNodeProof checks that n's ID is bound to the public key in its proof and
+// that both crypto puzzles are solved to at least the given difficulty.
+func VerifyNodeProof(n Node, diff PuzzleDifficulty) error {
+	if n.proof == nil {
+		return errors.New("node has no identity proof")
+	}
+	if len(n.proof.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: %d", len(n.proof.PublicKey))
+	}
+	if n.nodeID == nil {
+		return errors.New("node has no ID")
+	}
+
+	expected, err := NewNodeFromIPAndport(n.ipAddr, n.port, n.proof.PublicKey)
+	if err != nil {
+		return err
+	}
+	if expected.nodeID.Cmp(n.nodeID) != 0 {
+		return fmt.Errorf("node ID %s is not derived from its public key", n.HexID())
+	}
+
+	if staticPuzzleBits(n.proof.PublicKey) < diff.Static {
+		return errors.New("static crypto puzzle not solved")
+	}
+	if dynamicPuzzleBits(n.nodeID, n.proof.Nonce) < diff.Dynamic {
+		return errors.New("dynamic crypto puzzle not solved")
+	}
+
+	return nil
+}
+
+func staticPuzzleBits(pub ed25519.PublicKey) int {
+	first := sha256.Sum256(pub)
+	second := sha256.Sum256(first[:])
+	return leadingZeroBits(second[:])
+}
+
+func dynamicPuzzleBits(nodeID *big.Int, nonce []byte) int {
+	if len(nonce) != NODE_ID_BUFFER_SIZE {
+		return 0
+	}
+	buf := make([]byte, NODE_ID_BUFFER_SIZE)
+	nodeID.FillBytes(buf)
+	for i := range buf {
+		buf[i] ^= nonce[i]
+	}
+	sum := sha256.Sum256(buf)
+	return leadingZeroBits(sum[:])
+}
+
+func leadingZeroBits(b []byte) int {
+	count := 0
+	for _, x := range b {
+		if x != 0 {
+			return count + bits.LeadingZeros8(x)
+		}
+		count += 8
+	}
+	return count
+}
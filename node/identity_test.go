@@ -0,0 +1,61 @@
+package node
+
+import (
+	"testing"
+)
+
+// keep the puzzles cheap so tests stay fast
+var testPuzzle = PuzzleDifficulty{Static: 4, Dynamic: 4}
+
+func TestGenerateIdentity(t *testing.T) {
+	identity, n, err := GenerateIdentity("127.0.0.1", 4001, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+
+	if err := VerifyNodeProof(n, testPuzzle); err != nil {
+		t.Errorf("got %v, wanted valid proof", err)
+	}
+
+	want, _ := NewNodeFromIPAndport("127.0.0.1", 4001, identity.PublicKey)
+	if n.HexID() != want.HexID() {
+		t.Errorf("got %q, wanted %q", n.HexID(), want.HexID())
+	}
+}
+
+func TestVerifyNodeProofRejectsForgedID(t *testing.T) {
+	_, n, err := GenerateIdentity("127.0.0.1", 4001, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+
+	// same proof, claimed from a different address -> ID no longer matches
+	forged := n
+	forged.port = 4002
+	if err := VerifyNodeProof(forged, testPuzzle); err == nil {
+		t.Errorf("got valid proof for forged node, wanted error")
+	}
+
+	// no proof at all
+	plain, _ := NewNodeFromIPAndport("127.0.0.1", 4001)
+	if err := VerifyNodeProof(plain, testPuzzle); err == nil {
+		t.Errorf("got valid proof for node without identity, wanted error")
+	}
+}
+
+func TestVerifyNodeProofDifficulty(t *testing.T) {
+	_, n, err := GenerateIdentity("127.0.0.1", 4001, PuzzleDifficulty{})
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+
+	// find a proof that is too cheap for a harder requirement
+	harder := PuzzleDifficulty{Static: 16, Dynamic: 16}
+	if staticPuzzleBits(n.proof.PublicKey) >= harder.Static && dynamicPuzzleBits(n.nodeID, n.proof.Nonce) >= harder.Dynamic {
+		t.Skip("randomly generated identity happens to satisfy the harder puzzle")
+	}
+
+	if err := VerifyNodeProof(n, harder); err == nil {
+		t.Errorf("got valid proof, wanted puzzle error")
+	}
+}
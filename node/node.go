@@ -1,4 +1,6 @@
-package main
+// Package node defines DHT node IDs, the Node contact type and the
+// S/Kademlia identities and crypto puzzles that back secure node IDs.
+package node
 
 import (
 	"crypto/sha256"
@@ -6,13 +8,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
 	"net"
 	"strings"
-
-	//"log/slog"
-
-	//"github.com/go-faster/xor"
-	"math/big"
 )
 
 // TODO: change NodeID to fixed size byte array like [32]byte for sha-256
@@ -22,6 +20,7 @@ type Node struct {
 	ipAddr string
 	port   int
 	nodeID *big.Int
+	proof  *NodeProof // nil unless the node presented an S/Kademlia identity
 }
 
 // Using Ip address and UDP port to generate new Node
@@ -68,7 +67,17 @@ func NewNodeFromIPAndport(ipStr string, port int, extra ...[]byte) (Node, error)
 	// copy(id[:], sum[:NODE_ID_BUFFER_SIZE]) // originally here to take first 20 bytes (for 160 bit IDs) but since upgrading to sha-256, using full result
 	id := new(big.Int).SetBytes(sum[:])
 
-	return Node{ipStr, port, id}, nil
+	return Node{ipAddr: ipStr, port: port, nodeID: id}, nil
+}
+
+// NewNode returns the contact with the given ID, reachable at ip:port.
+func NewNode(id *big.Int, ip string, port int) Node {
+	return Node{ipAddr: ip, port: port, nodeID: id}
+}
+
+// NewNodeFromID returns a Node with just an ID, e.g. the target of a lookup.
+func NewNodeFromID(id *big.Int) Node {
+	return Node{nodeID: id}
 }
 
 // for testing purposes only
@@ -77,7 +86,33 @@ func NewNodeFromInt(i int64) Node {
 	id_int := big.NewInt(i)
 
 	// we don't really care about ip address and port for testing
-	return Node{"", 0, id_int}
+	return Node{ipAddr: "", port: 0, nodeID: id_int}
+}
+
+// ID returns the node's ID.
+func (self Node) ID() *big.Int {
+	return self.nodeID
+}
+
+// IP returns the address the node is reachable at.
+func (self Node) IP() string {
+	return self.ipAddr
+}
+
+// Port returns the UDP port the node listens on.
+func (self Node) Port() int {
+	return self.port
+}
+
+// Proof returns the node's S/Kademlia identity proof, or nil if it has none.
+func (self Node) Proof() *NodeProof {
+	return self.proof
+}
+
+// WithProof returns a copy of the node carrying proof.
+func (self Node) WithProof(proof *NodeProof) Node {
+	self.proof = proof
+	return self
 }
 
 // Return xor distance from self to n
@@ -105,6 +140,12 @@ func NodeIDToHex(id *big.Int) string {
 	return hex.EncodeToString(res)
 }
 
+// KeyID maps a storage key into the node ID space (SHA-256, like node IDs).
+func KeyID(key string) *big.Int {
+	sum := sha256.Sum256([]byte(key))
+	return new(big.Int).SetBytes(sum[:])
+}
+
 func FindMidpoint(n1 *big.Int, n2 *big.Int) (*big.Int, *big.Int) {
 
 	res := new(big.Int)
@@ -1,8 +1,7 @@
-package main
+package node
 
 import (
 	"testing"
-	"time"
 )
 
 func TestNewFromIPAndport(t *testing.T) {
@@ -76,8 +75,3 @@ func TestDistanceGeneral(t *testing.T) {
 	}
 
 }
-
-// small helper so we don't repeat sleep logic
-func waitForRouting() {
-	time.Sleep(300 * time.Millisecond)
-}
@@ -0,0 +1,33 @@
+package routing
+
+import "fmt"
+
+// Config sizes the routing table.
+type Config struct {
+	// bucket size, and how many closest nodes a lookup returns
+	K int
+	// buckets that don't cover our own ID are only split at depths that
+	// are a multiple of B
+	B int
+	// each bucket keeps up to K*ReplacementFactor replacement contacts
+	ReplacementFactor int
+}
+
+// DefaultConfig returns the parameters from the Kademlia paper: k=20, b=5.
+func DefaultConfig() Config {
+	return Config{K: KSIZE, B: BSIZE, ReplacementFactor: REPLACEMENT_FACTOR}
+}
+
+// Validate reports the first parameter that is out of range.
+func (c Config) Validate() error {
+	if c.K < 1 {
+		return fmt.Errorf("k must be at least 1, got %d", c.K)
+	}
+	if c.B < 1 {
+		return fmt.Errorf("b must be at least 1, got %d", c.B)
+	}
+	if c.ReplacementFactor < 0 {
+		return fmt.Errorf("replacement factor can't be negative, got %d", c.ReplacementFactor)
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+package routing
+
+// Defaults for Config, as in the Kademlia paper.
+const KSIZE = 20
+const BSIZE = 5
+const REPLACEMENT_FACTOR = 5
@@ -1,30 +1,29 @@
-package main
+package routing
 
 import (
-	"github.com/matheusoliveira/go-ordered-map/omap"
-	//"github.com/amjadjibon/itertools"
-	"time"
-	//"slices"
-	"strings"
-	//"bytes"
 	"math/big"
-	"fmt"
+	"strings"
+	"time"
+
+	"cs249-dht/node"
+	"github.com/matheusoliveira/go-ordered-map/omap"
 )
 
 type KBucket struct {
 	range_lower          *big.Int
 	range_upper          *big.Int
-	nodelist             omap.OMap[string, Node]
+	nodelist             omap.OMap[string, node.Node]
 	last_updated         time.Time
-	replacement_nodelist omap.OMap[string, Node]
+	replacement_nodelist omap.OMap[string, node.Node]
 	max_replacment_nodes int
+	config               Config
 }
 
-func NewKBucket(range_lower *big.Int, range_upper *big.Int) KBucket {
+func NewKBucket(range_lower *big.Int, range_upper *big.Int, config Config) KBucket {
 
 	// make node lists
-	_nodelist := omap.New[string, Node]()
-	_replacement_nodelist := omap.New[string, Node]()
+	_nodelist := omap.New[string, node.Node]()
+	_replacement_nodelist := omap.New[string, node.Node]()
 
 	return KBucket{
 		range_lower,
@@ -32,7 +31,8 @@ func NewKBucket(range_lower *big.Int, range_upper *big.Int) KBucket {
 		_nodelist,
 		time.Now(),
 		_replacement_nodelist,
-		KSIZE * REPLACEMENT_FACTOR,
+		config.K * config.ReplacementFactor,
+		config,
 	}
 }
 
@@ -41,13 +41,13 @@ func (self *KBucket) RefreshLastUpdated() {
 }
 
 func (self *KBucket) Split() (KBucket, KBucket) {
-	midp, mplusone := FindMidpoint(self.range_lower, self.range_upper)
-	first := NewKBucket(self.range_lower, midp)
-	second := NewKBucket(mplusone, self.range_upper)
+	midp, mplusone := node.FindMidpoint(self.range_lower, self.range_upper)
+	first := NewKBucket(self.range_lower, midp, self.config)
+	second := NewKBucket(mplusone, self.range_upper, self.config)
 
 	// transfer nodes by id here to each bucket
 	for it := self.nodelist.Iterator(); it.Next(); {
-		if first.HasInRange(it.Value().nodeID) {
+		if first.HasInRange(it.Value().ID()) {
 			first.nodelist.Put(it.Key(), it.Value())
 		} else {
 			second.nodelist.Put(it.Key(), it.Value())
@@ -56,7 +56,7 @@ func (self *KBucket) Split() (KBucket, KBucket) {
 	}
 
 	for it := self.replacement_nodelist.Iterator(); it.Next(); {
-		if first.HasInRange(it.Value().nodeID) {
+		if first.HasInRange(it.Value().ID()) {
 			first.nodelist.Put(it.Key(), it.Value())
 		} else {
 			second.nodelist.Put(it.Key(), it.Value())
@@ -68,21 +68,21 @@ func (self *KBucket) Split() (KBucket, KBucket) {
 
 }
 
-func (self *KBucket) GetNodes() []Node {
+func (self *KBucket) GetNodes() []node.Node {
 	return omap.IteratorValuesToSlice(self.nodelist.Iterator())
 }
 
-func (self *KBucket) GetReplacementNodes() []Node {
+func (self *KBucket) GetReplacementNodes() []node.Node {
 	return omap.IteratorValuesToSlice(self.replacement_nodelist.Iterator())
 }
 
-func (self *KBucket) AddNode(n Node) bool {
+func (self *KBucket) AddNode(n node.Node) bool {
 	_, found := self.nodelist.Get(n.HexID())
 	if found {
 		// delete the node and re-add if it exists, to preserve the order of last seen
 		self.nodelist.Delete(n.HexID())
 		self.nodelist.Put(n.HexID(), n)
-	} else if self.Len() < KSIZE {
+	} else if self.Len() < self.config.K {
 		//fmt.Println("bucket not yet full, ", n.HexID())
 		self.nodelist.Put(n.HexID(), n)
 	} else {
@@ -97,15 +97,13 @@ func (self *KBucket) AddNode(n Node) bool {
 			self.replacement_nodelist.Delete(oldest_seen)
 		}
 
-		fmt.Println("bucket full, should return false, ", n.HexID())
-
 		return false
 	}
 
 	return true
 }
 
-func (self *KBucket) RemoveNode(n Node) {
+func (self *KBucket) RemoveNode(n node.Node) {
 	_, found := self.replacement_nodelist.Get(n.HexID())
 	if found {
 		self.replacement_nodelist.Delete(n.HexID())
@@ -131,9 +129,9 @@ func (self *KBucket) RemoveNode(n Node) {
 	}
 }
 
-func (self *KBucket) GetNode(nodeID string) Node {
-	node, _ := self.nodelist.Get(nodeID)
-	return node
+func (self *KBucket) GetNode(nodeID string) node.Node {
+	n, _ := self.nodelist.Get(nodeID)
+	return n
 }
 
 func (self *KBucket) IsNewNode(nodeID string) bool {
@@ -147,7 +145,7 @@ func (self *KBucket) HasInRange(nodeID *big.Int) bool {
 }
 
 // TODO double check this is actually the oldest seen
-func (self *KBucket) Head() Node {
+func (self *KBucket) Head() node.Node {
 	head := omap.IteratorValuesToSlice(self.nodelist.Iterator())[0]
 	return head
 }
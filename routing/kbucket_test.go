@@ -1,15 +1,17 @@
-package main
+package routing
 
 import (
-	"testing"
+	"cs249-dht/node"
+
 	"math/big"
+	"testing"
 )
 
 func TestSplit(t *testing.T) {
 
-	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE * 2))
-	n1 := NewNodeFromInt(KSIZE)
-	n2 := NewNodeFromInt(KSIZE + 1)
+	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE*2), DefaultConfig())
+	n1 := node.NewNodeFromInt(KSIZE)
+	n2 := node.NewNodeFromInt(KSIZE + 1)
 
 	bucket.AddNode(n1)
 	bucket.AddNode(n2)
@@ -62,10 +64,10 @@ func TestSplit(t *testing.T) {
 
 func TestSplitNoOverlap(t *testing.T) {
 	upper := big.NewInt(1)
-	upper.Lsh(upper, NODE_ID_BIT_SIZE)
-	bucket := NewKBucket(big.NewInt(0), upper)
+	upper.Lsh(upper, node.NODE_ID_BIT_SIZE)
+	bucket := NewKBucket(big.NewInt(0), upper, DefaultConfig())
 	left, right := bucket.Split()
-	
+
 	got := left.range_upper
 	want := right.range_lower
 
@@ -78,10 +80,10 @@ func TestSplitNoOverlap(t *testing.T) {
 }
 
 func TestAddNode(t *testing.T) {
-	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE * 5))
+	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE*5), DefaultConfig())
 
 	for i := 0; i < KSIZE; i++ {
-		newNode := NewNodeFromInt(int64(i))
+		newNode := node.NewNodeFromInt(int64(i))
 		got := bucket.AddNode(newNode)
 		want := true
 
@@ -90,7 +92,7 @@ func TestAddNode(t *testing.T) {
 		}
 	}
 
-	newNode := NewNodeFromInt(KSIZE)
+	newNode := node.NewNodeFromInt(KSIZE)
 	got := bucket.AddNode(newNode)
 	want := false
 
@@ -100,11 +102,11 @@ func TestAddNode(t *testing.T) {
 }
 
 func TestDoubleAddNode(t *testing.T) {
-	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE * 5))
+	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE*5), DefaultConfig())
 
-	var nodelist [KSIZE]Node
+	var nodelist [KSIZE]node.Node
 	for i := 0; i < KSIZE; i++ {
-		newNode := NewNodeFromInt(int64(i))
+		newNode := node.NewNodeFromInt(int64(i))
 		nodelist[i] = newNode
 	}
 
@@ -136,20 +138,20 @@ func TestDoubleAddNode(t *testing.T) {
 			if node != nodelist[index+1] {
 				t.Errorf("got %q, wanted %q. index = %q", node.HexID(), nodelist[index+1].HexID(), index)
 			}
-		} else if index == KSIZE -1 {
+		} else if index == KSIZE-1 {
 			if node != nodelist[0] {
-				t.Errorf("got %q %q %q, wanted %q %q %q. index = %q", node.HexID(), node.ipAddr, node.port, nodelist[0].HexID(), nodelist[0].ipAddr, nodelist[0].port, index)
+				t.Errorf("got %q %q %q, wanted %q %q %q. index = %q", node.HexID(), node.IP(), node.Port(), nodelist[0].HexID(), nodelist[0].IP(), nodelist[0].Port(), index)
 			}
 		}
 	}
 }
 
 func TestRemoveNode(t *testing.T) {
-	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE + 5))
+	bucket := NewKBucket(big.NewInt(0), big.NewInt(KSIZE+5), DefaultConfig())
 
-	var nodelist [KSIZE + 5]Node
-	for i := 0; i < KSIZE + 5; i++ {
-		newNode := NewNodeFromInt(int64(i))
+	var nodelist [KSIZE + 5]node.Node
+	for i := 0; i < KSIZE+5; i++ {
+		newNode := node.NewNodeFromInt(int64(i))
 		nodelist[i] = newNode
 	}
 
@@ -161,7 +163,7 @@ func TestRemoveNode(t *testing.T) {
 		if node != nodelist[index] {
 			t.Errorf("got %q, wanted %q. index = %q", node.HexID(), nodelist[index].HexID(), index)
 		}
-	}	
+	}
 
 	for index, repl_node := range bucket.GetReplacementNodes() {
 		if repl_node != nodelist[index+KSIZE] {
@@ -176,7 +178,7 @@ func TestRemoveNode(t *testing.T) {
 		if node != nodelist[index] {
 			t.Errorf("got %q, wanted %q. index = %q", node.HexID(), nodelist[index].HexID(), index)
 		}
-	}	
+	}
 
 	for index, repl_node := range bucket.GetReplacementNodes() {
 		if repl_node != nodelist[index+KSIZE] {
@@ -189,7 +191,7 @@ func TestRemoveNode(t *testing.T) {
 	// and removed from the replacement node list
 	bucket.RemoveNode(nodelist[0])
 	for index, node := range bucket.GetNodes() {
-		if index != KSIZE -1 {
+		if index != KSIZE-1 {
 			if node != nodelist[index+1] {
 				t.Errorf("got %q, wanted %q. index = %q", node.HexID(), nodelist[index].HexID(), index)
 			}
@@ -199,7 +201,7 @@ func TestRemoveNode(t *testing.T) {
 			}
 		}
 
-	}	
+	}
 
 	for index, repl_node := range bucket.GetReplacementNodes() {
 		if repl_node != nodelist[index+KSIZE] {
@@ -207,42 +209,42 @@ func TestRemoveNode(t *testing.T) {
 		}
 	}
 
-	if len(bucket.GetReplacementNodes()) != REPLACEMENT_FACTOR - 2 { // removed 2 nodes
-		t.Errorf("got %q, wanted %q", len(bucket.GetReplacementNodes()), REPLACEMENT_FACTOR - 2)
+	if len(bucket.GetReplacementNodes()) != REPLACEMENT_FACTOR-2 { // removed 2 nodes
+		t.Errorf("got %q, wanted %q", len(bucket.GetReplacementNodes()), REPLACEMENT_FACTOR-2)
 	}
 
 }
 
 func TestInRange(t *testing.T) {
-	bucket := NewKBucket(big.NewInt(0), big.NewInt(10))
+	bucket := NewKBucket(big.NewInt(0), big.NewInt(10), DefaultConfig())
 
-	n0 := NewNodeFromInt(0)
-	n5 := NewNodeFromInt(5)
-	n10 := NewNodeFromInt(10)
-	n11 := NewNodeFromInt(11)
+	n0 := node.NewNodeFromInt(0)
+	n5 := node.NewNodeFromInt(5)
+	n10 := node.NewNodeFromInt(10)
+	n11 := node.NewNodeFromInt(11)
 
-	got := bucket.HasInRange(n0.nodeID)
+	got := bucket.HasInRange(n0.ID())
 
 	if got != true {
 		t.Errorf("got %t, wanted %t", got, true)
 	}
 
-	got = bucket.HasInRange(n5.nodeID)
+	got = bucket.HasInRange(n5.ID())
 
 	if got != true {
 		t.Errorf("got %t, wanted %t", got, true)
 	}
 
-	got = bucket.HasInRange(n10.nodeID)
+	got = bucket.HasInRange(n10.ID())
 
 	if got != true {
 		t.Errorf("got %t, wanted %t", got, true)
 	}
 
-	got = bucket.HasInRange(n11.nodeID)
+	got = bucket.HasInRange(n11.ID())
 
 	if got != false {
 		t.Errorf("got %t, wanted %t", got, false)
 	}
 
-}
\ No newline at end of file
+}
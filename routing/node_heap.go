@@ -1,16 +1,17 @@
 // This example demonstrates a distance queue built using the heap interface.
-package main
+package routing
 
 import (
-	//"bytes"
 	"container/heap"
-	"sort"
 	"math/big"
+	"sort"
+
+	"cs249-dht/node"
 )
 
 // An NodeMinHeapItem is something we manage in a distance queue.
 type NodeMinHeapItem struct {
-	node Node // Node in question
+	node node.Node // Node in question
 
 	distance *big.Int // Ordering by distance
 	// The index is needed by update and is maintained by the heap.Interface methods.
@@ -60,12 +61,12 @@ type NodeMinHeapItem struct {
 
 type BoundedNodeHeap struct {
 	items     []*NodeMinHeapItem
-	target    *Node
+	target    *node.Node
 	maxSize   int
 	contacted map[string]struct{}
 }
 
-func NewBoundedNodeHeap(target *Node, maxSize int) *BoundedNodeHeap {
+func NewBoundedNodeHeap(target *node.Node, maxSize int) *BoundedNodeHeap {
 	h := &BoundedNodeHeap{
 		target:    target,
 		maxSize:   maxSize,
@@ -115,12 +116,12 @@ func (h *BoundedNodeHeap) Pop() any {
 }
 
 // check for existence of a node in the heap
-func (h *BoundedNodeHeap) contains(n *Node) bool {
+func (h *BoundedNodeHeap) contains(n *node.Node) bool {
 	for _, it := range h.items {
-		// if bytes.Equal(it.node.nodeID, n.nodeID) {
+		// if bytes.Equal(it.node.ID(), n.ID()) {
 		// 	return true
 		// }
-		if it.node.nodeID.Cmp(n.nodeID) == 0 {
+		if it.node.ID().Cmp(n.ID()) == 0 {
 			return true
 		}
 	}
@@ -128,7 +129,7 @@ func (h *BoundedNodeHeap) contains(n *Node) bool {
 }
 
 // AddNode adds a node to the bounded heap if it's not already present
-func (h *BoundedNodeHeap) AddNode(n *Node) {
+func (h *BoundedNodeHeap) AddNode(n *node.Node) {
 	if h.contains(n) {
 		return
 	}
@@ -163,12 +164,18 @@ func (h *BoundedNodeHeap) AddNode(n *Node) {
 	})
 }
 
-func (h *BoundedNodeHeap) MarkContacted(n *Node) {
+func (h *BoundedNodeHeap) MarkContacted(n *node.Node) {
 	h.contacted[n.HexID()] = struct{}{}
 }
 
-func (h *BoundedNodeHeap) GetUncontacted() []*Node {
-	var out []*Node
+// Contacted reports whether n was marked contacted.
+func (h *BoundedNodeHeap) Contacted(n *node.Node) bool {
+	_, ok := h.contacted[n.HexID()]
+	return ok
+}
+
+func (h *BoundedNodeHeap) GetUncontacted() []*node.Node {
+	var out []*node.Node
 	for _, it := range h.items {
 		if _, ok := h.contacted[it.node.HexID()]; !ok {
 			out = append(out, &it.node)
@@ -181,7 +188,7 @@ func (h *BoundedNodeHeap) HaveContactedAll() bool {
 	return len(h.GetUncontacted()) == 0
 }
 
-func (h *BoundedNodeHeap) Closest() []*Node {
+func (h *BoundedNodeHeap) Closest() []*node.Node {
 	tmp := make([]*NodeMinHeapItem, len(h.items))
 	copy(tmp, h.items)
 
@@ -190,7 +197,7 @@ func (h *BoundedNodeHeap) Closest() []*Node {
 		return tmp[i].distance.Cmp(tmp[j].distance) < 0
 	})
 
-	out := make([]*Node, 0, len(tmp))
+	out := make([]*node.Node, 0, len(tmp))
 	for _, it := range tmp {
 		out = append(out, &it.node)
 	}
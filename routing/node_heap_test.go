@@ -1,15 +1,17 @@
-package main
+package routing
 
 import (
+	"cs249-dht/node"
+
 	"testing"
 )
 
 func TestHeap(t *testing.T) {
 
 	// generate the nodes
-	n1, _ := NewNodeFromIPAndport("192.0.2.10", 4001)
-	n2, _ := NewNodeFromIPAndport("2001:db8::1", 4001)
-	n3, _ := NewNodeFromIPAndport("2001:db8::1", 4002)
+	n1, _ := node.NewNodeFromIPAndport("192.0.2.10", 4001)
+	n2, _ := node.NewNodeFromIPAndport("2001:db8::1", 4001)
+	n3, _ := node.NewNodeFromIPAndport("2001:db8::1", 4002)
 
 	// Create a distance queue, put the nodes in it, and
 	// establish the distance queue (heap) invariants.
@@ -70,12 +72,12 @@ func TestHeap(t *testing.T) {
 	}
 
 	// Take the NodeMinHeapItems out; they arrive in decreasing distance order.
-	for i, node := range closest {
+	for i, n := range closest {
 		// nmhi := heap.Pop(&nmh).(*NodeMinHeapItem)
-		// got_node := NodeIDToHex(nmhi.node.nodeID)
+		// got_node := NodeIDToHex(nmhi.node.ID())
 		// got_dist := NodeIDToHex(nmhi.distance)
-		got_node := NodeIDToHex(node.nodeID)
-		got_dist := NodeIDToHex(node.GetXorDistance(&n1))
+		got_node := node.NodeIDToHex(n.ID())
+		got_dist := node.NodeIDToHex(n.GetXorDistance(&n1))
 
 		if got_node != wanted_node[i] {
 			t.Errorf("got node id: %q, wanted %q", got_node, wanted_node)
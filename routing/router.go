@@ -0,0 +1,317 @@
+// Package routing holds the Kademlia routing table: k-buckets, the Router
+// that splits them, and the bounded heap lookups use to track the closest
+// nodes seen.
+package routing
+
+import (
+	"log/slog"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"cs249-dht/node"
+)
+
+type Router struct {
+	node   node.Node
+	config Config
+	// protocol Protocol
+	buckets []*KBucket
+	// guards buckets, lookups may add contacts from several goroutines
+	mu *sync.Mutex
+	// discards everything unless SetLogger was called
+	logger *slog.Logger
+
+	// OnNewContact, if set, is called whenever AddContact admits a node the
+	// table didn't hold before. It runs outside the router lock.
+	OnNewContact func(node.Node)
+}
+
+func NewRouter(self node.Node, config Config) Router {
+	router := Router{
+		node:    self,
+		config:  config,
+		buckets: nil,
+		mu:      &sync.Mutex{},
+		logger:  slog.New(slog.DiscardHandler),
+	}
+	router.FlushCache()
+
+	return router
+}
+
+// SetLogger makes the router log contacts coming and going to logger.
+func (self *Router) SetLogger(logger *slog.Logger) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.logger = logger
+}
+
+func (self *Router) FlushCache() {
+	lower := big.NewInt(0)
+	upper := big.NewInt(1)
+	upper.Lsh(upper, node.NODE_ID_BIT_SIZE)
+	all_encompassing_bucket := NewKBucket(lower, upper, self.config)
+	self.buckets = append(self.buckets, &all_encompassing_bucket)
+}
+
+func (self *Router) SplitBucket(index int) {
+	first, second := self.buckets[index].Split()
+	self.buckets[index] = &first
+	self.buckets = slices.Insert(self.buckets, index, &second)
+}
+
+// Size returns the number of contacts held across all buckets.
+func (self *Router) Size() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	size := 0
+	for _, bucket := range self.buckets {
+		size += bucket.Len()
+	}
+	return size
+}
+
+// Contacts returns every contact in the table.
+func (self *Router) Contacts() []node.Node {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	var contacts []node.Node
+	for _, bucket := range self.buckets {
+		contacts = append(contacts, bucket.GetNodes()...)
+	}
+	return contacts
+}
+
+// BucketInfo is a snapshot of one k-bucket: the ID range it covers, its
+// contacts oldest first, and the replacements waiting for a slot.
+type BucketInfo struct {
+	Lower, Upper *big.Int
+	LastUpdated  time.Time
+	Contacts     []node.Node
+	Replacements []node.Node
+}
+
+// Buckets returns a snapshot of every bucket, lowest range first.
+func (self *Router) Buckets() []BucketInfo {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	infos := make([]BucketInfo, 0, len(self.buckets))
+	for _, bucket := range self.buckets {
+		infos = append(infos, BucketInfo{
+			Lower:        new(big.Int).Set(bucket.range_lower),
+			Upper:        new(big.Int).Set(bucket.range_upper),
+			LastUpdated:  bucket.last_updated,
+			Contacts:     bucket.GetNodes(),
+			Replacements: bucket.GetReplacementNodes(),
+		})
+	}
+	slices.SortFunc(infos, func(a, b BucketInfo) int { return a.Lower.Cmp(b.Lower) })
+	return infos
+}
+
+func (self *Router) LonelyBuckets() []*KBucket {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	now := time.Now()
+	// find buckets which haven't been updated since an hour
+	hourago := now.Add(time.Hour * -1)
+
+	lonelyBuckets := make([]*KBucket, 0, 1)
+
+	for _, bucket := range self.buckets {
+		if bucket.last_updated.Before(hourago) {
+			lonelyBuckets = append(lonelyBuckets, bucket)
+		}
+	}
+
+	return lonelyBuckets
+}
+
+func (self *Router) IsNewNode(n node.Node) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.isNewNode(n)
+}
+
+func (self *Router) isNewNode(n node.Node) bool {
+	index := self.GetBucketFor(n)
+	if index == -1 {
+		return true
+	}
+	return self.buckets[index].IsNewNode(n.HexID())
+}
+
+func (self *Router) RemoveContact(n node.Node) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	index := self.GetBucketFor(n)
+	if index == -1 {
+		return
+	}
+	self.buckets[index].RemoveNode(n)
+}
+
+func (self *Router) AddContact(n node.Node) {
+	// peers echo us back in their node lists; we are never our own contact
+	if n.ID() != nil && self.node.ID() != nil && n.ID().Cmp(self.node.ID()) == 0 {
+		return
+	}
+
+	self.mu.Lock()
+	wasNew := self.isNewNode(n)
+	self.addContact(n)
+	admitted := wasNew && !self.isNewNode(n)
+	hook := self.OnNewContact
+	self.mu.Unlock()
+
+	if admitted && hook != nil {
+		hook(n)
+	}
+}
+
+// LoadContacts adds contacts remembered from an earlier run. They are not
+// new to the network, so OnNewContact is not called for them.
+func (self *Router) LoadContacts(contacts []node.Node) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for _, n := range contacts {
+		if n.ID() == nil || (self.node.ID() != nil && n.ID().Cmp(self.node.ID()) == 0) {
+			continue
+		}
+		self.addContact(n)
+	}
+}
+
+func (self *Router) addContact(n node.Node) {
+	index := self.GetBucketFor(n)
+	if index == -1 {
+		return
+	}
+	bucket := self.buckets[index]
+
+	if bucket.AddNode(n) {
+		self.logger.Debug("added contact", "peer", n.HexID())
+		return
+	}
+
+	// if we are here, the bucket was full and addNode failed
+	// split the bucket if it has the router node in its range
+	// or if its depth is not congruent to 0, mod B
+
+	if bucket.HasInRange(self.node.ID()) || bucket.Depth()%self.config.B != 0 {
+		self.logger.Debug("bucket full, splitting", "bucket", index, "depth", bucket.Depth())
+		self.SplitBucket(index)
+		self.addContact(n)
+	} else {
+		//TODO: ping the head of the bucket list
+		self.logger.Debug("bucket full, kept contact as a replacement", "peer", n.HexID(), "bucket", index)
+	}
+}
+
+func (self *Router) GetBucketFor(n node.Node) int {
+	for index, bucket := range self.buckets {
+		if bucket.HasInRange(n.ID()) {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (self *Router) FindNeighbors(n node.Node, alpha int) []*node.Node {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	heapsize := alpha
+	if alpha == -1 || alpha <= 0 {
+		heapsize = self.config.K
+	}
+
+	nodes := NewBoundedNodeHeap(&n, heapsize)
+
+	traverser := NewTraversal(self, n)
+
+	for {
+		neighbor, done := traverser.Next()
+		if done {
+			break
+		}
+
+		if neighbor.ID() == nil || neighbor.HexID() == n.HexID() {
+			continue
+		}
+
+		nodes.AddNode(&neighbor)
+		if nodes.Len() == heapsize {
+			break
+		}
+	}
+
+	return nodes.Closest()
+}
+
+type Traversal struct {
+	currentNodes []node.Node
+	leftBuckets  []*KBucket
+	rightBuckets []*KBucket
+	curr_index   int
+	left_index   int
+	right_index  int
+	isLeft       bool
+}
+
+func NewTraversal(router *Router, startNode node.Node) *Traversal {
+	index := router.GetBucketFor(startNode)
+	router.buckets[index].RefreshLastUpdated()
+	currentNodes := router.buckets[index].GetNodes()
+	leftBuckets := router.buckets[:index]
+	rightBuckets := router.buckets[index+1:]
+
+	t := &Traversal{
+		currentNodes: currentNodes,
+		leftBuckets:  leftBuckets,
+		rightBuckets: rightBuckets,
+		curr_index:   len(currentNodes) - 1,
+		left_index:   len(leftBuckets) - 1, // start at last left bucket
+		right_index:  0,                    // start at first right bucket
+		isLeft:       true,
+	}
+
+	return t
+}
+
+func (self *Traversal) Next() (node.Node, bool) {
+	if self.curr_index >= 0 {
+		res := self.currentNodes[self.curr_index]
+		self.curr_index--
+		return res, false
+	}
+
+	if self.isLeft && self.left_index >= 0 {
+		self.currentNodes = self.leftBuckets[self.left_index].GetNodes()
+		self.left_index--
+		self.curr_index = len(self.currentNodes) - 1
+		self.isLeft = false
+		return self.Next()
+	}
+
+	if self.right_index < len(self.rightBuckets) {
+		self.currentNodes = self.rightBuckets[self.right_index].GetNodes()
+		self.right_index++
+		self.curr_index = len(self.currentNodes) - 1
+		self.isLeft = true
+		return self.Next()
+	}
+
+	return node.Node{}, true
+
+}
@@ -1,23 +1,26 @@
-package main
+package routing
 
 import (
-	"testing"
-	"math/big"
 	"fmt"
+	"math/big"
+	"testing"
+
+	"cs249-dht/node"
 )
 
 func TestRouter(t *testing.T) {
 	fmt.Println("testing router #######3")
-	our_node := NewNodeFromInt(1)
-	router := NewRouter(our_node)
+	our_node := node.NewNodeFromInt(1)
+	// small buckets, so three contacts force a split
+	router := NewRouter(our_node, Config{K: 2, B: BSIZE, ReplacementFactor: REPLACEMENT_FACTOR})
 
-	contact := NewNodeFromInt(2)
+	contact := node.NewNodeFromInt(2)
 	router.AddContact(contact)
 
-	contact = NewNodeFromInt(3)
+	contact = node.NewNodeFromInt(3)
 	router.AddContact(contact)
 
-	contact = NewNodeFromInt(4)
+	contact = node.NewNodeFromInt(4)
 	router.AddContact(contact)
 
 	if len(router.buckets) != 2 {
@@ -33,37 +36,37 @@ func TestRouter(t *testing.T) {
 
 func TestTraversal(t *testing.T) {
 
-	var nodes [10]Node
+	var nodes [10]node.Node
 	for i := 0; i < 10; i++ {
-		newNode := NewNodeFromInt(int64(i))
+		newNode := node.NewNodeFromInt(int64(i))
 		nodes[i] = newNode
 	}
 
 	var buckets []*KBucket
 
 	for i := 0; i < 5; i++ {
-		bucket := NewKBucket(big.NewInt(int64(2 * i)), big.NewInt(int64(2 * i + 1)))
-		bucket.AddNode(nodes[2 * i])
-		bucket.AddNode(nodes[2 * i + 1])
+		bucket := NewKBucket(big.NewInt(int64(2*i)), big.NewInt(int64(2*i+1)), DefaultConfig())
+		bucket.AddNode(nodes[2*i])
+		bucket.AddNode(nodes[2*i+1])
 		buckets = append(buckets, &bucket)
 	}
 
-	our_node := NewNodeFromInt(20)
-	router := NewRouter(our_node)
+	our_node := node.NewNodeFromInt(20)
+	router := NewRouter(our_node, DefaultConfig())
 
 	// replace with test buckets
 	router.buckets = buckets
 
-	expected_nodes := []Node {nodes[5], nodes[4], nodes[3], nodes[2], nodes[7], nodes[6], nodes[1], nodes[0], nodes[9], nodes[8]}
+	expected_nodes := []node.Node{nodes[5], nodes[4], nodes[3], nodes[2], nodes[7], nodes[6], nodes[1], nodes[0], nodes[9], nodes[8]}
 
 	start_node := nodes[4]
 	traverser := NewTraversal(&router, start_node)
 
 	neighbor, isComplete := traverser.Next()
-	index := 0;
+	index := 0
 
 	for !isComplete {
-		
+
 		if neighbor.HexID() != expected_nodes[index].HexID() {
 			t.Errorf("got %q, wanted %q", neighbor.HexID(), expected_nodes[index].HexID())
 		}
@@ -72,4 +75,4 @@ func TestTraversal(t *testing.T) {
 		index++
 	}
 
-}
\ No newline at end of file
+}
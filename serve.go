@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cs249-dht/control"
+	"cs249-dht/node"
+	"cs249-dht/server"
+)
+
+// serve runs a node until it is interrupted, doing the one-off operations
+// its flags ask for once it has joined.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	isBootstrap := fs.Bool("b", false, "is boostrap node")
+	configFile := fs.String("config", "", "JSON, YAML or TOML file to read settings from; DHT_* variables and flags override it")
+	configFlags := server.BindConfigFlags(fs)
+	apiAddr := fs.String("api", "", "serve the HTTP control API on this loopback address or unix:/path socket, e.g. 127.0.0.1:8190")
+	interactive := fs.Bool("interactive", false, "run a command prompt on the node once it has started; quit shuts it down")
+
+	lookupTargetHex := fs.String("lookup", "", "hex node ID to lookup")
+	lookupPaths := fs.Int("paths", 1, "number of disjoint paths for -lookup (S/Kademlia), 1 = plain lookup")
+
+	putImmutable := fs.String("put-immutable", "", "value to publish under its own SHA-256 hash")
+	getImmutable := fs.String("get-immutable", "", "hex SHA-256 key of a content-addressed value to fetch")
+
+	putBlobFile := fs.String("put-blob", "", "file to store as a chunked blob")
+	getBlobKey := fs.String("get-blob", "", "manifest key of a blob to fetch")
+	blobOut := fs.String("out", "", "where -get-blob and -get-erasure write the value (default: stdout)")
+	putErasureKey := fs.String("put-erasure", "", "key to store the -in file under, Reed-Solomon coded into fragments")
+	erasureIn := fs.String("in", "", "file -put-erasure reads the value from")
+	getErasureKey := fs.String("get-erasure", "", "key of an erasure-coded value to fetch")
+	dataShards := fs.Int("data-shards", server.ERASURE_DATA_SHARDS, "fragments needed to rebuild an erasure-coded value")
+	totalShards := fs.Int("total-shards", server.ERASURE_TOTAL_SHARDS, "fragments an erasure-coded value is split into")
+	getKey := fs.String("get", "", "look up the value stored under the given key")
+	deleteKey := fs.String("delete", "", "retract a value this node published, leaving tombstones on its replicas")
+
+	announceKey := fs.String("announce", "", "announce this node as a provider of the given key")
+	providersKey := fs.String("providers", "", "list the providers of the given key")
+
+	mutableKeyFile := fs.String("mutable-key", "mutable.key", "file holding the hex Ed25519 seed used to sign mutable items (created if missing)")
+	putMutable := fs.String("put-mutable", "", "value to publish as a signed mutable item")
+	getMutable := fs.String("get-mutable", "", "hex public key of a mutable item to fetch")
+	salt := fs.String("salt", "", "salt for -put-mutable / -get-mutable")
+	seq := fs.Int64("seq", 1, "sequence number for -put-mutable")
+	cas := fs.Int64("cas", -1, "only replace the item if its current sequence number is this, -1 = no check")
+	timeout := fs.Duration("timeout", 0, "give up on joining and on the requested operations after this long, 0 = no limit")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+
+	fs.Parse(args)
+
+	cfg, err := server.LoadConfig(*configFile, os.Environ(), configFlags)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if *isBootstrap {
+		cfg.Bootstrap = nil
+	} else if len(cfg.Bootstrap) == 0 {
+		cfg.Bootstrap = []string{"127.0.0.1:8090"}
+	}
+
+	// the console shows the node's log records between its own output
+	var console *control.Console
+	if *interactive {
+		console = control.NewConsole(control.Local{}, os.Stdout)
+		console.Level.Set(cfg.Log.Level)
+		cfg.Logger = server.NewLogger(console, cfg.Log.Format, console.Level)
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("Error creating server: %v", err)
+	}
+
+	// SIGINT/SIGTERM cancel whatever is running and then shut the node down
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	ctx := sigCtx
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if !*isBootstrap {
+		fmt.Printf("Starting JOINING node on port %d\n", cfg.Port)
+		if err := srv.Start(ctx); err != nil {
+			log.Fatalf("Error joining network: %v", err)
+		}
+		fmt.Printf("Joined network, routing table holds %d nodes\n", srv.Router.Size())
+
+		// targetID := ln.Self.ID() // e.g. lookup our own ID as a test
+		// nodes, err := ln.LookupNodes(targetID)
+
+		// If user requested a lookup, do one
+		if *lookupTargetHex != "" {
+			targetID := new(big.Int)
+			if _, ok := targetID.SetString(*lookupTargetHex, 16); !ok {
+				log.Fatalf("invalid lookup target hex: %s", *lookupTargetHex)
+			}
+
+			fmt.Printf("Running LookupNodes for targetID=%s...\n", *lookupTargetHex)
+			var nodes []node.Node
+			var err error
+			if *lookupPaths > 1 {
+				nodes, err = srv.LookupNodesDisjoint(ctx, targetID, *lookupPaths)
+			} else {
+				nodes, err = srv.LookupNodes(ctx, targetID)
+			}
+			if err != nil {
+				fmt.Printf("LookupNodes error: %v\n", err)
+			} else {
+				fmt.Println("LookupNodes returned:")
+				for _, n := range nodes {
+					fmt.Printf("- %s:%d id=%s\n", n.IP(), n.Port(), n.HexID())
+				}
+			}
+		}
+
+		if *putBlobFile != "" {
+			data, err := os.ReadFile(*putBlobFile)
+			if err != nil {
+				log.Fatalf("reading blob: %v", err)
+			}
+			key, err := srv.PutBlob(ctx, data, 0)
+			if err != nil {
+				fmt.Printf("PutBlob error: %v\n", err)
+			} else {
+				fmt.Printf("Stored %d-byte blob, manifest key %s\n", len(data), key)
+			}
+		}
+
+		if *getBlobKey != "" {
+			data, err := srv.GetBlob(ctx, *getBlobKey, func(p server.BlobProgress) {
+				fmt.Fprintf(os.Stderr, "\rfetched %d/%d chunks (%d/%d bytes)",
+					p.ChunksDone, p.ChunksTotal, p.BytesDone, p.BytesTotal)
+			})
+			fmt.Fprintln(os.Stderr)
+			switch {
+			case err != nil:
+				fmt.Printf("GetBlob error: %v\n", err)
+			case *blobOut != "":
+				if err := os.WriteFile(*blobOut, data, 0644); err != nil {
+					log.Fatalf("writing blob: %v", err)
+				}
+			default:
+				os.Stdout.Write(data)
+			}
+		}
+
+		if *putErasureKey != "" {
+			data, err := os.ReadFile(*erasureIn)
+			if err != nil {
+				log.Fatalf("reading -in: %v", err)
+			}
+			manifest, err := srv.PutErasureCoded(ctx, *putErasureKey, data, *dataShards, *totalShards)
+			if err != nil {
+				fmt.Printf("PutErasureCoded error: %v\n", err)
+			} else {
+				fmt.Printf("Stored %d bytes under %q as %d fragments, any %d rebuild it\n",
+					len(data), *putErasureKey, manifest.Total, manifest.Data)
+			}
+		}
+
+		if *getErasureKey != "" {
+			data, err := srv.GetErasureCoded(ctx, *getErasureKey)
+			switch {
+			case err != nil:
+				fmt.Printf("GetErasureCoded error: %v\n", err)
+			case *blobOut != "":
+				if err := os.WriteFile(*blobOut, data, 0644); err != nil {
+					log.Fatalf("writing value: %v", err)
+				}
+			default:
+				os.Stdout.Write(data)
+			}
+		}
+
+		if *getKey != "" {
+			result, err := srv.ReadValue(ctx, *getKey)
+			if err != nil && len(result.Replies) == 0 {
+				fmt.Printf("ReadValue error: %v\n", err)
+			} else {
+				if err != nil {
+					fmt.Printf("ReadValue warning: %v\n", err)
+				}
+				fmt.Printf("Value of %q version %s (from %d replicas, repaired %d): %s\n",
+					*getKey, result.Winner.Version, len(result.Replies), len(result.Repaired), result.Value)
+				if result.Conflicting {
+					fmt.Println("Warning: replicas hold concurrent versions of this key")
+				}
+			}
+		}
+
+		if *deleteKey != "" {
+			n, err := srv.Delete(ctx, *deleteKey)
+			if err != nil {
+				fmt.Printf("Delete error: %v\n", err)
+			} else {
+				fmt.Printf("Deleted key %q from %d nodes\n", *deleteKey, n)
+			}
+		}
+
+		if *announceKey != "" {
+			n, err := srv.Announce(ctx, *announceKey, server.PROVIDER_TTL)
+			if err != nil {
+				fmt.Printf("Announce error: %v\n", err)
+			} else {
+				fmt.Printf("Announced key %q to %d nodes\n", *announceKey, n)
+			}
+		}
+
+		if *providersKey != "" {
+			recs, err := srv.GetProviders(ctx, *providersKey)
+			if err != nil {
+				fmt.Printf("GetProviders error: %v\n", err)
+			} else {
+				fmt.Printf("Providers of %q:\n", *providersKey)
+				for _, rec := range recs {
+					fmt.Printf("- %s:%d id=%s expires=%s\n", rec.IP, rec.Port, rec.ID, rec.ExpiresAt.Format(time.RFC3339))
+				}
+			}
+		}
+
+		if *putImmutable != "" {
+			key, err := srv.PutImmutable(ctx, []byte(*putImmutable))
+			if err != nil {
+				fmt.Printf("PutImmutable error: %v\n", err)
+			} else {
+				fmt.Printf("Stored immutable value under key %s\n", key)
+			}
+		}
+
+		if *getImmutable != "" {
+			value, err := srv.GetImmutable(ctx, *getImmutable)
+			if err != nil {
+				fmt.Printf("GetImmutable error: %v\n", err)
+			} else {
+				fmt.Printf("Immutable value: %s\n", value)
+			}
+		}
+
+		if *putMutable != "" {
+			priv, err := loadMutableKey(*mutableKeyFile)
+			if err != nil {
+				log.Fatalf("Error loading mutable key: %v", err)
+			}
+			var casSeq *int64
+			if *cas >= 0 {
+				casSeq = cas
+			}
+			key, err := srv.PutMutable(ctx, priv, []byte(*salt), []byte(*putMutable), *seq, casSeq)
+			if err != nil {
+				fmt.Printf("PutMutable error: %v\n", err)
+			} else {
+				fmt.Printf("Stored mutable item seq=%d under key %s (public key %s)\n",
+					*seq, key, hex.EncodeToString(priv.Public().(ed25519.PublicKey)))
+			}
+		}
+
+		if *getMutable != "" {
+			pub, err := hex.DecodeString(*getMutable)
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				log.Fatalf("invalid public key hex: %s", *getMutable)
+			}
+			item, err := srv.GetMutable(ctx, ed25519.PublicKey(pub), []byte(*salt))
+			if err != nil {
+				fmt.Printf("GetMutable error: %v\n", err)
+			} else {
+				fmt.Printf("Mutable item seq=%d: %s\n", item.Seq, item.Value)
+			}
+		}
+	} else {
+		fmt.Printf("Starting BOOTSTRAP node on port %d\n", cfg.Port)
+		if err := srv.Start(ctx); err != nil {
+			log.Fatalf("Error starting server: %v", err)
+		}
+	}
+
+	var apiServer *http.Server
+	if *apiAddr != "" {
+		listener, err := control.Listen(*apiAddr)
+		if err != nil {
+			log.Fatalf("Error starting control API: %v", err)
+		}
+		fmt.Printf("Control API listening on %s\n", listener.Addr())
+		apiServer = &http.Server{Handler: control.Handler(control.Local{Server: srv})}
+		go apiServer.Serve(listener)
+	}
+
+	// Handle RPCs until we are told to stop
+	if *interactive {
+		console.Local = control.Local{Server: srv}
+		if err := console.Run(sigCtx, os.Stdin); err != nil {
+			fmt.Printf("Console error: %v\n", err)
+		}
+	} else {
+		<-sigCtx.Done()
+	}
+	stopSignals()
+	fmt.Println("Shutting down...")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancelShutdown()
+	if apiServer != nil {
+		apiServer.Shutdown(shutdownCtx)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("Error shutting down: %v", err)
+	}
+
+}
+
+// loadMutableKey reads the hex Ed25519 seed in path, generating and saving a
+// new one if the file does not exist yet.
+func loadMutableKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: expected a %d-byte hex seed", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cs249-dht/storage"
+)
+
+var ErrBlobIncomplete = errors.New("blob download incomplete")
+
+// BlobChunk is one entry of a manifest: the content hash a chunk is stored
+// under, and its length.
+type BlobChunk struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// BlobManifest describes a blob split into content-addressed chunks. It is
+// itself stored as an immutable value, so its key pins the whole blob.
+type BlobManifest struct {
+	Size      int64       `json:"size"`
+	ChunkSize int         `json:"chunk_size"`
+	Chunks    []BlobChunk `json:"chunks"`
+}
+
+// BlobProgress is reported after every chunk a download gets.
+type BlobProgress struct {
+	ChunksDone  int
+	ChunksTotal int
+	BytesDone   int64
+	BytesTotal  int64
+}
+
+// PutBlob splits data into chunkSize chunks (0 = BLOB_CHUNK_SIZE), stores
+// each under its hash, BLOB_PARALLELISM at a time, and then publishes the
+// manifest. Returns the manifest key.
+func (ln *Server) PutBlob(ctx context.Context, data []byte, chunkSize int) (string, error) {
+	if chunkSize <= 0 {
+		chunkSize = BLOB_CHUNK_SIZE
+	}
+
+	manifest := BlobManifest{Size: int64(len(data)), ChunkSize: chunkSize}
+	var chunks [][]byte
+	for off := 0; off < len(data); off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[off:end]
+		chunks = append(chunks, chunk)
+		manifest.Chunks = append(manifest.Chunks, BlobChunk{Hash: storage.ImmutableKey(chunk), Size: len(chunk)})
+	}
+
+	errs := make([]error, len(chunks))
+	forEachParallel(len(chunks), func(i int) {
+		_, errs[i] = ln.PutImmutable(ctx, chunks[i])
+	})
+	for i, err := range errs {
+		if err != nil {
+			return "", ctxOr(ctx, fmt.Errorf("PutBlob: chunk %d: %w", i, err))
+		}
+	}
+
+	encoded, err := json.Marshal(&manifest)
+	if err != nil {
+		return "", fmt.Errorf("PutBlob: encoding manifest: %w", err)
+	}
+	key, err := ln.PutImmutable(ctx, encoded)
+	if err != nil {
+		return "", ctxOr(ctx, fmt.Errorf("PutBlob: manifest: %w", err))
+	}
+	return key, nil
+}
+
+// BlobDownload is a blob being fetched. Chunks that arrived are kept, so a
+// failed FetchBlob can be called again and only asks for what is missing.
+type BlobDownload struct {
+	Key      string
+	Manifest BlobManifest
+
+	mu     sync.Mutex
+	chunks [][]byte // nil until fetched and verified
+}
+
+// NewBlobDownload fetches and checks the manifest stored under key.
+func (ln *Server) NewBlobDownload(ctx context.Context, key string) (*BlobDownload, error) {
+	encoded, err := ln.GetImmutable(ctx, key)
+	if err != nil {
+		return nil, ctxOr(ctx, fmt.Errorf("fetching manifest: %w", err))
+	}
+
+	var manifest BlobManifest
+	if err := json.Unmarshal(encoded, &manifest); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+	var total int64
+	for _, c := range manifest.Chunks {
+		total += int64(c.Size)
+	}
+	if total != manifest.Size {
+		return nil, fmt.Errorf("manifest chunks add up to %d bytes, not %d", total, manifest.Size)
+	}
+
+	return &BlobDownload{
+		Key:      key,
+		Manifest: manifest,
+		chunks:   make([][]byte, len(manifest.Chunks)),
+	}, nil
+}
+
+// Missing returns the indexes of chunks not fetched yet.
+func (d *BlobDownload) Missing() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var missing []int
+	for i, c := range d.chunks {
+		if c == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+func (d *BlobDownload) progress() BlobProgress {
+	p := BlobProgress{ChunksTotal: len(d.chunks), BytesTotal: d.Manifest.Size}
+	for _, c := range d.chunks {
+		if c != nil {
+			p.ChunksDone++
+			p.BytesDone += int64(len(c))
+		}
+	}
+	return p
+}
+
+// Bytes assembles the blob, or fails with ErrBlobIncomplete if chunks are missing.
+func (d *BlobDownload) Bytes() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make([]byte, 0, d.Manifest.Size)
+	for i, c := range d.chunks {
+		if c == nil {
+			return nil, fmt.Errorf("%w: chunk %d missing", ErrBlobIncomplete, i)
+		}
+		out = append(out, c...)
+	}
+	return out, nil
+}
+
+// FetchBlob fetches every missing chunk of d, BLOB_PARALLELISM at a time,
+// checking each against its hash and size. progress, if given, is called
+// after each chunk arrives, never concurrently. If some chunks can't be found the error wraps
+// ErrBlobIncomplete and calling FetchBlob again resumes the download.
+func (ln *Server) FetchBlob(ctx context.Context, d *BlobDownload, progress func(BlobProgress)) error {
+	missing := d.Missing()
+
+	var progressMu sync.Mutex
+	errs := make([]error, len(missing))
+	forEachParallel(len(missing), func(j int) {
+		i := missing[j]
+		want := d.Manifest.Chunks[i]
+
+		chunk, err := ln.GetImmutable(ctx, want.Hash)
+		if err == nil && len(chunk) != want.Size {
+			err = fmt.Errorf("got %d bytes, manifest says %d", len(chunk), want.Size)
+		}
+		if err != nil {
+			errs[j] = fmt.Errorf("chunk %d: %w", i, err)
+			return
+		}
+
+		progressMu.Lock()
+		defer progressMu.Unlock()
+
+		d.mu.Lock()
+		d.chunks[i] = chunk
+		p := d.progress()
+		d.mu.Unlock()
+
+		if progress != nil {
+			progress(p)
+		}
+	})
+
+	failed := 0
+	var lastErr error
+	for _, err := range errs {
+		if err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		return ctxOr(ctx, fmt.Errorf("%w: %d of %d chunks failed, last: %v",
+			ErrBlobIncomplete, failed, len(d.Manifest.Chunks), lastErr))
+	}
+	return nil
+}
+
+// GetBlob fetches the whole blob whose manifest is stored under key.
+func (ln *Server) GetBlob(ctx context.Context, key string, progress func(BlobProgress)) ([]byte, error) {
+	d, err := ln.NewBlobDownload(ctx, key)
+	if err != nil {
+		return nil, ctxOr(ctx, fmt.Errorf("GetBlob: %w", err))
+	}
+	if err := ln.FetchBlob(ctx, d, progress); err != nil {
+		return nil, ctxOr(ctx, fmt.Errorf("GetBlob: %w", err))
+	}
+	return d.Bytes()
+}
+
+// forEachParallel calls f(0..n-1) with at most BLOB_PARALLELISM running at once.
+func forEachParallel(n int, f func(i int)) {
+	sem := make(chan struct{}, BLOB_PARALLELISM)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
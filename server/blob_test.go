@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestBlobRoundTrip(t *testing.T) {
+	startHonestNode(t, 20601)
+	publisher := startHonestNode(t, 20602)
+	client := startHonestNode(t, 20603)
+	publisher.PingBootstrap(context.Background(), "127.0.0.1", 20601)
+	client.PingBootstrap(context.Background(), "127.0.0.1", 20601)
+	waitForRouting()
+
+	data := make([]byte, 10000)
+	rand.Read(data)
+
+	key, err := publisher.PutBlob(context.Background(), data, 1024)
+	if err != nil {
+		t.Fatalf("PutBlob: %v", err)
+	}
+
+	var last BlobProgress
+	calls := 0
+	got, err := client.GetBlob(context.Background(), key, func(p BlobProgress) {
+		calls++
+		if p.ChunksDone > last.ChunksDone {
+			last = p
+		}
+	})
+	if err != nil {
+		t.Fatalf("GetBlob: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes back, wanted the original %d", len(got), len(data))
+	}
+	if calls != 10 || last.ChunksDone != 10 || last.BytesDone != int64(len(data)) {
+		t.Errorf("got %d progress calls ending at %+v, wanted 10 ending with everything done", calls, last)
+	}
+}
+
+func TestBlobResume(t *testing.T) {
+	servers := []*Server{startHonestNode(t, 20604), startHonestNode(t, 20605)}
+	servers[1].PingBootstrap(context.Background(), "127.0.0.1", 20604)
+	waitForRouting()
+
+	data := make([]byte, 3000)
+	rand.Read(data)
+	key, err := servers[1].PutBlob(context.Background(), data, 1000)
+	if err != nil {
+		t.Fatalf("PutBlob: %v", err)
+	}
+
+	client := startHonestNode(t, 20606)
+	client.PingBootstrap(context.Background(), "127.0.0.1", 20604)
+
+	d, err := client.NewBlobDownload(context.Background(), key)
+	if err != nil {
+		t.Fatalf("NewBlobDownload: %v", err)
+	}
+
+	// lose one chunk everywhere, including the copy handed off to the client
+	lost := d.Manifest.Chunks[1].Hash
+	var removed [][]byte
+	for _, s := range append(servers, client) {
+		if v, ok := s.Store.Get(lost); ok {
+			removed = append(removed, v.Value)
+			s.Store.Delete(lost)
+		}
+	}
+
+	if err := client.FetchBlob(context.Background(), d, nil); !errors.Is(err, ErrBlobIncomplete) {
+		t.Fatalf("got %v, wanted %v", err, ErrBlobIncomplete)
+	}
+	if missing := d.Missing(); len(missing) != 1 || missing[0] != 1 {
+		t.Fatalf("got missing %v, wanted only chunk 1", missing)
+	}
+
+	// once the chunk is back, fetching again only asks for it
+	servers[0].StoreLocal(lost, removed[0])
+	calls := 0
+	if err := client.FetchBlob(context.Background(), d, func(BlobProgress) { calls++ }); err != nil {
+		t.Fatalf("FetchBlob: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d chunks fetched on resume, wanted 1", calls)
+	}
+	got, err := d.Bytes()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, %v, wanted the original blob", len(got), err)
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/routing"
+)
+
+// ProtocolConfig holds the Kademlia parameters and timeouts.
+type ProtocolConfig struct {
+	// k, b and the replacement cache size of the routing table
+	Routing routing.Config
+	// parallel RPCs per lookup step
+	Alpha int
+	// how many of the k closest nodes a value is stored on, 0 = all k
+	Replication int
+
+	// how long to wait for a reply to a lookup RPC, and for a write to be acked
+	RPCTimeout   time.Duration
+	StoreTimeout time.Duration
+
+	// how often housekeeping runs, and how long a value goes without being
+	// stored before it is republished
+	MaintenanceInterval time.Duration
+	RepublishInterval   time.Duration
+}
+
+// DefaultProtocolConfig returns the parameters from the Kademlia paper:
+// k=20, alpha=3, b=5, values stored on the k closest nodes and republished
+// hourly.
+func DefaultProtocolConfig() ProtocolConfig {
+	return ProtocolConfig{
+		Routing:             routing.DefaultConfig(),
+		Alpha:               ALPHA,
+		RPCTimeout:          RPC_TIMEOUT,
+		StoreTimeout:        STORE_TIMEOUT,
+		MaintenanceInterval: MAINTENANCE_INTERVAL,
+		RepublishInterval:   REPUBLISH_INTERVAL,
+	}
+}
+
+// Validate reports the first parameter that is out of range.
+func (p ProtocolConfig) Validate() error {
+	if err := p.Routing.Validate(); err != nil {
+		return err
+	}
+	if p.Alpha < 1 {
+		return fmt.Errorf("alpha must be at least 1, got %d", p.Alpha)
+	}
+	if p.Replication < 0 || p.Replication > p.Routing.K {
+		return fmt.Errorf("replication must be between 0 and k=%d, got %d", p.Routing.K, p.Replication)
+	}
+	for name, d := range map[string]time.Duration{
+		"rpc timeout":          p.RPCTimeout,
+		"store timeout":        p.StoreTimeout,
+		"maintenance interval": p.MaintenanceInterval,
+		"republish interval":   p.RepublishInterval,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", name, d)
+		}
+	}
+	return nil
+}
+
+// replicas is how many nodes a value is stored on.
+func (p ProtocolConfig) replicas() int {
+	if p.Replication == 0 {
+		return p.Routing.K
+	}
+	return p.Replication
+}
+
+// tombstoneTTL is how long deleted keys stay blocked: long enough for stale
+// replicas to stop republishing them.
+func (p ProtocolConfig) tombstoneTTL() time.Duration {
+	return 2 * p.RepublishInterval
+}
+
+// Config describes a node to create with New.
+type Config struct {
+	// address to bind and advertise
+	IP   string
+	Port int
+
+	// "ip:port" of nodes to join the network through on Start; empty for
+	// the first (bootstrap) node
+	Bootstrap []string
+
+	// S/Kademlia mode: generate an identity meeting Puzzle, sign every RPC
+	// and only admit peers that prove their own
+	Secure bool
+	Puzzle node.PuzzleDifficulty
+	// encrypt all RPC traffic over authenticated sessions, requires Secure
+	Encrypt bool
+
+	Protocol ProtocolConfig
+	Limits   LimitsConfig
+	Quorum   QuorumConfig
+
+	// file the stored values and routing table are saved to on Shutdown and
+	// restored from by New; empty to keep no state across restarts
+	StateFile string
+	// on Shutdown, tell our contacts we are leaving so they drop us from
+	// their routing tables straight away
+	NotifyLeave bool
+
+	// what to log to stderr; silent by default
+	Log LogConfig
+	// if set, the node logs here instead and Log is ignored
+	Logger *slog.Logger
+}
+
+// DefaultConfig returns the settings the command line tool starts from: a
+// plain (non S/Kademlia) node on 127.0.0.1:8090 with the paper's protocol
+// parameters and the default limits.
+func DefaultConfig() Config {
+	return Config{
+		IP:       "127.0.0.1",
+		Port:     8090,
+		Puzzle:   node.DefaultPuzzleDifficulty(),
+		Protocol: DefaultProtocolConfig(),
+		Limits:   DefaultLimitsConfig(),
+		Quorum:   DefaultQuorumConfig(),
+		Log:      DefaultLogConfig(),
+	}
+}
+
+// Validate reports the first setting in cfg that is missing or out of range.
+func (cfg Config) Validate() error {
+	if net.ParseIP(cfg.IP) == nil {
+		return fmt.Errorf("invalid ip %q", cfg.IP)
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("port %d out of range", cfg.Port)
+	}
+	for _, addr := range cfg.Bootstrap {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("bootstrap address %q: %w", addr, err)
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("bootstrap address %q: bad port", addr)
+		}
+	}
+	if cfg.Encrypt && !cfg.Secure {
+		return fmt.Errorf("encrypt requires secure")
+	}
+	if cfg.Puzzle.Static < 0 || cfg.Puzzle.Dynamic < 0 {
+		return fmt.Errorf("puzzle difficulty can't be negative")
+	}
+	if err := cfg.Protocol.Validate(); err != nil {
+		return err
+	}
+	if cfg.Quorum.Write < 0 || cfg.Quorum.Write > cfg.Protocol.replicas() {
+		return fmt.Errorf("write quorum must be between 0 and the %d replicas, got %d",
+			cfg.Protocol.replicas(), cfg.Quorum.Write)
+	}
+	if cfg.Quorum.Read < 0 {
+		return fmt.Errorf("read quorum can't be negative, got %d", cfg.Quorum.Read)
+	}
+	if err := cfg.Limits.Validate(); err != nil {
+		return err
+	}
+	if cfg.Logger == nil {
+		return cfg.Log.Validate()
+	}
+	return nil
+}
+
+// New creates a node as described by cfg and binds its socket. Call Start
+// to run it and Close to release it.
+func New(cfg Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("New: %w", err)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = NewLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	}
+
+	var server *Server
+	var err error
+	if cfg.Secure {
+		logger.Info("generating node identity", "static_bits", cfg.Puzzle.Static, "dynamic_bits", cfg.Puzzle.Dynamic)
+		identity, _, genErr := node.GenerateIdentity(cfg.IP, cfg.Port, cfg.Puzzle)
+		if genErr != nil {
+			return nil, fmt.Errorf("New: generating identity: %w", genErr)
+		}
+		server, err = NewServerWithIdentity(cfg.IP, cfg.Port, identity, cfg.Puzzle)
+	} else {
+		server, err = NewServer(cfg.IP, cfg.Port)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("New: %w", err)
+	}
+
+	server.SetLogger(logger)
+	server.SetProtocol(cfg.Protocol)
+	server.SetLimits(cfg.Limits)
+	server.Quorum = cfg.Quorum
+	server.bootstrap = cfg.Bootstrap
+	server.stateFile = cfg.StateFile
+	server.notifyLeave = cfg.NotifyLeave
+
+	if cfg.StateFile != "" {
+		if err := server.LoadState(cfg.StateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			server.Close()
+			return nil, fmt.Errorf("New: %w", err)
+		}
+	}
+
+	if cfg.Encrypt {
+		if err := server.EnableSecureChannel(); err != nil {
+			server.Close()
+			return nil, fmt.Errorf("New: %w", err)
+		}
+	}
+	return server, nil
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"time"
+)
+
+// Defaults for ProtocolConfig
+const ALPHA = 3                          // parallel RPCs per lookup step
+const RPC_TIMEOUT = 5 * time.Second      // wait for a reply to PING, FIND_NODE, FIND_VALUE or GET_PROVIDERS
+const STORE_TIMEOUT = 3 * time.Second    // wait for a STORE, ANNOUNCE or DELETE to be acked
+const REPUBLISH_INTERVAL = time.Hour     // stored values are pushed to the closest nodes again this often
+const MAINTENANCE_INTERVAL = time.Minute // how often expiry, tombstone GC and republishing run
+
+const TOKEN_ROTATION = 5 * time.Minute // how often the store token secret rotates
+const RATE_LIMIT_MAX_PEERS = 10000     // prune idle rate limit buckets past this many
+const PROVIDER_TTL = 30 * time.Minute  // how long a provider announcement lives unless refreshed
+const MAX_PROVIDERS_PER_KEY = 64       // provider records kept per key
+const MAX_PROVIDER_RECORDS = 100000    // provider records kept over all keys
+const HANDOFF_QUEUE_SIZE = 256         // new contacts waiting for key handoff; more are dropped during a mass join
+const BLOB_CHUNK_SIZE = 32 << 10       // blob chunk size; base64 in JSON must still fit a datagram
+const BLOB_PARALLELISM = 4             // blob chunks stored or fetched at once
+const ERASURE_DATA_SHARDS = 4          // fragments needed to rebuild an erasure-coded value
+const ERASURE_TOTAL_SHARDS = 6         // fragments stored per erasure-coded value, each on its own node
+const LEAVE_TIMEOUT = time.Second      // how long Shutdown waits for each contact to ack a LEAVE
+const MAX_CLOCK_SKEW = 1 << 32         // versions further ahead of our clock than this are refused
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/routing"
+	"cs249-dht/transport"
+)
+
+// lookupClaims records which lookup path queried each node, so that the
+// paths of a disjoint lookup never share a contacted node.
+type lookupClaims struct {
+	mu     sync.Mutex
+	owners map[string]int
+}
+
+func newLookupClaims() *lookupClaims {
+	return &lookupClaims{owners: make(map[string]int)}
+}
+
+// claim reports whether path may query n, i.e. nobody has queried it yet.
+func (c *lookupClaims) claim(n *node.Node, path int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, taken := c.owners[n.HexID()]; taken {
+		return false
+	}
+	c.owners[n.HexID()] = path
+	return true
+}
+
+// ownedByOther reports whether some path other than path already queried n.
+func (c *lookupClaims) ownedByOther(n *node.Node, path int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	owner, taken := c.owners[n.HexID()]
+	return taken && owner != path
+}
+
+func containsNode(nodes []node.Node, n node.Node) bool {
+	for _, x := range nodes {
+		if x.ID().Cmp(n.ID()) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupNodes performs a Kademlia-style iterative lookup for nodes
+// close to targetID, and returns up to KSIZE closest nodes it finds.
+// If ctx is done before the lookup converges it returns ctx.Err().
+func (ln *Server) LookupNodes(ctx context.Context, targetID *big.Int) ([]node.Node, error) {
+	// 1. Start from our own routing table
+	targetNode := node.NewNodeFromID(targetID)
+
+	ln.Logger.Debug("starting lookup", "target", targetNode.HexID())
+	trace := lookupTraceFrom(ctx)
+	trace.start(ln.Self, "node", &targetNode)
+	var closest []node.Node
+	defer func() { trace.finish(&targetNode, closest) }()
+
+	initial := ln.Router.FindNeighbors(targetNode, ln.Protocol.Routing.K)
+	if len(initial) == 0 {
+		return nil, fmt.Errorf("no known nodes in routing table")
+	}
+
+	closest = ln.lookupPath(ctx, targetID, initial, 0, newLookupClaims())
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return closest, nil
+}
+
+// LookupNodesDisjoint is the S/Kademlia variant of LookupNodes. The closest
+// known contacts are split over paths independent lookups that run in
+// parallel, each with its own heap, and no node is ever queried by more than
+// one path. A poisoned peer can then only steer the path it was handed to.
+// Returns the union of every path's result, closest first.
+func (ln *Server) LookupNodesDisjoint(ctx context.Context, targetID *big.Int, paths int) ([]node.Node, error) {
+	if paths < 1 {
+		paths = 1
+	}
+
+	targetNode := node.NewNodeFromID(targetID)
+
+	ln.Logger.Debug("starting disjoint lookup", "target", targetNode.HexID(), "paths", paths)
+	trace := lookupTraceFrom(ctx)
+	trace.start(ln.Self, "node", &targetNode)
+	var out []node.Node
+	defer func() { trace.finish(&targetNode, out) }()
+
+	initial := ln.Router.FindNeighbors(targetNode, ln.Protocol.Routing.K*paths)
+	if len(initial) == 0 {
+		return nil, fmt.Errorf("no known nodes in routing table")
+	}
+
+	// deal the initial contacts round robin, closest first
+	starts := make([][]*node.Node, paths)
+	for i, n := range initial {
+		starts[i%paths] = append(starts[i%paths], n)
+	}
+
+	claims := newLookupClaims()
+	results := make([][]node.Node, paths)
+
+	var wg sync.WaitGroup
+	for path := 0; path < paths; path++ {
+		if len(starts[path]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(path int) {
+			defer wg.Done()
+			results[path] = ln.lookupPath(ctx, targetID, starts[path], path, claims)
+		}(path)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// union of all paths, deduplicated and ordered by distance
+	seen := make(map[string]bool)
+	out = make([]node.Node, 0, ln.Protocol.Routing.K*paths)
+	for _, result := range results {
+		for _, n := range result {
+			if seen[n.HexID()] {
+				continue
+			}
+			seen[n.HexID()] = true
+			out = append(out, n)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return targetNode.GetXorDistance(&out[i]).Cmp(targetNode.GetXorDistance(&out[j])) < 0
+	})
+
+	return out, nil
+}
+
+// lookupPath runs one iterative lookup from initial, using its own bounded
+// heap. Nodes are only queried if claims lets this path have them. It
+// gives up early, returning what it has, once ctx is done.
+func (ln *Server) lookupPath(ctx context.Context, targetID *big.Int, initial []*node.Node, path int, claims *lookupClaims) []node.Node {
+	targetNode := node.NewNodeFromID(targetID)
+	started, rounds := time.Now(), 0
+	defer func() { ln.Metrics.lookedUp("node", time.Since(started), rounds) }()
+	trace := lookupTraceFrom(ctx)
+
+	// 2. Create a bounded heap keyed by distance to target
+	heap := routing.NewBoundedNodeHeap(&targetNode, ln.Protocol.Routing.K)
+	for _, n := range initial {
+		if n == nil || n.ID() == nil {
+			continue
+		}
+		heap.AddNode(n)
+	}
+
+	for ctx.Err() == nil {
+		// 3. Get uncontacted nodes, closest first
+		uncontacted := heap.GetUncontacted()
+		if len(uncontacted) == 0 {
+			break
+		}
+
+		// Take up to alpha at a time
+		batch := uncontacted
+		if len(batch) > ln.Protocol.Alpha {
+			batch = batch[:ln.Protocol.Alpha]
+		}
+		rounds++
+		round := TraceRound{Path: path, Round: rounds}
+
+		progress := false
+
+		for _, n := range batch {
+			if n == nil || n.ID() == nil {
+				continue
+			}
+			heap.MarkContacted(n)
+			if !claims.claim(n, path) {
+				if trace != nil {
+					round.Queries = append(round.Queries, TraceQuery{TraceNode: traceNode(&targetNode, n), Outcome: "skipped"})
+				}
+				continue
+			}
+
+			// 4. Ask this node for neighbors of targetID
+			sent := time.Now()
+			newNodes, err := ln.FindNodeOnce(ctx, targetID, n.IP(), n.Port())
+			if trace != nil {
+				q := traceQuery(&targetNode, n, time.Since(sent), err)
+				for i := range newNodes {
+					q.Returned = append(q.Returned, traceNode(&targetNode, &newNodes[i]))
+				}
+				round.Queries = append(round.Queries, q)
+			}
+			if err != nil {
+				// errors are common (timeouts, offline nodes), just skip
+				continue
+			}
+
+			ln.Logger.Debug("lookup step", "peer", peerAddr(n.IP(), n.Port()), "path", path, "new_nodes", len(newNodes))
+
+			// 5. Merge newly discovered nodes
+			for _, nn := range newNodes {
+				// Make sure we don't freak out if nodeID is nil
+				if nn.ID() == nil {
+					continue
+				}
+				// we can't usefully ask ourselves, and other paths' nodes are off limits
+				if nn.ID().Cmp(ln.Self.ID()) == 0 || claims.ownedByOther(&nn, path) {
+					continue
+				}
+				heap.AddNode(&nn)
+				ln.admitContact(nn)
+			}
+
+			if len(newNodes) > 0 {
+				progress = true
+			}
+		}
+		trace.addRound(round, &targetNode, heap)
+
+		// 6. If none of the batch gave us new nodes, we converged
+		if !progress {
+			break
+		}
+	}
+
+	// 7. Return the K closest nodes from heap
+	closestPtrs := heap.Closest() // []*Node
+	out := make([]node.Node, 0, len(closestPtrs))
+	for _, p := range closestPtrs {
+		if p != nil && p.ID() != nil {
+			out = append(out, *p)
+		}
+	}
+	return out
+}
+
+// LookupValue performs a Kademlia-style iterative FIND_VALUE for key and
+// returns the value. Values failing their own integrity check (hash for
+// content-addressed items, signature for mutable ones) are thrown away and
+// the lookup carries on with the next peer. With a read quorum configured,
+// replies from several replicas are reconciled as in ReadValue.
+func (ln *Server) LookupValue(ctx context.Context, key string) ([]byte, error) {
+	result, err := ln.ReadValue(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return result.Value, nil
+}
+
+// lookupValue returns the first value response that passes accept; a
+// non-nil error from accept discards the response like a corrupted value.
+func (ln *Server) lookupValue(ctx context.Context, key string, accept func(resp *transport.RPCMessage) error) (*transport.RPCMessage, error) {
+	var found *transport.RPCMessage
+	ln.walkValue(ctx, key, node.KeyID(key), ln.Protocol.Routing.K, func(n node.Node, resp *transport.RPCMessage) bool {
+		if resp == nil {
+			return false
+		}
+		if accept != nil {
+			if err := accept(resp); err != nil {
+				ln.Logger.Warn("discarding value", "peer", peerAddr(n.IP(), n.Port()), "key", key, "err", err)
+				return false
+			}
+		}
+		found = resp
+		return true
+	})
+
+	if found == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("value for key %q not found", key)
+	}
+	return found, nil
+}
+
+// walkValue runs an iterative FIND_VALUE for key towards targetID, normally
+// KeyID(key), calling visit with every node that answered: resp is its
+// value response, or nil if it sent contacts instead. The walk ends when
+// visit returns true, none of the width closest nodes seen are left to ask,
+// or ctx is done. Returns those nodes.
+func (ln *Server) walkValue(ctx context.Context, key string, targetID *big.Int, width int, visit func(n node.Node, resp *transport.RPCMessage) (stop bool)) []node.Node {
+	targetNode := node.NewNodeFromID(targetID)
+
+	ln.Logger.Debug("starting value lookup", "key", key)
+	started, rounds := time.Now(), 0
+	defer func() { ln.Metrics.lookedUp("value", time.Since(started), rounds) }()
+	trace := lookupTraceFrom(ctx)
+	trace.start(ln.Self, "value", &targetNode)
+
+	heap := routing.NewBoundedNodeHeap(&targetNode, width)
+	for _, n := range ln.Router.FindNeighbors(targetNode, width) {
+		if n == nil || n.ID() == nil {
+			continue
+		}
+		heap.AddNode(n)
+	}
+
+walk:
+	for ctx.Err() == nil {
+		uncontacted := heap.GetUncontacted()
+		if len(uncontacted) == 0 {
+			break
+		}
+
+		batch := uncontacted
+		if len(batch) > ln.Protocol.Alpha {
+			batch = batch[:ln.Protocol.Alpha]
+		}
+		rounds++
+		round := TraceRound{Round: rounds}
+
+		for _, n := range batch {
+			if n == nil || n.ID() == nil {
+				continue
+			}
+			heap.MarkContacted(n)
+
+			sent := time.Now()
+			resp, newNodes, err := ln.findValueOnce(ctx, key, targetID, n.IP(), n.Port())
+			if trace != nil {
+				q := traceQuery(&targetNode, n, time.Since(sent), err)
+				q.Value = err == nil && resp != nil
+				for i := range newNodes {
+					q.Returned = append(q.Returned, traceNode(&targetNode, &newNodes[i]))
+				}
+				round.Queries = append(round.Queries, q)
+			}
+			if err != nil {
+				// timeouts, offline nodes and corrupted values alike: try someone else
+				ln.Logger.Debug("value lookup step", "peer", peerAddr(n.IP(), n.Port()), "key", key, "err", err)
+				continue
+			}
+			if visit(*n, resp) {
+				trace.addRound(round, &targetNode, heap)
+				break walk
+			}
+
+			for _, nn := range newNodes {
+				if nn.ID() == nil || nn.ID().Cmp(ln.Self.ID()) == 0 {
+					continue
+				}
+				heap.AddNode(&nn)
+			}
+		}
+		trace.addRound(round, &targetNode, heap)
+	}
+
+	closest := heap.Closest()
+	out := make([]node.Node, 0, len(closest))
+	for _, p := range closest {
+		if p != nil && p.ID() != nil {
+			out = append(out, *p)
+		}
+	}
+	trace.finish(&targetNode, out)
+	return out
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/routing"
+	"cs249-dht/transport"
+)
+
+// startMaliciousNode runs a node that answers every FIND_NODE with made-up
+// contacts right next to the target, all of which point back at itself.
+func startMaliciousNode(t *testing.T, port int) *Server {
+	mal, err := NewServer("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	go mal.Transport.ListenRPC(func(msg *transport.RPCMessage, from *net.UDPAddr) {
+		switch msg.Type {
+		case transport.RPCPing:
+			mal.sendDirectRPC(mal.newReply(msg, transport.RPCPong), from)
+
+		case transport.RPCFindNode:
+			target := new(big.Int)
+			if _, ok := target.SetString(msg.TargetID, 16); !ok {
+				return
+			}
+			resp := mal.newReply(msg, transport.RPCFindNode)
+			for i := 1; i <= routing.KSIZE; i++ {
+				fake := new(big.Int).Xor(target, big.NewInt(int64(i)))
+				resp.Nodes = append(resp.Nodes, transport.RPCNodeInfo{
+					ID:   node.NodeIDToHex(fake),
+					IP:   "127.0.0.1",
+					Port: port,
+				})
+			}
+			mal.sendDirectRPC(resp, from)
+		}
+	})
+
+	return mal
+}
+
+func startHonestNode(t *testing.T, port int) *Server {
+	s, err := NewServer("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go s.Run()
+	return s
+}
+
+func TestDisjointLookupSurvivesPoisonedPeer(t *testing.T) {
+	honest := startHonestNode(t, 19401)
+	target := startHonestNode(t, 19402)
+	startMaliciousNode(t, 19403)
+
+	target.PingBootstrap(context.Background(), "127.0.0.1", 19401)
+
+	// look for an ID next to (but not equal to) the target node
+	targetID := new(big.Int).Lsh(big.NewInt(1), 200)
+	targetID.Xor(targetID, target.Self.ID())
+
+	// single shared heap: the malicious node's fake contacts crowd out the target
+	single := startHonestNode(t, 19404)
+	single.PingBootstrap(context.Background(), "127.0.0.1", 19401)
+	single.PingBootstrap(context.Background(), "127.0.0.1", 19403)
+
+	got, err := single.LookupNodes(context.Background(), targetID)
+	if err != nil {
+		t.Fatalf("LookupNodes: %v", err)
+	}
+	if containsNode(got, target.Self) {
+		t.Errorf("single path lookup found the target, wanted it poisoned")
+	}
+
+	// disjoint paths: the honest path is never handed the fake contacts
+	disjoint := startHonestNode(t, 19405)
+	disjoint.PingBootstrap(context.Background(), "127.0.0.1", 19401)
+	disjoint.PingBootstrap(context.Background(), "127.0.0.1", 19403)
+
+	got, err = disjoint.LookupNodesDisjoint(context.Background(), targetID, 2)
+	if err != nil {
+		t.Fatalf("LookupNodesDisjoint: %v", err)
+	}
+	if !containsNode(got, target.Self) {
+		t.Errorf("disjoint lookup did not find the target node %s", target.Self.HexID())
+	}
+	if !containsNode(got, honest.Self) {
+		t.Errorf("disjoint lookup did not return the honest node %s", honest.Self.HexID())
+	}
+
+	// results are ordered by distance to the target ID
+	targetNode := node.NewNodeFromID(targetID)
+	for i := 1; i < len(got); i++ {
+		if targetNode.GetXorDistance(&got[i-1]).Cmp(targetNode.GetXorDistance(&got[i])) > 0 {
+			t.Errorf("result %d is farther than result %d", i-1, i)
+		}
+	}
+}
+
+func TestLookupHonoursContext(t *testing.T) {
+	client := startHonestNode(t, 19406)
+
+	// bound but never reading, so every RPC to it hangs until its timeout
+	silent, err := NewServer("127.0.0.1", 19407)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer silent.Close()
+	client.Router.AddContact(silent.Self)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.LookupNodes(ctx, big.NewInt(1))
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, wanted %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookup took %v, wanted it to stop at the 200ms deadline", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.StoreValue(cancelled, "k", []byte("v")); err != context.Canceled {
+		t.Errorf("got error %v, wanted %v", err, context.Canceled)
+	}
+	if _, err := client.LookupValue(cancelled, "k"); err != context.Canceled {
+		t.Errorf("got error %v, wanted %v", err, context.Canceled)
+	}
+}
+
+func TestLookupClaims(t *testing.T) {
+	claims := newLookupClaims()
+	n := node.NewNodeFromInt(7)
+
+	if !claims.claim(&n, 0) {
+		t.Errorf("got false, wanted first claim to succeed")
+	}
+	if claims.claim(&n, 1) {
+		t.Errorf("got true, wanted second path to be refused")
+	}
+	if claims.claim(&n, 0) {
+		t.Errorf("got true, wanted node to be queried only once")
+	}
+	if !claims.ownedByOther(&n, 1) {
+		t.Errorf("got false, wanted node owned by path 0")
+	}
+	if claims.ownedByOther(&n, 0) {
+		t.Errorf("got true, wanted node owned by path 0 itself")
+	}
+}
+
+// small helper so we don't repeat sleep logic
+func waitForRouting() {
+	time.Sleep(300 * time.Millisecond)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+
+	"cs249-dht/node"
+	"cs249-dht/transport"
+)
+
+// provenSender reports whether msg.FromID really belongs to whoever sent
+// msg: either it is bound to the key that signed msg (checkSender has
+// verified the signature by now), or it is the ID of the address msg came
+// from.
+func provenSender(msg *transport.RPCMessage, from *net.UDPAddr) bool {
+	var n node.Node
+	var err error
+	if len(msg.Signature) > 0 {
+		n, err = node.NewNodeFromIPAndport(msg.FromIP, msg.FromPort, msg.PublicKey)
+	} else {
+		n, err = node.NewNodeFromIPAndport(from.IP.String(), from.Port)
+	}
+	return err == nil && n.HexID() == msg.FromID
+}
+
+// senderID is who msg provably comes from: msg.FromID if provenSender,
+// otherwise the node at the address it came from.
+func senderID(msg *transport.RPCMessage, from *net.UDPAddr) string {
+	if provenSender(msg, from) {
+		return msg.FromID
+	}
+	n, err := node.NewNodeFromIPAndport(from.IP.String(), from.Port)
+	if err != nil {
+		return from.String()
+	}
+	return n.HexID()
+}
+
+// quotaOwner is who pays in the quotas for what msg stores here. A sender
+// whose signed ID carries a valid crypto puzzle proof is charged by ID, as
+// IDs cost work to make; anyone else could take a new ID for every source
+// port, so is charged for its address, an IPv4 address or an IPv6 /64.
+func (ln *Server) quotaOwner(msg *transport.RPCMessage, from *net.UDPAddr) string {
+	if ln.Identity != nil && len(msg.Signature) > 0 && provenSender(msg, from) {
+		if sender, err := transport.NodeFromRPC(msg); err == nil && node.VerifyNodeProof(*sender, ln.Puzzle) == nil {
+			return msg.FromID
+		}
+	}
+	if ip4 := from.IP.To4(); ip4 != nil {
+		return "ip:" + ip4.String()
+	}
+	prefix := net.IPNet{IP: from.IP.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return "ip:" + prefix.String()
+}
+
+// handleDeleteRPC removes msg.Key if the sender published it, and leaves a
+// tombstone so stale replicas can't bring the sender's value back. A
+// tombstone for a key we don't hold is only taken if we are among the k
+// closest nodes to it, where the publisher's replicas would be.
+func (ln *Server) handleDeleteRPC(msg *transport.RPCMessage, from *net.UDPAddr) {
+	ln.Logger.Debug("got DELETE", "peer", from.String(), "key", msg.Key)
+
+	if msg.Key == "" {
+		ln.Logger.Warn("DELETE with empty key, ignoring", "peer", from.String())
+		return
+	}
+	if !ln.Tokens.Validate(msg.Token, from) {
+		ln.Logger.Warn("DELETE without a valid token, rejecting", "peer", from.String(), "key", msg.Key)
+		ln.sendErrorRPC(msg, from, "invalid or expired store token")
+		return
+	}
+	if !provenSender(msg, from) {
+		ln.Logger.Warn("DELETE from an unproven sender ID, rejecting", "peer", from.String(), "key", msg.Key)
+		ln.sendErrorRPC(msg, from, "delete needs a signed rpc or a sender ID matching its address")
+		return
+	}
+
+	if !ln.holdsKey(msg.Key, msg.FromID) && !ln.amongClosest(node.KeyID(msg.Key)) {
+		ln.Logger.Info("DELETE for a key we don't replicate, rejecting", "peer", from.String(), "key", msg.Key)
+		ln.sendErrorRPC(msg, from, "not a replica for this key")
+		return
+	}
+	if _, err := ln.Store.Tombstone(msg.Key, msg.FromID, ln.Protocol.tombstoneTTL()); err != nil {
+		ln.Logger.Info("DELETE refused", "peer", from.String(), "key", msg.Key, "err", err)
+		ln.sendErrorRPC(msg, from, err.Error())
+		return
+	}
+
+	ack := ln.newReply(msg, transport.RPCDelete)
+	ack.Key = msg.Key
+	if err := ln.sendDirectRPC(ack, from); err != nil {
+		ln.Logger.Warn("sending DELETE ack", "peer", from.String(), "err", err)
+	}
+}
+
+// holdsKey reports whether we have a value or a tombstone from publisher
+// under key.
+func (ln *Server) holdsKey(key, publisher string) bool {
+	if _, ok := ln.Store.Get(key); ok {
+		return true
+	}
+	_, ok := ln.Store.GetTombstone(key, publisher)
+	return ok
+}
+
+// amongClosest reports whether fewer than k of our contacts are closer to
+// keyID than we are.
+func (ln *Server) amongClosest(keyID *big.Int) bool {
+	target := node.NewNodeFromID(keyID)
+	ours := target.GetXorDistance(&ln.Self)
+	closer := 0
+	for _, n := range ln.Router.FindNeighbors(target, ln.Protocol.Routing.K) {
+		if n != nil && n.ID() != nil && target.GetXorDistance(n).Cmp(ours) < 0 {
+			closer++
+		}
+	}
+	return closer < ln.Protocol.Routing.K
+}
+
+// Delete retracts a value we published: it is removed here and on the k
+// closest nodes to key, all of which keep a tombstone
+// for twice the republish interval.
+// Returns how many remote nodes accepted the delete.
+func (ln *Server) Delete(ctx context.Context, key string) (int, error) {
+	if _, err := ln.Store.Tombstone(key, ln.Self.HexID(), ln.Protocol.tombstoneTTL()); err != nil {
+		ln.Logger.Warn("deleting local copy", "key", key, "err", err)
+	}
+
+	// the replicas are wherever StoreValue put them, plus whoever is
+	// closest by now
+	keyID := node.KeyID(key)
+	targets := make(map[string]node.Node)
+	for _, n := range ln.Router.FindNeighbors(node.NewNodeFromID(keyID), ln.Protocol.replicas()) {
+		if n != nil && n.ID() != nil {
+			targets[n.HexID()] = *n
+		}
+	}
+	if nodes, err := ln.LookupNodes(ctx, keyID); err == nil {
+		for _, n := range nodes {
+			targets[n.HexID()] = n
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	delete(targets, ln.Self.HexID())
+	if len(targets) == 0 {
+		return 0, fmt.Errorf("Delete: no known nodes to delete from")
+	}
+
+	deleted := 0
+	var lastErr error
+	for _, n := range targets {
+		if err := ln.deleteFromNode(ctx, n, keyID, key); err != nil {
+			ln.Logger.Info("deleting from replica", "peer", peerAddr(n.IP(), n.Port()), "key", key, "err", err)
+			lastErr = err
+			continue
+		}
+		deleted++
+	}
+
+	if err := ctx.Err(); err != nil {
+		return deleted, err
+	}
+	if deleted == 0 && lastErr != nil {
+		return 0, fmt.Errorf("Delete: %w", lastErr)
+	}
+	return deleted, nil
+}
+
+// deleteFromNode sends a DELETE for key to n, presenting a write token.
+func (ln *Server) deleteFromNode(ctx context.Context, n node.Node, keyID *big.Int, key string) error {
+	token, err := ln.writeToken(ctx, n, keyID)
+	if err != nil {
+		return err
+	}
+
+	msg := ln.newRPC(transport.RPCDelete)
+	msg.Key = key
+	msg.Token = token
+
+	_, err = ln.sendRPC(ctx, n.IP(), n.Port(), msg, ln.Protocol.StoreTimeout)
+	if err != nil {
+		ln.forgetToken(n.IP(), n.Port())
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"cs249-dht/node"
+)
+
+func TestDeletePropagates(t *testing.T) {
+	storer := startHonestNode(t, 20101)
+	publisher := startHonestNode(t, 20102)
+	other := startHonestNode(t, 20103)
+	publisher.PingBootstrap(context.Background(), "127.0.0.1", 20101)
+	other.PingBootstrap(context.Background(), "127.0.0.1", 20101)
+
+	if _, err := publisher.StoreValue(context.Background(), "doomed", []byte("v1")); err != nil {
+		t.Fatalf("StoreValue: %v", err)
+	}
+	waitForRouting()
+
+	// only the publisher may delete
+	if _, err := other.Delete(context.Background(), "doomed"); err == nil {
+		t.Errorf("got nil error, wanted non-publisher delete to fail")
+	}
+	if _, ok := storer.GetLocal("doomed"); !ok {
+		t.Fatalf("value gone after a non-publisher delete")
+	}
+
+	if n, err := publisher.Delete(context.Background(), "doomed"); err != nil || n == 0 {
+		t.Fatalf("got n=%d err=%v, wanted delete to reach the storer", n, err)
+	}
+	if _, ok := storer.GetLocal("doomed"); ok {
+		t.Errorf("storer still has the deleted value")
+	}
+	if _, ok := storer.Store.GetTombstone("doomed", publisher.Self.HexID()); !ok {
+		t.Errorf("storer kept no tombstone")
+	}
+
+	// a stale replica republishing the old value is turned away
+	stale := storeRequest{Key: "doomed", Value: []byte("v1"), Publisher: publisher.Self.HexID()}
+	if result, _ := other.replicate(context.Background(), stale); result.Acks != 0 {
+		t.Errorf("got %d nodes accepting a stale republish, wanted 0", result.Acks)
+	}
+
+	// the publisher can publish again
+	if _, err := publisher.StoreValue(context.Background(), "doomed", []byte("v2")); err != nil {
+		t.Fatalf("StoreValue: %v", err)
+	}
+	waitForRouting()
+	got, ok := storer.GetLocal("doomed")
+	if !ok || string(got) != "v2" {
+		t.Errorf("got %q (found=%t), wanted %q", got, ok, "v2")
+	}
+}
+
+func TestSecondDeleteKeepsFirstTombstone(t *testing.T) {
+	storer := startHonestNode(t, 20104)
+	publisher := startHonestNode(t, 20105)
+	other := startHonestNode(t, 20106)
+	publisher.PingBootstrap(context.Background(), "127.0.0.1", 20104)
+	other.PingBootstrap(context.Background(), "127.0.0.1", 20104)
+
+	if _, err := publisher.StoreValue(context.Background(), "doomed", []byte("v1")); err != nil {
+		t.Fatalf("StoreValue: %v", err)
+	}
+	waitForRouting()
+	if n, err := publisher.Delete(context.Background(), "doomed"); err != nil || n == 0 {
+		t.Fatalf("got n=%d err=%v, wanted delete to reach the storer", n, err)
+	}
+
+	// the key is absent on the storer now, so anyone nearby may tombstone it
+	// for themselves, but not in the publisher's place
+	if err := other.deleteFromNode(context.Background(), storer.Self, node.KeyID("doomed"), "doomed"); err != nil {
+		t.Fatalf("deleteFromNode: %v", err)
+	}
+	if _, ok := storer.Store.GetTombstone("doomed", other.Self.HexID()); !ok {
+		t.Errorf("got no tombstone for the second publisher")
+	}
+	if _, ok := storer.Store.GetTombstone("doomed", publisher.Self.HexID()); !ok {
+		t.Fatalf("got the first publisher's tombstone replaced")
+	}
+
+	stale := storeRequest{Key: "doomed", Value: []byte("v1"), Publisher: publisher.Self.HexID()}
+	if result, _ := other.replicate(context.Background(), stale); result.Acks != 0 {
+		t.Errorf("got %d nodes accepting a stale republish, wanted 0", result.Acks)
+	}
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/storage"
+	"cs249-dht/transport"
+)
+
+var ErrNotErasureCoded = errors.New("value is not erasure-coded")
+
+// ErasureManifest describes a value Reed-Solomon coded into Total fragments,
+// any Data of which rebuild it. It is stored under the value's own key; the
+// fragments live under FragmentKey(key, i).
+type ErasureManifest struct {
+	Data   int      `json:"data"`
+	Total  int      `json:"total"`
+	Size   int64    `json:"size"`
+	Hash   string   `json:"hash"`   // hex SHA-256 of the value
+	Shards []string `json:"shards"` // hex SHA-256 of each fragment
+}
+
+func decodeErasureManifest(encoded []byte) (ErasureManifest, error) {
+	var m ErasureManifest
+	if err := json.Unmarshal(encoded, &m); err != nil {
+		return m, fmt.Errorf("decoding erasure manifest: %w", err)
+	}
+	if m.Data < 1 || m.Total < m.Data || m.Total > 256 || len(m.Shards) != m.Total || m.Size < 0 {
+		return m, fmt.Errorf("malformed erasure manifest: %d of %d shards, %d hashes, size %d",
+			m.Data, m.Total, len(m.Shards), m.Size)
+	}
+	return m, nil
+}
+
+// FragmentKey is the key fragment i of key is stored under. It is hashed
+// like any other key; lookups for the fragment head for fragmentID instead.
+func FragmentKey(key string, i int) string {
+	return fmt.Sprintf("fragment:%d:%s", i, key)
+}
+
+// fragmentID is where fragment i of key is looked for. It differs from
+// KeyID(key) only in the lowest bits, so the fragments sit among the nodes
+// closest to the key.
+func fragmentID(key string, i int) *big.Int {
+	return new(big.Int).Xor(node.KeyID(key), big.NewInt(int64(i+1)))
+}
+
+func shardHash(shard []byte) string {
+	sum := sha256.Sum256(shard)
+	return hex.EncodeToString(sum[:])
+}
+
+// PutErasureCoded splits value into dataShards fragments plus parity, for
+// totalShards in all (0 for either means ERASURE_DATA_SHARDS and
+// ERASURE_TOTAL_SHARDS), and stores one fragment on each of the
+// totalShards nodes closest to key. The manifest is then replicated under
+// key like StoreValue does. Fails unless enough fragments were stored to
+// rebuild the value.
+func (ln *Server) PutErasureCoded(ctx context.Context, key string, value []byte, dataShards, totalShards int) (ErasureManifest, error) {
+	if dataShards <= 0 {
+		dataShards = ERASURE_DATA_SHARDS
+	}
+	if totalShards <= 0 {
+		totalShards = ERASURE_TOTAL_SHARDS
+	}
+	rs, err := storage.NewReedSolomon(dataShards, totalShards)
+	if err != nil {
+		return ErasureManifest{}, fmt.Errorf("PutErasureCoded: %w", err)
+	}
+
+	shards := rs.Encode(value)
+	manifest := ErasureManifest{
+		Data:  dataShards,
+		Total: totalShards,
+		Size:  int64(len(value)),
+		Hash:  shardHash(value),
+	}
+	for _, s := range shards {
+		manifest.Shards = append(manifest.Shards, shardHash(s))
+	}
+
+	version := ln.nextVersion(key)
+	now := time.Now()
+	missing := make([]int, len(shards))
+	for i := range missing {
+		missing[i] = i
+	}
+	stored, err := ln.storeFragments(ctx, key, shards, missing, func(req *storeRequest) {
+		req.Version = version
+		req.Timestamp = now
+	})
+	if err != nil {
+		return manifest, ctxOr(ctx, fmt.Errorf("PutErasureCoded: %w", err))
+	}
+	if stored < dataShards {
+		return manifest, fmt.Errorf("PutErasureCoded: only %d of %d fragments stored, %d needed",
+			stored, totalShards, dataShards)
+	}
+
+	encoded, err := json.Marshal(&manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("PutErasureCoded: encoding manifest: %w", err)
+	}
+	req := storeRequest{Key: key, Value: encoded, Erasure: true, Version: version, Timestamp: now}
+	if _, err := ln.replicate(ctx, req); err != nil {
+		return manifest, ctxOr(ctx, fmt.Errorf("PutErasureCoded: manifest: %w", err))
+	}
+	return manifest, nil
+}
+
+// storeFragments stores shards[i] for each i in which, each on its own node
+// among the closest to key, moving on to the next node if one refuses.
+// stamp fills in the version fields of each request. Returns how many were
+// stored.
+func (ln *Server) storeFragments(ctx context.Context, key string, shards [][]byte, which []int, stamp func(req *storeRequest)) (int, error) {
+	nodes := ln.closestNodes(ctx, key, len(shards))
+	if len(nodes) == 0 {
+		return 0, errNoStoreTargets
+	}
+
+	var mu sync.Mutex
+	stored := 0
+	forEachParallel(len(which), func(j int) {
+		i := which[j]
+		fragKey := FragmentKey(key, i)
+		req := storeRequest{Key: fragKey, Value: shards[i], Fragment: true}
+		stamp(&req)
+
+		for attempt := 0; attempt < len(nodes); attempt++ {
+			n := nodes[(i+attempt)%len(nodes)]
+			if err := ln.storeToNode(ctx, n, fragmentID(key, i), req); err != nil {
+				ln.Logger.Info("storing fragment", "peer", peerAddr(n.IP(), n.Port()), "key", key, "fragment", i, "err", err)
+				continue
+			}
+			mu.Lock()
+			stored++
+			mu.Unlock()
+			return
+		}
+	})
+	return stored, ctx.Err()
+}
+
+// closestNodes returns the n closest nodes to key we can find.
+func (ln *Server) closestNodes(ctx context.Context, key string, n int) []node.Node {
+	return ln.walkValue(ctx, key, node.KeyID(key), n, func(node.Node, *transport.RPCMessage) bool { return false })
+}
+
+// GetErasureCoded looks up the manifest stored under key, fetches its
+// fragments in parallel and rebuilds the value from the first ones to
+// arrive, checking it against the manifest's hash.
+func (ln *Server) GetErasureCoded(ctx context.Context, key string) ([]byte, error) {
+	resp, err := ln.lookupValue(ctx, key, func(resp *transport.RPCMessage) error {
+		if !resp.Erasure {
+			return ErrNotErasureCoded
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, ctxOr(ctx, fmt.Errorf("GetErasureCoded: %w", err))
+	}
+	manifest, err := decodeErasureManifest(resp.Value)
+	if err != nil {
+		return nil, fmt.Errorf("GetErasureCoded: %w", err)
+	}
+
+	shards := ln.fetchFragments(ctx, key, manifest, manifest.Data)
+	value, err := rebuildErasure(manifest, shards)
+	if err != nil {
+		return nil, ctxOr(ctx, fmt.Errorf("GetErasureCoded: %w", err))
+	}
+	return value, nil
+}
+
+func rebuildErasure(manifest ErasureManifest, shards [][]byte) ([]byte, error) {
+	rs, err := storage.NewReedSolomon(manifest.Data, manifest.Total)
+	if err != nil {
+		return nil, err
+	}
+	value, err := rs.Reconstruct(shards, int(manifest.Size))
+	if err != nil {
+		return nil, err
+	}
+	if shardHash(value) != manifest.Hash {
+		return nil, fmt.Errorf("rebuilt value doesn't match manifest hash")
+	}
+	return value, nil
+}
+
+// fetchFragments looks up every fragment manifest lists at once and returns
+// as soon as want of them arrived intact (or every lookup gave up). Missing
+// fragments are nil. Lookups still running are cancelled on return.
+func (ln *Server) fetchFragments(ctx context.Context, key string, manifest ErasureManifest, want int) [][]byte {
+	type fragment struct {
+		i     int
+		value []byte
+	}
+	results := make(chan fragment, manifest.Total)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i := 0; i < manifest.Total; i++ {
+		go func(i int) {
+			// lookups only ask other nodes, so check our own copy first
+			if stored, ok := ln.Store.Get(FragmentKey(key, i)); ok && shardHash(stored.Value) == manifest.Shards[i] {
+				results <- fragment{i: i, value: stored.Value}
+				return
+			}
+
+			var found []byte
+			ln.walkValue(ctx, FragmentKey(key, i), fragmentID(key, i), manifest.Total, func(n node.Node, resp *transport.RPCMessage) bool {
+				if ctx.Err() != nil {
+					return true
+				}
+				if resp == nil {
+					return false
+				}
+				if shardHash(resp.Value) != manifest.Shards[i] {
+					ln.Logger.Warn("discarding bad fragment", "peer", peerAddr(n.IP(), n.Port()), "key", key, "fragment", i)
+					return false
+				}
+				found = resp.Value
+				return true
+			})
+			results <- fragment{i: i, value: found}
+		}(i)
+	}
+
+	shards := make([][]byte, manifest.Total)
+	got := 0
+	for answered := 0; answered < manifest.Total && got < want; answered++ {
+		f := <-results
+		if f.value != nil {
+			shards[f.i] = f.value
+			got++
+		}
+	}
+	return shards
+}
+
+// repairErasure re-stores the fragments of the manifest item that can no
+// longer be found, rebuilding them from the ones that can. Only one replica
+// republishes a given manifest per interval, so normally only one node
+// repairs it.
+func (ln *Server) repairErasure(ctx context.Context, item storage.StoredValue) error {
+	manifest, err := decodeErasureManifest(item.Value)
+	if err != nil {
+		return err
+	}
+
+	shards := ln.fetchFragments(ctx, item.Key, manifest, manifest.Total)
+	var missing []int
+	for i, s := range shards {
+		if s == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	value, err := rebuildErasure(manifest, shards)
+	if err != nil {
+		return fmt.Errorf("%d of %d fragments missing: %w", len(missing), manifest.Total, err)
+	}
+	rs, err := storage.NewReedSolomon(manifest.Data, manifest.Total)
+	if err != nil {
+		return err
+	}
+
+	stored, err := ln.storeFragments(ctx, item.Key, rs.Encode(value), missing, func(req *storeRequest) {
+		req.Publisher = item.Publisher
+		req.Timestamp = item.Timestamp
+		req.Version = item.Version
+	})
+	if err != nil {
+		return err
+	}
+	ln.Logger.Info("repaired fragments", "key", item.Key, "repaired", stored, "missing", len(missing))
+	return nil
+}
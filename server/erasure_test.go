@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+)
+
+func TestFragmentIDNearKey(t *testing.T) {
+	keyID := node.KeyID("movie")
+	for i := 0; i < 6; i++ {
+		fragID := fragmentID("movie", i)
+		if fragID.Cmp(keyID) == 0 {
+			t.Errorf("got fragment %d at the key's own ID, wanted its own ID", i)
+		}
+		if d := fragID.Xor(fragID, keyID); d.BitLen() > 3 {
+			t.Errorf("got fragment %d %d bits away from the key, wanted the lowest bits only", i, d.BitLen())
+		}
+	}
+
+	// no key string can pick its own spot in the ID space
+	raw := "id:" + node.NodeIDToHex(keyID)
+	if node.KeyID(raw).Cmp(keyID) == 0 {
+		t.Errorf("got %q placed at the ID it names, wanted it hashed like any other key", raw)
+	}
+}
+
+// startErasureNetwork starts a bootstrap node plus n others joined to it.
+func startErasureNetwork(t *testing.T, basePort, n int) []*Server {
+	servers := []*Server{startHonestNode(t, basePort)}
+	for i := 1; i <= n; i++ {
+		s := startHonestNode(t, basePort+i)
+		s.PingBootstrap(context.Background(), "127.0.0.1", basePort)
+		servers = append(servers, s)
+	}
+	waitForRouting()
+	return servers
+}
+
+// dropFragments deletes fragments which of key from every server.
+func dropFragments(servers []*Server, key string, which ...int) {
+	for _, i := range which {
+		for _, s := range servers {
+			s.Store.Delete(FragmentKey(key, i))
+		}
+	}
+}
+
+func TestErasureSurvivesLostFragments(t *testing.T) {
+	servers := startErasureNetwork(t, 20701, 7)
+	publisher, client := servers[1], servers[7]
+
+	value := make([]byte, 5000)
+	rand.Read(value)
+	manifest, err := publisher.PutErasureCoded(context.Background(), "movie", value, 4, 6)
+	if err != nil {
+		t.Fatalf("PutErasureCoded: %v", err)
+	}
+	if manifest.Data != 4 || manifest.Total != 6 {
+		t.Fatalf("got %d of %d shards, wanted 4 of 6", manifest.Data, manifest.Total)
+	}
+
+	// no node should hold more than its share
+	for _, s := range servers {
+		for _, key := range s.Store.Keys() {
+			if v, _ := s.Store.Get(key); v.Fragment && len(v.Value) > len(value)/4+1 {
+				t.Errorf("got a %d-byte fragment, wanted a quarter of the value", len(v.Value))
+			}
+		}
+	}
+
+	dropFragments(servers, "movie", 0, 4)
+	got, err := client.GetErasureCoded(context.Background(), "movie")
+	if err != nil {
+		t.Fatalf("GetErasureCoded with two fragments lost: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("got %d different bytes back, wanted the original value", len(got))
+	}
+
+	dropFragments(servers, "movie", 2)
+	if _, err := client.GetErasureCoded(context.Background(), "movie"); err == nil {
+		t.Errorf("got nil error with three of six fragments lost, wanted error")
+	}
+}
+
+func TestRepublishRepairsFragments(t *testing.T) {
+	servers := startErasureNetwork(t, 20711, 7)
+	publisher, client := servers[1], servers[7]
+
+	value := []byte("a value worth keeping around for a while")
+	if _, err := publisher.PutErasureCoded(context.Background(), "archive", value, 4, 6); err != nil {
+		t.Fatalf("PutErasureCoded: %v", err)
+	}
+	dropFragments(servers, "archive", 1, 5)
+
+	later := time.Now().Add(REPUBLISH_INTERVAL + time.Minute)
+	publisher.Store.SetClock(func() time.Time { return later })
+	publisher.Maintain(context.Background())
+
+	for _, i := range []int{1, 5} {
+		held := 0
+		for _, s := range servers {
+			if _, ok := s.Store.Get(FragmentKey("archive", i)); ok {
+				held++
+			}
+		}
+		if held != 1 {
+			t.Errorf("got fragment %d on %d nodes after republish, wanted it repaired onto one", i, held)
+		}
+	}
+
+	// with the repaired fragments, losing two others is survivable again
+	dropFragments(servers, "archive", 0, 2)
+	got, err := client.GetErasureCoded(context.Background(), "archive")
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("got %q, %v, wanted the original value", got, err)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"cs249-dht/node"
+)
+
+// queueHandoff is the router's OnNewContact hook. It must not block, since
+// it runs inside RPC handling: if the queue is full (a mass join) the node is
+// skipped, and it will still pick up keys from the next republish.
+func (ln *Server) queueHandoff(n node.Node) {
+	select {
+	case ln.handoffQueue <- n:
+	default:
+		ln.Logger.Warn("handoff queue full, skipping new contact", "peer", peerAddr(n.IP(), n.Port()))
+	}
+}
+
+// handoffLoop works through newly admitted contacts one at a time.
+func (ln *Server) handoffLoop() {
+	for {
+		select {
+		case n := <-ln.handoffQueue:
+			ln.handoff(ln.ctx, n)
+		case <-ln.ctx.Done():
+			return
+		}
+	}
+}
+
+// waitHandoffToken blocks until the Handoff rate from our limits allows
+// another STORE. A zero rate means no limit. Returns ctx.Err() if ctx is
+// done first.
+func (ln *Server) waitHandoffToken(ctx context.Context) error {
+	for {
+		ln.handoffMu.Lock()
+		now := time.Now()
+		ok := ln.handoffBucket.spec.Rate <= 0 || ln.handoffBucket.Allow(now)
+		wait := ln.handoffBucket.RetryAfter(now)
+		ln.handoffMu.Unlock()
+
+		if ok {
+			return nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handoff sends n every key we hold for which n is now among the k closest
+// nodes we know (Kademlia paper, section 2.5). Contacts learned from other
+// nodes' replies could name any address, so n must answer a PING as itself
+// before it is sent anything else.
+func (ln *Server) handoff(ctx context.Context, n node.Node) {
+	handed := 0
+	checked := false
+	for _, key := range ln.Store.Keys() {
+		keyID := node.KeyID(key)
+		if !containsID(ln.Router.FindNeighbors(node.NewNodeFromID(keyID), ln.Protocol.Routing.K), n) {
+			continue
+		}
+		item, ok := ln.Store.Get(key)
+		if !ok || item.Fragment {
+			// fragments stay put; the manifest's republisher looks after them
+			continue
+		}
+
+		if !checked {
+			peer, _, err := ln.Ping(ctx, n.IP(), n.Port())
+			if err != nil || peer.HexID() != n.HexID() {
+				ln.Logger.Info("new contact did not answer as itself, no handoff", "peer", peerAddr(n.IP(), n.Port()), "err", err)
+				return
+			}
+			checked = true
+		}
+
+		if err := ln.waitHandoffToken(ctx); err != nil {
+			return
+		}
+
+		req := storeRequestFromStored(item)
+		if err := ln.storeToNode(ctx, n, keyID, req); err != nil {
+			ln.Logger.Info("handing key to new contact", "peer", peerAddr(n.IP(), n.Port()), "key", key, "err", err)
+			continue
+		}
+		handed++
+	}
+
+	if handed > 0 {
+		ln.Logger.Info("handed keys to new contact", "peer", peerAddr(n.IP(), n.Port()), "keys", handed)
+	}
+}
+
+func containsID(nodes []*node.Node, n node.Node) bool {
+	for _, x := range nodes {
+		if x != nil && x.ID() != nil && x.ID().Cmp(n.ID()) == 0 {
+			return true
+		}
+	}
+	return false
+}
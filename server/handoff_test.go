@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/transport"
+)
+
+// countKeys reports how many of keys s holds.
+func countKeys(s *Server, keys []string) int {
+	n := 0
+	for _, key := range keys {
+		if _, ok := s.GetLocal(key); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHandoffToNewContact(t *testing.T) {
+	holder := startHonestNode(t, 20301)
+
+	var keys []string
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		holder.StoreLocal(key, []byte("v"))
+	}
+
+	// with nobody else in the table, the newcomer is among the k closest to everything
+	joiner := startHonestNode(t, 20302)
+	joiner.PingBootstrap(context.Background(), "127.0.0.1", 20301)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for countKeys(joiner, keys) < len(keys) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := countKeys(joiner, keys); got != len(keys) {
+		t.Errorf("got %d keys handed off, wanted %d", got, len(keys))
+	}
+
+	// seeing the same node again is not a new contact
+	holder.Router.AddContact(joiner.Self)
+	if got := len(holder.handoffQueue); got != 0 {
+		t.Errorf("got %d queued handoffs, wanted 0 for a known contact", got)
+	}
+}
+
+func TestHandoffIsRateLimited(t *testing.T) {
+	holder, err := NewServer("127.0.0.1", 20303)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	limits := DefaultLimitsConfig()
+	limits.Handoff = RateSpec{Rate: 0.5, Burst: 2}
+	holder.SetLimits(limits)
+	go holder.Run()
+
+	var keys []string
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		holder.StoreLocal(key, []byte("v"))
+	}
+
+	joiner := startHonestNode(t, 20304)
+	joiner.PingBootstrap(context.Background(), "127.0.0.1", 20303)
+
+	time.Sleep(time.Second)
+	if got := countKeys(joiner, keys); got == 0 || got > 3 {
+		t.Errorf("got %d keys after 1s, wanted the burst of 2 (maybe 3)", got)
+	}
+}
+
+func TestNoHandoffToUnansweringContact(t *testing.T) {
+	holder := startHonestNode(t, 20305)
+	holder.Protocol.RPCTimeout = 200 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		holder.StoreLocal(fmt.Sprintf("key-%d", i), []byte("v"))
+	}
+
+	// some host that doesn't speak the protocol, named in a FIND_NODE reply
+	victim, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 20306})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer victim.Close()
+	named, _ := node.NewNodeFromIPAndport("127.0.0.1", 20306)
+	holder.admitContact(named)
+
+	var got []transport.RPCDescriptor
+	buf := make([]byte, transport.UDP_BUFFER_SIZE)
+	victim.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, _, err := victim.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		var msg transport.RPCMessage
+		json.Unmarshal(buf[:n], &msg)
+		got = append(got, msg.Type)
+	}
+	if len(got) != 1 || got[0] != transport.RPCPing {
+		t.Errorf("got %v sent to the named address, wanted a single PING", got)
+	}
+}
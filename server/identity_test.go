@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"cs249-dht/node"
+	"cs249-dht/transport"
+)
+
+var testPuzzle = node.PuzzleDifficulty{Static: 4, Dynamic: 4}
+
+func TestUnprovenNodeRefusedRoutingSlot(t *testing.T) {
+	identity, _, err := node.GenerateIdentity("127.0.0.1", 19301, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	server, err := NewServerWithIdentity("127.0.0.1", 19301, identity, testPuzzle)
+	if err != nil {
+		t.Fatalf("NewServerWithIdentity: %v", err)
+	}
+	go server.Run()
+
+	// a legacy node, no identity at all. The server drops unsigned RPCs
+	// without answering, so don't wait for a Pong.
+	legacy, err := NewServer("127.0.0.1", 19302)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	serverAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 19301}
+	if err := legacy.sendDirectRPC(legacy.newRPC(transport.RPCPing), serverAddr); err != nil {
+		t.Fatalf("sendDirectRPC: %v", err)
+	}
+
+	// a node with an identity that does not solve the puzzle
+	weakIdentity, _, err := node.GenerateIdentity("127.0.0.1", 19303, node.PuzzleDifficulty{})
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	weak, err := NewServerWithIdentity("127.0.0.1", 19303, weakIdentity, node.PuzzleDifficulty{})
+	if err != nil {
+		t.Fatalf("NewServerWithIdentity: %v", err)
+	}
+	weak.PingBootstrap(context.Background(), "127.0.0.1", 19301)
+
+	// a proper node
+	goodIdentity, _, err := node.GenerateIdentity("127.0.0.1", 19304, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	good, err := NewServerWithIdentity("127.0.0.1", 19304, goodIdentity, testPuzzle)
+	if err != nil {
+		t.Fatalf("NewServerWithIdentity: %v", err)
+	}
+	good.PingBootstrap(context.Background(), "127.0.0.1", 19301)
+
+	waitForRouting()
+
+	if !server.Router.IsNewNode(legacy.Self) {
+		t.Errorf("legacy node got a routing slot, wanted refused")
+	}
+	if server.Router.IsNewNode(good.Self) {
+		t.Errorf("node with a valid proof was refused a routing slot")
+	}
+	if good.Router.IsNewNode(server.Self) {
+		t.Errorf("server was not added to good node's routing table")
+	}
+	if node.VerifyNodeProof(weak.Self, testPuzzle) == nil {
+		t.Skip("weak identity happens to solve the test puzzle")
+	}
+	if !server.Router.IsNewNode(weak.Self) {
+		t.Errorf("node without a valid proof got a routing slot, wanted refused")
+	}
+}
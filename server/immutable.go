@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"cs249-dht/storage"
+	"cs249-dht/transport"
+)
+
+// PutImmutable stores value under its own hash and returns that key.
+func (ln *Server) PutImmutable(ctx context.Context, value []byte) (string, error) {
+	req := storeRequest{
+		Key:       storage.ImmutableKey(value),
+		Value:     value,
+		Immutable: true,
+	}
+
+	if _, err := ln.replicate(ctx, req); err != nil {
+		return "", ctxOr(ctx, fmt.Errorf("PutImmutable: %w", err))
+	}
+	return req.Key, nil
+}
+
+// GetImmutable fetches the value addressed by key. Copies that don't hash to
+// key are discarded whether or not the peer flagged them as immutable, and
+// the lookup moves on to the next peer.
+func (ln *Server) GetImmutable(ctx context.Context, key string) ([]byte, error) {
+	if stored, ok := ln.Store.Get(key); ok && storage.VerifyImmutable(key, stored.Value) == nil {
+		return stored.Value, nil
+	}
+
+	resp, err := ln.lookupValue(ctx, key, func(resp *transport.RPCMessage) error {
+		return storage.VerifyImmutable(key, resp.Value)
+	})
+	if err != nil {
+		return nil, ctxOr(ctx, fmt.Errorf("GetImmutable: %w", err))
+	}
+	return resp.Value, nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"cs249-dht/storage"
+	"cs249-dht/transport"
+)
+
+// startForgingNode runs a node that answers every FIND_VALUE with a value
+// that claims to be content-addressed but doesn't match the key.
+func startForgingNode(t *testing.T, port int) *Server {
+	forger, err := NewServer("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	go forger.Transport.ListenRPC(func(msg *transport.RPCMessage, from *net.UDPAddr) {
+		switch msg.Type {
+		case transport.RPCPing:
+			forger.sendDirectRPC(forger.newReply(msg, transport.RPCPong), from)
+
+		case transport.RPCFindValue:
+			resp := forger.newReply(msg, transport.RPCFindValue)
+			resp.Key = msg.Key
+			resp.Value = []byte("forged")
+			resp.Immutable = true
+			forger.sendDirectRPC(resp, from)
+		}
+	})
+
+	return forger
+}
+
+func TestImmutableStoreChecksHash(t *testing.T) {
+	ln, err := NewServer("127.0.0.1", 19901)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	value := []byte("content")
+	key := storage.ImmutableKey(value)
+
+	err = ln.putStored(storeRequest{Key: key, Value: []byte("other"), Immutable: true}, "publisher")
+	if !errors.Is(err, storage.ErrHashMismatch) {
+		t.Errorf("got %v, wanted %v", err, storage.ErrHashMismatch)
+	}
+
+	if err := ln.putStored(storeRequest{Key: key, Value: value, Immutable: true}, "publisher"); err != nil {
+		t.Fatalf("got %v, wanted matching value to be stored", err)
+	}
+	if err := ln.putStored(storeRequest{Key: key, Value: []byte("other")}, "publisher"); !errors.Is(err, storage.ErrKeyIsImmutable) {
+		t.Errorf("got %v, wanted %v", err, storage.ErrKeyIsImmutable)
+	}
+}
+
+func TestGetImmutableSkipsForgedValues(t *testing.T) {
+	storer := startHonestNode(t, 19902)
+	startForgingNode(t, 19903)
+	client := startHonestNode(t, 19904)
+
+	value := []byte("the real thing")
+	key := storage.ImmutableKey(value)
+	if err := storer.putStored(storeRequest{Key: key, Value: value, Immutable: true}, storer.Self.HexID()); err != nil {
+		t.Fatalf("putStored: %v", err)
+	}
+
+	// the forger answers every FIND_VALUE with junk
+	if _, _, err := client.FindValueOnce(context.Background(), key, "127.0.0.1", 19903); !errors.Is(err, storage.ErrHashMismatch) {
+		t.Errorf("got %v, wanted %v from the forger", err, storage.ErrHashMismatch)
+	}
+
+	client.PingBootstrap(context.Background(), "127.0.0.1", 19902)
+	client.PingBootstrap(context.Background(), "127.0.0.1", 19903)
+
+	got, err := client.GetImmutable(context.Background(), key)
+	if err != nil {
+		t.Fatalf("GetImmutable: %v", err)
+	}
+	if string(got) != string(value) {
+		t.Errorf("got %q, wanted %q", got, value)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// LevelOff is above every level a node logs at, so a logger at LevelOff
+// writes nothing.
+const LevelOff = slog.LevelError + 4
+
+// LogConfig says what a node logs and how, for New to build its logger
+// from when Config.Logger is nil.
+type LogConfig struct {
+	// least severe level written, LevelOff for nothing
+	Level slog.Level
+	// text or json
+	Format string
+}
+
+// DefaultLogConfig returns a silent configuration: a library shouldn't
+// write to stderr unless asked to.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{Level: LevelOff, Format: "text"}
+}
+
+// Validate reports an unknown format.
+func (c LogConfig) Validate() error {
+	if c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("log format must be text or json, got %q", c.Format)
+	}
+	return nil
+}
+
+// ParseLogLevel maps debug, info, warn, error or off to its level.
+func ParseLogLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "off") {
+		return LevelOff, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, wanted debug, info, warn, error or off", name)
+	}
+	return level, nil
+}
+
+// FormatLogLevel is the inverse of ParseLogLevel.
+func FormatLogLevel(level slog.Level) string {
+	if level >= LevelOff {
+		return "off"
+	}
+	return strings.ToLower(level.String())
+}
+
+// NewLogger returns a logger writing the records at level and above to w,
+// as logfmt-style text or as one JSON object per line. level may be a
+// *slog.LevelVar to change it while the node runs.
+func NewLogger(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// peerAddr is how log records name the peer at ip:port.
+func peerAddr(ip string, port int) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// SetLogger makes the node, its routing table and its transport log to
+// logger, with the node's ID on every record.
+func (ln *Server) SetLogger(logger *slog.Logger) {
+	ln.Logger = logger.With("node", ln.Self.HexID())
+	ln.Router.SetLogger(ln.Logger)
+	ln.Transport.SetLogger(ln.Logger)
+}
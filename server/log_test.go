@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, name := range []string{"debug", "info", "warn", "error", "off"} {
+		level, err := ParseLogLevel(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := FormatLogLevel(level); got != name {
+			t.Errorf("got %q back for %q", got, name)
+		}
+	}
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Errorf("got nil error for an unknown level, wanted error")
+	}
+}
+
+func TestLogSettings(t *testing.T) {
+	cfg, err := LoadConfig("", []string{"DHT_LOG_LEVEL=debug"}, ConfigFlags{"log_format": "json"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Log.Level != slog.LevelDebug || cfg.Log.Format != "json" {
+		t.Errorf("got %+v, wanted debug as json", cfg.Log)
+	}
+	if DefaultConfig().Log.Level != LevelOff {
+		t.Errorf("got a default level of %v, wanted off", DefaultConfig().Log.Level)
+	}
+	if _, err := LoadConfig("", nil, ConfigFlags{"log_format": "xml"}); err == nil {
+		t.Errorf("got nil error for an unknown format, wanted error")
+	}
+}
+
+func TestLogRecordsCarryNodeAndPeer(t *testing.T) {
+	a := startHonestNode(t, 21111)
+	defer a.Close()
+	b := startHonestNode(t, 21112)
+	defer b.Close()
+
+	var buf bytes.Buffer
+	b.SetLogger(NewLogger(&buf, "json", slog.LevelDebug))
+	if _, _, err := b.Ping(context.Background(), "127.0.0.1", 21111); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	var record struct {
+		Level  string `json:"level"`
+		Msg    string `json:"msg"`
+		Node   string `json:"node"`
+		Peer   string `json:"peer"`
+		PeerID string `json:"peer_id"`
+	}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("decoding %q: %v", buf.String(), err)
+		}
+		if record.Msg == "ping answered" {
+			break
+		}
+	}
+	if record.Msg != "ping answered" {
+		t.Fatalf("got no ping record, wanted one")
+	}
+	if record.Level != "DEBUG" || record.Node != b.Self.HexID() || record.Peer != "127.0.0.1:21111" || record.PeerID != a.Self.HexID() {
+		t.Errorf("got %+v, wanted a debug record naming both nodes", record)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// Maintain runs one round of housekeeping: expired provider records,
+// tombstones, peers' write tokens and idle secure channel sessions are
+// dropped, and values nobody has stored here for
+// Protocol.RepublishInterval are pushed out to the closest nodes again.
+func (ln *Server) Maintain(ctx context.Context) {
+	ln.Providers.Expire()
+
+	if n := ln.Store.ExpireTombstones(); n > 0 {
+		ln.Logger.Debug("dropped expired tombstones", "count", n)
+	}
+	if n := ln.expireTokens(); n > 0 {
+		ln.Logger.Debug("dropped stale write tokens", "count", n)
+	}
+	if n := ln.Transport.ExpireSessions(); n > 0 {
+		ln.Logger.Debug("dropped idle secure sessions", "count", n)
+	}
+
+	ln.republish(ctx)
+}
+
+func (ln *Server) maintenanceLoop() {
+	ticker := time.NewTicker(ln.Protocol.MaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ln.Maintain(ln.ctx)
+		case <-ln.ctx.Done():
+			return
+		}
+	}
+}
+
+// republish re-sends every value that hasn't been stored here within
+// Protocol.RepublishInterval. A STORE from someone else resets the clock, so
+// normally only one replica republishes each key per interval (Kademlia
+// paper, section 2.5). The original publisher travels with the value, so a
+// replica can't revive a value its publisher has deleted.
+//
+// Erasure-coded fragments are not republished themselves, as that would
+// copy each one to k nodes; instead whoever republishes the manifest
+// rebuilds and re-stores the fragments that went missing.
+func (ln *Server) republish(ctx context.Context) {
+	for _, item := range ln.Store.StoredBefore(ln.Store.Now().Add(-ln.Protocol.RepublishInterval)) {
+		if item.Fragment {
+			continue
+		}
+		req := storeRequestFromStored(item)
+		if _, err := ln.replicate(ctx, req); err != nil {
+			ln.Logger.Warn("republishing", "key", item.Key, "err", err)
+		}
+		if item.Erasure {
+			if err := ln.repairErasure(ctx, item); err != nil {
+				ln.Logger.Warn("repairing fragments", "key", item.Key, "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMaintainRepublishes(t *testing.T) {
+	replica := startHonestNode(t, 20201)
+	holder := startHonestNode(t, 20202)
+	holder.PingBootstrap(context.Background(), "127.0.0.1", 20201)
+	waitForRouting() // let the handoff to the new contact finish first
+
+	if err := holder.putStored(storeRequest{Key: "old", Value: []byte("v")}, "some-publisher"); err != nil {
+		t.Fatalf("putStored: %v", err)
+	}
+
+	// nothing is due yet
+	holder.Maintain(context.Background())
+	waitForRouting()
+	if _, ok := replica.GetLocal("old"); ok {
+		t.Fatalf("value republished before REPUBLISH_INTERVAL")
+	}
+
+	later := time.Now().Add(REPUBLISH_INTERVAL + time.Minute)
+	holder.Store.SetClock(func() time.Time { return later })
+	holder.Maintain(context.Background())
+	waitForRouting()
+
+	got, ok := replica.Store.Get("old")
+	if !ok || string(got.Value) != "v" {
+		t.Fatalf("got %q (found=%t), wanted republished value", got.Value, ok)
+	}
+	if got.Publisher != "some-publisher" {
+		t.Errorf("got publisher %q, wanted the original publisher kept", got.Publisher)
+	}
+}
+
+func TestMaintainDropsStaleTokens(t *testing.T) {
+	holder := startHonestNode(t, 20203)
+
+	holder.rememberToken("127.0.0.1", 1, "fresh")
+	holder.rememberToken("127.0.0.1", 2, "stale")
+	holder.peerTokensMu.Lock()
+	pt := holder.peerTokens["127.0.0.1:2"]
+	pt.expiresAt = time.Now().Add(-TOKEN_ROTATION - time.Second)
+	holder.peerTokens["127.0.0.1:2"] = pt
+	holder.peerTokensMu.Unlock()
+
+	holder.Maintain(context.Background())
+
+	holder.peerTokensMu.Lock()
+	defer holder.peerTokensMu.Unlock()
+	if _, ok := holder.peerTokens["127.0.0.1:2"]; ok {
+		t.Errorf("got the stale token kept, wanted it dropped")
+	}
+	if _, ok := holder.peerTokens["127.0.0.1:1"]; !ok {
+		t.Errorf("got the fresh token dropped, wanted it kept")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"cs249-dht/metrics"
+	"cs249-dht/transport"
+)
+
+// Metrics is what a node counts about itself, exposed in the Prometheus
+// text format by Registry. Routing table and store figures are read from
+// them on each scrape.
+type Metrics struct {
+	Registry *metrics.Registry
+
+	rpcsSent     *metrics.Counter
+	rpcsReceived *metrics.Counter
+	rpcTimeouts  *metrics.Counter
+	rpcRTT       *metrics.Histogram
+
+	lookupDuration *metrics.Histogram
+	lookupHops     *metrics.Histogram
+}
+
+func newMetrics(ln *Server) *Metrics {
+	r := metrics.NewRegistry()
+	m := &Metrics{
+		Registry: r,
+		rpcsSent: r.NewCounter("dht_rpcs_sent_total",
+			"RPC messages sent, requests and replies, by type.", "type"),
+		rpcsReceived: r.NewCounter("dht_rpcs_received_total",
+			"RPC messages received, requests and replies, by type.", "type"),
+		rpcTimeouts: r.NewCounter("dht_rpc_timeouts_total",
+			"Requests that got no reply in time, by type.", "type"),
+		rpcRTT: r.NewHistogram("dht_rpc_rtt_seconds",
+			"Round trip time of answered requests, by type.",
+			metrics.ExponentialBuckets(0.0005, 2, 14), "type"),
+		lookupDuration: r.NewHistogram("dht_lookup_duration_seconds",
+			"How long iterative lookups took, by kind (node or value); each path of a disjoint lookup counts.",
+			metrics.ExponentialBuckets(0.001, 2, 14), "kind"),
+		lookupHops: r.NewHistogram("dht_lookup_hops",
+			"Rounds of queries iterative lookups needed, by kind (node or value).",
+			metrics.LinearBuckets(1, 1, 10), "kind"),
+	}
+
+	r.NewGaugeFunc("dht_routing_buckets", "k-buckets in the routing table.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(len(ln.Router.Buckets())))
+		})
+	r.NewGaugeFunc("dht_routing_bucket_capacity", "Contacts a k-bucket holds (k).", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ln.Protocol.Routing.K))
+		})
+	r.NewGaugeFunc("dht_routing_bucket_contacts",
+		"Contacts in each k-bucket, numbered from the one covering the lowest IDs.", []string{"bucket"},
+		func(emit func(float64, ...string)) {
+			for i, b := range ln.Router.Buckets() {
+				emit(float64(len(b.Contacts)), strconv.Itoa(i))
+			}
+		})
+	r.NewGaugeFunc("dht_routing_contacts", "Contacts in the routing table.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ln.Router.Size()))
+		})
+	r.NewGaugeFunc("dht_routing_replacements", "Contacts waiting in the replacement caches of full buckets.", nil,
+		func(emit func(float64, ...string)) {
+			n := 0
+			for _, b := range ln.Router.Buckets() {
+				n += len(b.Replacements)
+			}
+			emit(float64(n))
+		})
+
+	r.NewGaugeFunc("dht_store_keys", "Values in the local store.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ln.Store.Stats().Keys))
+		})
+	r.NewGaugeFunc("dht_store_bytes", "Bytes of values in the local store.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ln.Store.Stats().Bytes))
+		})
+	r.NewGaugeFunc("dht_store_tombstones", "Deleted keys remembered so that old replicas don't revive them.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ln.Store.Stats().Tombstones))
+		})
+	r.NewCounterFunc("dht_store_evictions_total", "Values evicted to make room under the store's size cap.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ln.Store.Stats().Evictions))
+		})
+	r.NewCounterFunc("dht_store_rejections_total", "Values refused by a publisher quota or the size cap.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ln.Store.Stats().Rejections))
+		})
+	r.NewCounterFunc("dht_rpcs_throttled_total", "Requests refused by the per-peer rate limit, by type.", []string{"type"},
+		func(emit func(float64, ...string)) {
+			for typ, n := range ln.Limiter.Stats().Throttled {
+				emit(float64(n), rpcLabel(typ))
+			}
+		})
+	return m
+}
+
+// rpcLabel is the label value of an RPC type, e.g. find_node.
+func rpcLabel(t transport.RPCDescriptor) string {
+	return strings.ReplaceAll(strings.ToLower(t.String()), " ", "_")
+}
+
+func (m *Metrics) sent(msg *transport.RPCMessage) {
+	m.rpcsSent.Inc(rpcLabel(msg.Type))
+}
+
+func (m *Metrics) received(msg *transport.RPCMessage) {
+	m.rpcsReceived.Inc(rpcLabel(msg.Type))
+}
+
+func (m *Metrics) answered(req *transport.RPCMessage, rtt time.Duration) {
+	m.rpcRTT.Observe(rtt.Seconds(), rpcLabel(req.Type))
+}
+
+func (m *Metrics) timedOut(req *transport.RPCMessage) {
+	m.rpcTimeouts.Inc(rpcLabel(req.Type))
+}
+
+func (m *Metrics) lookedUp(kind string, took time.Duration, hops int) {
+	m.lookupDuration.Observe(took.Seconds(), kind)
+	m.lookupHops.Observe(float64(hops), kind)
+}
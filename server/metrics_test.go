@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestMetricsCountRPCsAndLookups(t *testing.T) {
+	a := startHonestNode(t, 21101)
+	defer a.Close()
+	b := startHonestNode(t, 21102)
+	defer b.Close()
+	ctx := context.Background()
+
+	if err := b.PingBootstrap(ctx, "127.0.0.1", 21101); err != nil {
+		t.Fatalf("PingBootstrap: %v", err)
+	}
+	if _, err := b.LookupNodes(ctx, big.NewInt(1)); err != nil {
+		t.Fatalf("LookupNodes: %v", err)
+	}
+	// nobody listens here
+	b.Ping(ctx, "127.0.0.1", 21103)
+
+	if got := b.Metrics.rpcsSent.Value("ping"); got != 2 {
+		t.Errorf("got %v pings sent, wanted 2", got)
+	}
+	if got := b.Metrics.rpcTimeouts.Value("ping"); got != 1 {
+		t.Errorf("got %v ping timeouts, wanted 1", got)
+	}
+	if got := b.Metrics.rpcRTT.Count("ping"); got != 1 {
+		t.Errorf("got %d ping round trips, wanted 1", got)
+	}
+	if got := b.Metrics.lookupHops.Count("node"); got != 1 {
+		t.Errorf("got %d node lookups, wanted 1", got)
+	}
+	if got := a.Metrics.rpcsReceived.Value("find_node"); got != 1 {
+		t.Errorf("got %v find_node received, wanted 1", got)
+	}
+
+	var scrape strings.Builder
+	b.Metrics.Registry.WriteText(&scrape)
+	for _, line := range []string{
+		`dht_rpcs_sent_total{type="find_node"} 1`,
+		`dht_routing_contacts 1`,
+		`dht_routing_bucket_contacts{bucket="0"} 1`,
+		`dht_lookup_hops_bucket{kind="node",le="1"} 1`,
+		`dht_store_keys 0`,
+	} {
+		if !strings.Contains(scrape.String(), line+"\n") {
+			t.Errorf("got no %s in\n%s", line, scrape.String())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+
+	"cs249-dht/node"
+	"cs249-dht/storage"
+)
+
+// PutMutable signs value with priv at sequence number seq and stores it under
+// MutableKey(public key, salt). If cas is non-nil the write only succeeds
+// where the stored item currently has sequence number *cas.
+func (ln *Server) PutMutable(ctx context.Context, priv ed25519.PrivateKey, salt []byte, value []byte, seq int64, cas *int64) (string, error) {
+	item := storage.SignMutable(priv, salt, seq, value)
+	meta := item.MutableMeta
+	meta.CAS = cas
+
+	req := storeRequest{
+		Key:     item.Key(),
+		Value:   value,
+		Mutable: &meta,
+	}
+
+	if _, err := ln.replicate(ctx, req); err != nil {
+		return "", ctxOr(ctx, fmt.Errorf("PutMutable: %w", err))
+	}
+	return req.Key, nil
+}
+
+// GetMutable looks up the item signed by pub under salt and returns the
+// valid copy with the highest sequence number, from us or the k closest nodes.
+func (ln *Server) GetMutable(ctx context.Context, pub ed25519.PublicKey, salt []byte) (*storage.MutableItem, error) {
+	key := storage.MutableKey(pub, salt)
+
+	var best *storage.MutableItem
+	consider := func(value []byte, meta *storage.MutableMeta) {
+		if meta == nil {
+			return
+		}
+		item := &storage.MutableItem{MutableMeta: *meta, Value: value}
+		item.CAS = nil
+		if !bytes.Equal(item.PublicKey, pub) || item.Verify(key) != nil {
+			return
+		}
+		if best == nil || item.Seq > best.Seq {
+			best = item
+		}
+	}
+
+	if stored, ok := ln.Store.Get(key); ok {
+		consider(stored.Value, stored.Mutable)
+	}
+
+	nodes, err := ln.LookupNodes(ctx, node.KeyID(key))
+	if err != nil && best == nil {
+		return nil, ctxOr(ctx, fmt.Errorf("GetMutable: %w", err))
+	}
+	for _, n := range nodes {
+		resp, _, err := ln.findValueOnce(ctx, key, nil, n.IP(), n.Port())
+		if err != nil || resp == nil {
+			continue
+		}
+		consider(resp.Value, resp.Mutable)
+	}
+
+	if best == nil {
+		return nil, ctxOr(ctx, fmt.Errorf("GetMutable: no valid item found for %s", key))
+	}
+	return best, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"cs249-dht/storage"
+)
+
+func newMutableKey(t *testing.T) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return priv
+}
+
+func TestMutableSeqAndCAS(t *testing.T) {
+	ln, err := NewServer("127.0.0.1", 19801)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	priv := newMutableKey(t)
+	put := func(seq int64, value string, cas *int64) error {
+		item := storage.SignMutable(priv, nil, seq, []byte(value))
+		item.CAS = cas
+		return ln.putStored(storeRequest{Key: item.Key(), Value: item.Value, Mutable: &item.MutableMeta}, "publisher")
+	}
+	seqOf := func(n int64) *int64 { return &n }
+
+	if err := put(2, "two", nil); err != nil {
+		t.Fatalf("got %v, wanted first put to succeed", err)
+	}
+	if err := put(1, "one", nil); !errors.Is(err, storage.ErrSeqTooLow) {
+		t.Errorf("got %v, wanted %v", err, storage.ErrSeqTooLow)
+	}
+	if err := put(3, "three", seqOf(1)); !errors.Is(err, storage.ErrCASMismatch) {
+		t.Errorf("got %v, wanted %v", err, storage.ErrCASMismatch)
+	}
+	if err := put(3, "three", seqOf(2)); err != nil {
+		t.Errorf("got %v, wanted CAS put to succeed", err)
+	}
+
+	key := storage.MutableKey(priv.Public().(ed25519.PublicKey), nil)
+	got, ok := ln.GetLocal(key)
+	if !ok || string(got) != "three" {
+		t.Errorf("got %q (found=%t), wanted %q", got, ok, "three")
+	}
+
+	// plain STOREs can't clobber a signed item
+	if err := ln.putStored(storeRequest{Key: key, Value: []byte("junk")}, "publisher"); !errors.Is(err, storage.ErrKeyIsMutable) {
+		t.Errorf("got %v, wanted %v", err, storage.ErrKeyIsMutable)
+	}
+}
+
+func TestPutGetMutable(t *testing.T) {
+	storer := startHonestNode(t, 19802)
+	client := startHonestNode(t, 19803)
+	client.PingBootstrap(context.Background(), "127.0.0.1", 19802)
+
+	priv := newMutableKey(t)
+	pub := priv.Public().(ed25519.PublicKey)
+
+	if _, err := client.PutMutable(context.Background(), priv, []byte("s"), []byte("v1"), 1, nil); err != nil {
+		t.Fatalf("PutMutable: %v", err)
+	}
+	waitForRouting()
+
+	// the storer has it, and a stale CAS is refused remotely
+	if _, ok := storer.GetLocal(storage.MutableKey(pub, []byte("s"))); !ok {
+		t.Errorf("storer does not have the mutable item")
+	}
+	stale := int64(0)
+	if _, err := client.PutMutable(context.Background(), priv, []byte("s"), []byte("v2"), 2, &stale); err == nil {
+		t.Errorf("got nil error, wanted CAS mismatch")
+	}
+
+	item, err := storer.GetMutable(context.Background(), pub, []byte("s"))
+	if err != nil {
+		t.Fatalf("GetMutable: %v", err)
+	}
+	if item.Seq != 1 || string(item.Value) != "v1" {
+		t.Errorf("got seq=%d value=%q, wanted seq=1 value=%q", item.Seq, item.Value, "v1")
+	}
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/routing"
+	"cs249-dht/transport"
+)
+
+// ProviderRecord says that the node ID at IP:Port has what a key names,
+// until ExpiresAt.
+type ProviderRecord struct {
+	ID        string
+	IP        string
+	Port      int
+	ExpiresAt time.Time
+}
+
+func providerToRPC(rec ProviderRecord, now time.Time) transport.RPCProvider {
+	return transport.RPCProvider{
+		ID:         rec.ID,
+		IP:         rec.IP,
+		Port:       rec.Port,
+		TTLSeconds: int64(rec.ExpiresAt.Sub(now) / time.Second),
+	}
+}
+
+func providerFromRPC(p transport.RPCProvider, now time.Time) ProviderRecord {
+	return ProviderRecord{
+		ID:        p.ID,
+		IP:        p.IP,
+		Port:      p.Port,
+		ExpiresAt: now.Add(time.Duration(p.TTLSeconds) * time.Second),
+	}
+}
+
+// ProviderStore keeps a set of provider records per key, unlike ValueStore
+// where a STORE replaces the value. Records expire one by one, each key
+// holds at most maxPerKey of them and the store at most maxRecords, 0 =
+// unlimited.
+type ProviderStore struct {
+	mu         sync.Mutex
+	records    map[string]map[string]ProviderRecord // key -> provider ID -> record
+	count      int
+	maxPerKey  int
+	maxRecords int
+	now        func() time.Time // swapped out by tests
+}
+
+func NewProviderStore(maxPerKey, maxRecords int) *ProviderStore {
+	return &ProviderStore{
+		records:    make(map[string]map[string]ProviderRecord),
+		maxPerKey:  maxPerKey,
+		maxRecords: maxRecords,
+		now:        time.Now,
+	}
+}
+
+// Add records rec as a provider of key, refreshing it if it was already
+// there. When the key's set or the whole store is full the record closest
+// to expiring makes way, unless rec would expire even sooner.
+func (ps *ProviderStore) Add(key string, rec ProviderRecord) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := ps.now()
+	if !rec.ExpiresAt.After(now) {
+		return
+	}
+	set := ps.live(key, now)
+	if set == nil {
+		set = make(map[string]ProviderRecord)
+		ps.records[key] = set
+	}
+
+	_, known := set[rec.ID]
+	if !known && ps.maxPerKey > 0 && len(set) >= ps.maxPerKey {
+		var soonest string
+		for id, r := range set {
+			if soonest == "" || r.ExpiresAt.Before(set[soonest].ExpiresAt) {
+				soonest = id
+			}
+		}
+		if !rec.ExpiresAt.After(set[soonest].ExpiresAt) {
+			return
+		}
+		delete(set, soonest)
+		ps.count--
+	}
+	if !known && ps.maxRecords > 0 && ps.count >= ps.maxRecords {
+		soonestKey, soonestID := "", ""
+		for k, s := range ps.records {
+			for id, r := range s {
+				if soonestKey == "" || r.ExpiresAt.Before(ps.records[soonestKey][soonestID].ExpiresAt) {
+					soonestKey, soonestID = k, id
+				}
+			}
+		}
+		if !rec.ExpiresAt.After(ps.records[soonestKey][soonestID].ExpiresAt) {
+			if len(set) == 0 {
+				delete(ps.records, key)
+			}
+			return
+		}
+		delete(ps.records[soonestKey], soonestID)
+		ps.count--
+		if len(ps.records[soonestKey]) == 0 && soonestKey != key {
+			delete(ps.records, soonestKey)
+		}
+	}
+	if !known {
+		ps.count++
+	}
+	set[rec.ID] = rec
+}
+
+// Get returns the unexpired providers of key, longest-lived first.
+func (ps *ProviderStore) Get(key string) []ProviderRecord {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	set := ps.live(key, ps.now())
+	out := make([]ProviderRecord, 0, len(set))
+	for _, rec := range set {
+		out = append(out, rec)
+	}
+	sortProviders(out)
+	return out
+}
+
+// Expire drops every expired record.
+func (ps *ProviderStore) Expire() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := ps.now()
+	for key := range ps.records {
+		ps.live(key, now)
+	}
+}
+
+// live prunes expired records of key and returns what is left, or nil.
+// Caller must hold ps.mu.
+func (ps *ProviderStore) live(key string, now time.Time) map[string]ProviderRecord {
+	set := ps.records[key]
+	for id, rec := range set {
+		if !rec.ExpiresAt.After(now) {
+			delete(set, id)
+			ps.count--
+		}
+	}
+	if len(set) == 0 {
+		delete(ps.records, key)
+		return nil
+	}
+	return set
+}
+
+func sortProviders(recs []ProviderRecord) {
+	sort.Slice(recs, func(i, j int) bool {
+		if !recs[i].ExpiresAt.Equal(recs[j].ExpiresAt) {
+			return recs[i].ExpiresAt.After(recs[j].ExpiresAt)
+		}
+		return recs[i].ID < recs[j].ID
+	})
+}
+
+// handleAnnounceRPC records the sender as a provider of msg.Key. Like STORE
+// (and BEP 5 announce_peer) it needs a write token, and the address recorded
+// is the one the announcement came from. The sender ID must be proven, as
+// it names the record.
+func (ln *Server) handleAnnounceRPC(msg *transport.RPCMessage, from *net.UDPAddr) {
+	ln.Logger.Debug("got ANNOUNCE", "peer", from.String(), "key", msg.Key)
+
+	if msg.Key == "" {
+		ln.Logger.Warn("ANNOUNCE with empty key, ignoring", "peer", from.String())
+		return
+	}
+	if !ln.Tokens.Validate(msg.Token, from) {
+		ln.Logger.Warn("ANNOUNCE without a valid token, rejecting", "peer", from.String(), "key", msg.Key)
+		ln.sendErrorRPC(msg, from, "invalid or expired store token")
+		return
+	}
+	// the record is keyed by FromID, so nobody may announce under another node's
+	if !provenSender(msg, from) {
+		ln.Logger.Warn("ANNOUNCE from an unproven sender ID, rejecting", "peer", from.String(), "key", msg.Key)
+		ln.sendErrorRPC(msg, from, "announce needs a signed rpc or a sender ID matching its address")
+		return
+	}
+
+	ttl := time.Duration(msg.TTLSeconds) * time.Second
+	if ttl <= 0 || ttl > PROVIDER_TTL {
+		ttl = PROVIDER_TTL
+	}
+	ln.Providers.Add(msg.Key, ProviderRecord{
+		ID:        msg.FromID,
+		IP:        from.IP.String(),
+		Port:      from.Port,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+
+	ack := ln.newReply(msg, transport.RPCAnnounce)
+	ack.Key = msg.Key
+	if err := ln.sendDirectRPC(ack, from); err != nil {
+		ln.Logger.Warn("sending ANNOUNCE ack", "peer", from.String(), "err", err)
+	}
+}
+
+// handleGetProvidersRPC answers with the providers we know of for msg.Key
+// along with our closest nodes to it, so the requester can keep looking.
+func (ln *Server) handleGetProvidersRPC(msg *transport.RPCMessage, from *net.UDPAddr) {
+	if msg.Key == "" {
+		ln.Logger.Warn("GET_PROVIDERS without a key", "peer", from.String())
+		return
+	}
+
+	targetNode := node.NewNodeFromID(node.KeyID(msg.Key))
+	neighbors := ln.Router.FindNeighbors(targetNode, -1)
+
+	resp := ln.newReply(msg, transport.RPCGetProviders)
+	resp.Key = msg.Key
+	resp.Token = ln.Tokens.Issue(from)
+	for _, n := range neighbors {
+		resp.Nodes = append(resp.Nodes, transport.NodeToRPC(n))
+	}
+	now := time.Now()
+	for _, rec := range ln.Providers.Get(msg.Key) {
+		resp.Providers = append(resp.Providers, providerToRPC(rec, now))
+	}
+
+	if err := ln.sendDirectRPC(resp, from); err != nil {
+		ln.Logger.Warn("sending GET_PROVIDERS response", "peer", from.String(), "err", err)
+	}
+}
+
+// Announce tells the nodes closest to key that we provide it, for ttl (at
+// most PROVIDER_TTL). Returns how many nodes accepted the announcement.
+func (ln *Server) Announce(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	if ttl <= 0 || ttl > PROVIDER_TTL {
+		ttl = PROVIDER_TTL
+	}
+	ln.Providers.Add(key, ProviderRecord{
+		ID:        ln.Self.HexID(),
+		IP:        ln.Self.IP(),
+		Port:      ln.Self.Port(),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+
+	keyID := node.KeyID(key)
+	nodes, err := ln.LookupNodes(ctx, keyID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("Announce: %w", err)
+	}
+
+	announced := 0
+	var lastErr error
+	for _, n := range nodes {
+		token, err := ln.writeToken(ctx, n, keyID)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		msg := ln.newRPC(transport.RPCAnnounce)
+		msg.Key = key
+		msg.Token = token
+		msg.TTLSeconds = int64(ttl / time.Second)
+		if _, err := ln.sendRPC(ctx, n.IP(), n.Port(), msg, ln.Protocol.StoreTimeout); err != nil {
+			ln.Logger.Info("announcing to node", "peer", peerAddr(n.IP(), n.Port()), "key", key, "err", err)
+			ln.forgetToken(n.IP(), n.Port())
+			lastErr = err
+			continue
+		}
+		announced++
+	}
+
+	if err := ctx.Err(); err != nil {
+		return announced, err
+	}
+	if announced == 0 && lastErr != nil {
+		return 0, fmt.Errorf("Announce: %w", lastErr)
+	}
+	return announced, nil
+}
+
+// GetProvidersOnce sends a single GET_PROVIDERS RPC to ip:port, returning
+// the providers it knows of and its closest nodes to key.
+func (ln *Server) GetProvidersOnce(ctx context.Context, key string, ip string, port int) ([]ProviderRecord, []node.Node, error) {
+	msg := ln.newRPC(transport.RPCGetProviders)
+	msg.Key = key
+
+	resp, err := ln.sendRPC(ctx, ip, port, msg, ln.Protocol.RPCTimeout)
+	if err != nil {
+		return nil, nil, ctxOr(ctx, fmt.Errorf("SendRPC GetProviders: %w", err))
+	}
+	ln.rememberToken(ip, port, resp.Token)
+
+	now := time.Now()
+	recs := make([]ProviderRecord, 0, len(resp.Providers))
+	for _, p := range resp.Providers {
+		if p.ID == "" || p.TTLSeconds <= 0 {
+			continue
+		}
+		recs = append(recs, providerFromRPC(p, now))
+	}
+
+	nodes := make([]node.Node, 0, len(resp.Nodes))
+	for _, info := range resp.Nodes {
+		n, err := transport.NodeFromInfo(info)
+		if err != nil {
+			continue
+		}
+		ln.admitContact(n)
+		nodes = append(nodes, n)
+	}
+	return recs, nodes, nil
+}
+
+// GetProviders walks towards key like LookupNodes and merges the provider
+// sets of every node it asks (BEP 5 get_peers style). Returns up to
+// MAX_PROVIDERS_PER_KEY providers, longest-lived first.
+func (ln *Server) GetProviders(ctx context.Context, key string) ([]ProviderRecord, error) {
+	merged := make(map[string]ProviderRecord)
+	merge := func(recs []ProviderRecord) {
+		for _, rec := range recs {
+			if old, ok := merged[rec.ID]; !ok || rec.ExpiresAt.After(old.ExpiresAt) {
+				merged[rec.ID] = rec
+			}
+		}
+	}
+	merge(ln.Providers.Get(key))
+
+	targetNode := node.NewNodeFromID(node.KeyID(key))
+
+	heap := routing.NewBoundedNodeHeap(&targetNode, ln.Protocol.Routing.K)
+	for _, n := range ln.Router.FindNeighbors(targetNode, ln.Protocol.Routing.K) {
+		if n == nil || n.ID() == nil {
+			continue
+		}
+		heap.AddNode(n)
+	}
+	if len(heap.GetUncontacted()) == 0 && len(merged) == 0 {
+		return nil, fmt.Errorf("GetProviders: no known nodes in routing table")
+	}
+
+	for ctx.Err() == nil {
+		uncontacted := heap.GetUncontacted()
+		if len(uncontacted) == 0 {
+			break
+		}
+
+		batch := uncontacted
+		if len(batch) > ln.Protocol.Alpha {
+			batch = batch[:ln.Protocol.Alpha]
+		}
+
+		for _, n := range batch {
+			heap.MarkContacted(n)
+
+			recs, newNodes, err := ln.GetProvidersOnce(ctx, key, n.IP(), n.Port())
+			if err != nil {
+				continue
+			}
+			merge(recs)
+
+			for _, nn := range newNodes {
+				if nn.ID() == nil || nn.ID().Cmp(ln.Self.ID()) == 0 {
+					continue
+				}
+				heap.AddNode(&nn)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]ProviderRecord, 0, len(merged))
+	for _, rec := range merged {
+		out = append(out, rec)
+	}
+	sortProviders(out)
+	if len(out) > MAX_PROVIDERS_PER_KEY {
+		out = out[:MAX_PROVIDERS_PER_KEY]
+	}
+	return out, nil
+}
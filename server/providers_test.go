@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/transport"
+)
+
+func TestProviderStoreExpiryAndCap(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ps := NewProviderStore(2, 0)
+	ps.now = func() time.Time { return now }
+
+	ps.Add("k", ProviderRecord{ID: "a", ExpiresAt: now.Add(time.Minute)})
+	ps.Add("k", ProviderRecord{ID: "b", ExpiresAt: now.Add(3 * time.Minute)})
+
+	// full: c outlives a, so a makes way
+	ps.Add("k", ProviderRecord{ID: "c", ExpiresAt: now.Add(2 * time.Minute)})
+	// full: d would expire first, so it's dropped
+	ps.Add("k", ProviderRecord{ID: "d", ExpiresAt: now.Add(30 * time.Second)})
+
+	got := ps.Get("k")
+	if len(got) != 2 || got[0].ID != "b" || got[1].ID != "c" {
+		t.Fatalf("got %+v, wanted providers b and c", got)
+	}
+
+	// records expire individually
+	now = now.Add(150 * time.Second)
+	got = ps.Get("k")
+	if len(got) != 1 || got[0].ID != "b" {
+		t.Errorf("got %+v, wanted only b left", got)
+	}
+
+	now = now.Add(time.Hour)
+	ps.Expire()
+	if len(ps.records) != 0 {
+		t.Errorf("got %d keys, wanted expired keys to be dropped", len(ps.records))
+	}
+}
+
+func TestProviderStoreGlobalCap(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ps := NewProviderStore(0, 3)
+	ps.now = func() time.Time { return now }
+
+	// one provider announcing under ever more keys
+	for i := 0; i < 3; i++ {
+		ps.Add(fmt.Sprintf("k%d", i), ProviderRecord{ID: "a", ExpiresAt: now.Add(time.Duration(i+1) * time.Minute)})
+	}
+	// full: it outlives k0's record, which makes way
+	ps.Add("k3", ProviderRecord{ID: "a", ExpiresAt: now.Add(10 * time.Minute)})
+	// full: it would expire first, so it's dropped
+	ps.Add("k4", ProviderRecord{ID: "a", ExpiresAt: now.Add(30 * time.Second)})
+	// refreshing a record needs no room
+	ps.Add("k1", ProviderRecord{ID: "a", ExpiresAt: now.Add(20 * time.Minute)})
+
+	for key, want := range map[string]int{"k0": 0, "k1": 1, "k2": 1, "k3": 1, "k4": 0} {
+		if got := len(ps.Get(key)); got != want {
+			t.Errorf("got %d providers of %s, wanted %d", got, key, want)
+		}
+	}
+	if len(ps.records) != 3 || ps.count != 3 {
+		t.Errorf("got %d keys and %d records, wanted 3 of each", len(ps.records), ps.count)
+	}
+
+	now = now.Add(time.Hour)
+	ps.Expire()
+	if ps.count != 0 {
+		t.Errorf("got %d records counted after expiry, wanted 0", ps.count)
+	}
+}
+
+func TestAnnounceAndGetProviders(t *testing.T) {
+	startHonestNode(t, 20001)
+
+	var providers []*Server
+	for port := 20002; port <= 20004; port++ {
+		p := startHonestNode(t, port)
+		p.PingBootstrap(context.Background(), "127.0.0.1", 20001)
+		providers = append(providers, p)
+	}
+	waitForRouting()
+
+	for _, p := range providers {
+		if _, err := p.Announce(context.Background(), "some-file", time.Minute); err != nil {
+			t.Fatalf("Announce: %v", err)
+		}
+	}
+
+	client := startHonestNode(t, 20005)
+	client.PingBootstrap(context.Background(), "127.0.0.1", 20001)
+
+	recs, err := client.GetProviders(context.Background(), "some-file")
+	if err != nil {
+		t.Fatalf("GetProviders: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, rec := range recs {
+		seen[fmt.Sprintf("%s:%d", rec.IP, rec.Port)] = true
+	}
+	for _, p := range providers {
+		addr := fmt.Sprintf("%s:%d", p.Self.IP(), p.Self.Port())
+		if !seen[addr] {
+			t.Errorf("got %v, wanted provider %s", seen, addr)
+		}
+	}
+
+}
+
+func TestAnnounceUnderAnotherIDIsRejected(t *testing.T) {
+	target := startHonestNode(t, 20011)
+	defer target.Close()
+	victim := startHonestNode(t, 20012)
+	defer victim.Close()
+	attacker := startHonestNode(t, 20013)
+	defer attacker.Close()
+	ctx := context.Background()
+
+	victim.PingBootstrap(ctx, "127.0.0.1", 20011)
+	attacker.PingBootstrap(ctx, "127.0.0.1", 20011)
+	if _, err := victim.Announce(ctx, "some-file", time.Minute); err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+
+	// a valid token for the attacker's own address, but the victim's ID
+	token, err := attacker.writeToken(ctx, target.Self, node.KeyID("some-file"))
+	if err != nil {
+		t.Fatalf("writeToken: %v", err)
+	}
+	msg := attacker.newRPC(transport.RPCAnnounce)
+	msg.FromID = victim.Self.HexID()
+	msg.Key = "some-file"
+	msg.Token = token
+	if _, err := attacker.sendRPC(ctx, "127.0.0.1", 20011, msg, time.Second); err == nil {
+		t.Errorf("got nil error announcing under the victim's ID, wanted it rejected")
+	}
+
+	recs := target.Providers.Get("some-file")
+	if len(recs) != 1 || recs[0].ID != victim.Self.HexID() || recs[0].Port != 20012 {
+		t.Errorf("got %+v, wanted only the victim's own record", recs)
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"cs249-dht/node"
+	"cs249-dht/storage"
+	"cs249-dht/transport"
+)
+
+// ConflictPolicy picks the winner when replicas return different values.
+type ConflictPolicy int
+
+const (
+	LatestTimestamp ConflictPolicy = iota // newest publisher timestamp wins
+	HighestSeq                            // highest mutable item sequence number wins
+	Majority                              // the value most replicas returned wins
+	HighestVersion                        // newest logical version wins, as on STORE
+)
+
+// ParseConflictPolicy maps a policy name (version, latest, seq or majority)
+// to its ConflictPolicy.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch name {
+	case "version":
+		return HighestVersion, nil
+	case "latest":
+		return LatestTimestamp, nil
+	case "seq":
+		return HighestSeq, nil
+	case "majority":
+		return Majority, nil
+	}
+	return 0, fmt.Errorf("unknown conflict policy: %s", name)
+}
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case LatestTimestamp:
+		return "latest"
+	case HighestSeq:
+		return "seq"
+	case Majority:
+		return "majority"
+	}
+	return "version"
+}
+
+// QuorumConfig sets how many replicas must take part in an operation.
+type QuorumConfig struct {
+	// STORE acks needed for a write to succeed, 0 = a majority of the
+	// replicas the lookup found
+	Write int
+
+	// values a read gathers from distinct replicas before resolving them,
+	// 0 or 1 = take the first one found
+	Read int
+	// how a read picks among differing values
+	Conflict ConflictPolicy
+	// push the winning value to replicas that returned a losing one or none
+	ReadRepair bool
+}
+
+func DefaultQuorumConfig() QuorumConfig {
+	return QuorumConfig{Write: 0, Read: 1, Conflict: HighestVersion}
+}
+
+// writeQuorum is the number of acks needed when writing to replicas nodes.
+func (q QuorumConfig) writeQuorum(replicas int) int {
+	if q.Write > 0 {
+		return q.Write
+	}
+	return replicas/2 + 1
+}
+
+// ReplicaOutcome is how one replica answered a STORE. Err is nil if it acked.
+type ReplicaOutcome struct {
+	Node node.Node
+	Err  error
+}
+
+// StoreResult reports a replicated write: every replica tried, how many acked
+// and how many had to.
+type StoreResult struct {
+	Replicas []ReplicaOutcome
+	Acks     int
+	Quorum   int
+}
+
+// QuorumError is returned when fewer than Result.Quorum replicas acked.
+type QuorumError struct {
+	Result StoreResult
+}
+
+func (e *QuorumError) Error() string {
+	var failures []string
+	for _, r := range e.Result.Replicas {
+		if r.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s:%d: %v", r.Node.IP(), r.Node.Port(), r.Err))
+		}
+	}
+	return fmt.Sprintf("write quorum not reached: %d of %d replicas acked, needed %d (%s)",
+		e.Result.Acks, len(e.Result.Replicas), e.Result.Quorum, strings.Join(failures, "; "))
+}
+
+// ReplicaValue is the value one replica returned to a read.
+type ReplicaValue struct {
+	Node node.Node
+	storage.StoredValue
+}
+
+// ReadResult reports a quorum read: the winning value, every reply it was
+// chosen from, and the replicas read-repair was sent to. Conflicting is set
+// when replies carried concurrent versions, i.e. publishers wrote the key
+// without seeing each other's write, so the winner may have lost an update.
+type ReadResult struct {
+	Value       []byte
+	Winner      ReplicaValue
+	Replies     []ReplicaValue
+	Repaired    []node.Node
+	Conflicting bool
+}
+
+// ReadQuorumError is returned when fewer than Wanted replicas had a value.
+// The value resolved from the replies that did come back is still returned.
+type ReadQuorumError struct {
+	Got, Wanted int
+}
+
+func (e *ReadQuorumError) Error() string {
+	return fmt.Sprintf("read quorum not reached: %d of %d replicas returned a value", e.Got, e.Wanted)
+}
+
+// resolve returns the index of the winning reply under policy p.
+func (p ConflictPolicy) resolve(replies []ReplicaValue) int {
+	newer := func(a, b ReplicaValue) bool {
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.After(b.Timestamp)
+		}
+		// same time: any deterministic choice keeps replicas agreeing
+		return bytes.Compare(a.Value, b.Value) > 0
+	}
+	seq := func(r ReplicaValue) int64 {
+		if r.Mutable == nil {
+			return -1
+		}
+		return r.Mutable.Seq
+	}
+
+	votes := make(map[string]int)
+	for _, r := range replies {
+		votes[string(r.Value)]++
+	}
+
+	best := 0
+	for i := 1; i < len(replies); i++ {
+		a, b := replies[i], replies[best]
+		switch p {
+		case HighestSeq:
+			if seq(a) != seq(b) {
+				if seq(a) > seq(b) {
+					best = i
+				}
+				continue
+			}
+		case Majority:
+			if votes[string(a.Value)] != votes[string(b.Value)] {
+				if votes[string(a.Value)] > votes[string(b.Value)] {
+					best = i
+				}
+				continue
+			}
+		case HighestVersion:
+			if a.Version != b.Version {
+				if a.Version.Newer(b.Version) {
+					best = i
+				}
+				continue
+			}
+		}
+		if newer(a, b) {
+			best = i
+		}
+	}
+	return best
+}
+
+// ReadValue looks key up and gathers values from Quorum.Read replicas,
+// resolving any disagreement with Quorum.Conflict. Our own copy, if we hold
+// one, counts as one of the replies. With Quorum.ReadRepair the winner is
+// then stored on every replica that returned something else, and on the
+// closest nodes that had nothing.
+func (ln *Server) ReadValue(ctx context.Context, key string) (ReadResult, error) {
+	wanted := ln.Quorum.Read
+	if wanted < 1 {
+		wanted = 1
+	}
+
+	// look at enough nodes to find wanted replicas
+	width := ln.Protocol.Routing.K
+	if wanted > width {
+		width = wanted
+	}
+
+	var result ReadResult
+	if item, ok := ln.Store.Get(key); ok {
+		result.Replies = append(result.Replies, ReplicaValue{Node: ln.Self, StoredValue: item})
+	}
+
+	var empty []node.Node
+	var closest []node.Node
+	if len(result.Replies) < wanted {
+		closest = ln.walkValue(ctx, key, node.KeyID(key), width, func(n node.Node, resp *transport.RPCMessage) bool {
+			if resp == nil {
+				empty = append(empty, n)
+				return false
+			}
+			req := storeRequestFromRPC(resp)
+			result.Replies = append(result.Replies, ReplicaValue{
+				Node: n,
+				StoredValue: storage.StoredValue{
+					Key:       req.Key,
+					Value:     req.Value,
+					Publisher: req.Publisher,
+					Timestamp: req.Timestamp,
+					Version:   req.Version,
+					Mutable:   req.Mutable,
+					Immutable: req.Immutable,
+					Erasure:   req.Erasure,
+					Fragment:  req.Fragment,
+				},
+			})
+			return len(result.Replies) >= wanted
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return ReadResult{}, err
+	}
+
+	if len(result.Replies) == 0 {
+		return result, fmt.Errorf("value for key %q not found", key)
+	}
+	result.Winner = result.Replies[ln.Quorum.Conflict.resolve(result.Replies)]
+	result.Value = result.Winner.Value
+	for i, a := range result.Replies {
+		ln.observeVersion(a.Version)
+		for _, b := range result.Replies[i+1:] {
+			if a.Version.ConcurrentWith(b.Version) {
+				result.Conflicting = true
+			}
+		}
+	}
+
+	if ln.Quorum.ReadRepair {
+		result.Repaired = ln.readRepair(ctx, result, empty, closest)
+	}
+
+	if len(result.Replies) < wanted {
+		return result, &ReadQuorumError{Got: len(result.Replies), Wanted: wanted}
+	}
+	return result, nil
+}
+
+// readRepair stores the winner of result on replicas that returned a
+// different value, and on nodes in closest that returned none.
+func (ln *Server) readRepair(ctx context.Context, result ReadResult, empty []node.Node, closest []node.Node) []node.Node {
+	var stale []node.Node
+	for _, r := range result.Replies {
+		if !bytes.Equal(r.Value, result.Value) {
+			stale = append(stale, r.Node)
+		}
+	}
+	for _, n := range empty {
+		if containsNode(closest, n) {
+			stale = append(stale, n)
+		}
+	}
+
+	req := storeRequestFromStored(result.Winner.StoredValue)
+	keyID := node.KeyID(req.Key)
+
+	var repaired []node.Node
+	for _, n := range stale {
+		if n.HexID() == ln.Self.HexID() {
+			if err := ln.putStored(req, ln.Self.HexID()); err != nil {
+				ln.Logger.Info("read repair of our own copy", "key", req.Key, "err", err)
+				continue
+			}
+			repaired = append(repaired, n)
+			continue
+		}
+		if err := ln.storeToNode(ctx, n, keyID, req); err != nil {
+			ln.Logger.Info("read repair", "peer", peerAddr(n.IP(), n.Port()), "key", req.Key, "err", err)
+			continue
+		}
+		repaired = append(repaired, n)
+	}
+	return repaired
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cs249-dht/storage"
+)
+
+func TestWriteQuorum(t *testing.T) {
+	cases := []struct {
+		config   QuorumConfig
+		replicas int
+		wanted   int
+	}{
+		{QuorumConfig{}, 1, 1},
+		{QuorumConfig{}, 2, 2},
+		{QuorumConfig{}, 5, 3},
+		{QuorumConfig{Write: 1}, 5, 1},
+	}
+	for _, c := range cases {
+		if got := c.config.writeQuorum(c.replicas); got != c.wanted {
+			t.Errorf("got %d, wanted %d for %+v with %d replicas", got, c.wanted, c.config, c.replicas)
+		}
+	}
+}
+
+func TestStoreValueQuorum(t *testing.T) {
+	startHonestNode(t, 20401)
+
+	// a replica that is out of space and refuses every STORE
+	full, err := NewServer("127.0.0.1", 20402)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	limits := DefaultLimitsConfig()
+	limits.MaxStoreBytes = 1
+	limits.Eviction = storage.EvictNone
+	full.SetLimits(limits)
+	go full.Run()
+
+	client := startHonestNode(t, 20403)
+	client.PingBootstrap(context.Background(), "127.0.0.1", 20401)
+	client.PingBootstrap(context.Background(), "127.0.0.1", 20402)
+
+	client.Quorum.Write = 2
+	result, err := client.StoreValue(context.Background(), "key", []byte("value"))
+	var qerr *QuorumError
+	if !errors.As(err, &qerr) {
+		t.Fatalf("got %v, wanted *QuorumError", err)
+	}
+	if result.Acks != 1 || len(result.Replicas) != 2 {
+		t.Errorf("got %d acks from %d replicas, wanted 1 of 2", result.Acks, len(result.Replicas))
+	}
+	for _, r := range result.Replicas {
+		refused := r.Node.Port() == 20402
+		if refused != (r.Err != nil) {
+			t.Errorf("got err=%v from port %d, wanted only the full replica to fail", r.Err, r.Node.Port())
+		}
+	}
+
+	client.Quorum.Write = 1
+	if _, err := client.StoreValue(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("got %v, wanted a quorum of 1 to be met", err)
+	}
+}
+
+func TestConflictPolicies(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	replies := []ReplicaValue{
+		{StoredValue: storage.StoredValue{Value: []byte("x"), Timestamp: at(1), Mutable: &storage.MutableMeta{Seq: 5}}},
+		{StoredValue: storage.StoredValue{Value: []byte("x"), Timestamp: at(2), Mutable: &storage.MutableMeta{Seq: 5}}},
+		{StoredValue: storage.StoredValue{Value: []byte("y"), Timestamp: at(3), Mutable: &storage.MutableMeta{Seq: 4}}},
+	}
+
+	cases := []struct {
+		policy ConflictPolicy
+		wanted int
+	}{
+		{LatestTimestamp, 2},
+		{HighestSeq, 1}, // seq 5 twice, newer of the two wins
+		{Majority, 1},   // "x" twice, newer of the two wins
+	}
+	for _, c := range cases {
+		if got := c.policy.resolve(replies); got != c.wanted {
+			t.Errorf("got reply %d, wanted %d for policy %d", got, c.wanted, c.policy)
+		}
+	}
+}
+
+func TestReadQuorumWithRepair(t *testing.T) {
+	var replicas []*Server
+	for port := 20411; port <= 20413; port++ {
+		replicas = append(replicas, startHonestNode(t, port))
+	}
+	client := startHonestNode(t, 20414)
+	for _, r := range replicas {
+		client.PingBootstrap(context.Background(), "127.0.0.1", r.Self.Port())
+	}
+	waitForRouting() // handoffs of nothing, but let them settle
+
+	// a partial write: two replicas have the new value, one the old
+	now := time.Now()
+	for i, r := range replicas {
+		req := storeRequest{Key: "k", Value: []byte("new"), Timestamp: now}
+		if i == 2 {
+			req = storeRequest{Key: "k", Value: []byte("old"), Timestamp: now.Add(-time.Minute)}
+		}
+		if err := r.putStored(req, "publisher"); err != nil {
+			t.Fatalf("putStored: %v", err)
+		}
+	}
+
+	client.Quorum.Read = 3
+	client.Quorum.ReadRepair = true
+	result, err := client.ReadValue(context.Background(), "k")
+	if err != nil {
+		t.Fatalf("ReadValue: %v", err)
+	}
+	if string(result.Value) != "new" || len(result.Replies) != 3 {
+		t.Errorf("got %q from %d replies, wanted %q from 3", result.Value, len(result.Replies), "new")
+	}
+	if len(result.Repaired) != 1 || result.Repaired[0].Port() != replicas[2].Self.Port() {
+		t.Errorf("got repaired %v, wanted only the stale replica", result.Repaired)
+	}
+	if got, _ := replicas[2].GetLocal("k"); string(got) != "new" {
+		t.Errorf("got %q on the stale replica after repair, wanted %q", got, "new")
+	}
+
+	// asking for more replicas than hold the key still returns the winner
+	client.Quorum.Read = 5
+	result, err = client.ReadValue(context.Background(), "k")
+	var qerr *ReadQuorumError
+	if !errors.As(err, &qerr) || string(result.Value) != "new" {
+		t.Errorf("got %q, %v, wanted %q and a *ReadQuorumError", result.Value, err, "new")
+	}
+}
+
+func TestReadFindsOwnCopy(t *testing.T) {
+	// the only node there is
+	alone := startHonestNode(t, 20421)
+	alone.StoreLocal("mine", []byte("v"))
+
+	got, err := alone.LookupValue(context.Background(), "mine")
+	if err != nil || string(got) != "v" {
+		t.Fatalf("got %q, %v, wanted our own copy", got, err)
+	}
+
+	// with a quorum of two the network has to make up the rest
+	peer := startHonestNode(t, 20422)
+	peer.PingBootstrap(context.Background(), "127.0.0.1", 20421)
+	peer.StoreLocal("mine", []byte("v"))
+	alone.Quorum.Read = 2
+	result, err := alone.ReadValue(context.Background(), "mine")
+	if err != nil || len(result.Replies) != 2 || result.Replies[0].Node.HexID() != alone.Self.HexID() {
+		t.Errorf("got %+v, %v, wanted our copy and the peer's", result.Replies, err)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"cs249-dht/storage"
+	"cs249-dht/transport"
+)
+
+// RateSpec is a token bucket refilling at Rate tokens per second, holding at most Burst.
+type RateSpec struct {
+	Rate  float64
+	Burst float64
+}
+
+// LimitsConfig holds every per-peer limit a Server enforces.
+type LimitsConfig struct {
+	// every RPC from one source IP
+	PerIP RateSpec
+	// RPCs of a given type from one source IP, on top of PerIP
+	PerType map[transport.RPCDescriptor]RateSpec
+
+	// storage quotas
+	storage.Quotas
+
+	// STOREs we send when handing keys off to newly joined nodes
+	Handoff RateSpec
+}
+
+func DefaultLimitsConfig() LimitsConfig {
+	return LimitsConfig{
+		PerIP: RateSpec{Rate: 100, Burst: 200},
+		PerType: map[transport.RPCDescriptor]RateSpec{
+			transport.RPCStore:    {Rate: 10, Burst: 20},
+			transport.RPCAnnounce: {Rate: 10, Burst: 20},
+			transport.RPCDelete:   {Rate: 10, Burst: 20},
+		},
+		Quotas:  storage.DefaultQuotas(),
+		Handoff: RateSpec{Rate: 20, Burst: 40},
+	}
+}
+
+// Validate checks no rate, burst or quota is negative.
+func (l LimitsConfig) Validate() error {
+	if l.PerIP.Rate < 0 || l.PerIP.Burst < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+	for typ, spec := range l.PerType {
+		if spec.Rate < 0 || spec.Burst < 0 {
+			return fmt.Errorf("%v rate limits can't be negative", typ)
+		}
+	}
+	if l.Handoff.Rate < 0 || l.Handoff.Burst < 0 {
+		return fmt.Errorf("handoff rate limits can't be negative")
+	}
+	for name, v := range map[string]int{
+		"keys per publisher quota":  l.MaxKeysPerPublisher,
+		"bytes per publisher quota": l.MaxBytesPerPublisher,
+		"store key cap":             l.MaxStoreKeys,
+		"store byte cap":            l.MaxStoreBytes,
+		"tombstone cap":             l.MaxTombstones,
+	} {
+		if v < 0 {
+			return fmt.Errorf("%s can't be negative, got %d", name, v)
+		}
+	}
+	return nil
+}
+
+// TokenBucket is a classic token bucket rate limiter.
+type TokenBucket struct {
+	spec   RateSpec
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(spec RateSpec, now time.Time) *TokenBucket {
+	return &TokenBucket{spec: spec, tokens: spec.Burst, last: now}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.spec.Burst, b.tokens+elapsed*b.spec.Rate)
+		b.last = now
+	}
+}
+
+// Allow takes one token if there is one.
+func (b *TokenBucket) Allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// RetryAfter is how long until the next token is available.
+func (b *TokenBucket) RetryAfter(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 || b.spec.Rate <= 0 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.spec.Rate * float64(time.Second))
+}
+
+// full buckets have no memory worth keeping
+func (b *TokenBucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.spec.Burst
+}
+
+// RateLimiter keeps a token bucket per source IP and per (source IP, RPC type).
+type RateLimiter struct {
+	mu      sync.Mutex
+	config  LimitsConfig
+	buckets map[string]*TokenBucket
+	now     func() time.Time // swapped out by tests
+
+	allowed   map[transport.RPCDescriptor]uint64
+	throttled map[transport.RPCDescriptor]uint64
+}
+
+func NewRateLimiter(config LimitsConfig) *RateLimiter {
+	return &RateLimiter{
+		config:    config,
+		buckets:   make(map[string]*TokenBucket),
+		now:       time.Now,
+		allowed:   make(map[transport.RPCDescriptor]uint64),
+		throttled: make(map[transport.RPCDescriptor]uint64),
+	}
+}
+
+// Reconfigure switches to config. Bucket state starts over; the counts
+// Stats reports are kept.
+func (rl *RateLimiter) Reconfigure(config LimitsConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.config = config
+	rl.buckets = make(map[string]*TokenBucket)
+}
+
+// Config returns the limits in force.
+func (rl *RateLimiter) Config() LimitsConfig {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.config
+}
+
+// Allow reports whether an RPC of type typ from ip may be served. If not,
+// retryAfter says when the peer may try again.
+func (rl *RateLimiter) Allow(ip string, typ transport.RPCDescriptor) (ok bool, retryAfter time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	if len(rl.buckets) > RATE_LIMIT_MAX_PEERS {
+		rl.prune(now)
+	}
+
+	// check both buckets before taking from either, so a refused
+	// per-type request doesn't eat the per-IP budget
+	ipBucket := rl.bucket("ip|"+ip, rl.config.PerIP, now)
+	var typeBucket *TokenBucket
+	if spec, limited := rl.config.PerType[typ]; limited {
+		typeBucket = rl.bucket(fmt.Sprintf("type|%s|%d", ip, typ), spec, now)
+	}
+
+	for _, b := range []*TokenBucket{ipBucket, typeBucket} {
+		if b != nil && b.RetryAfter(now) > 0 {
+			rl.throttled[typ]++
+			return false, b.RetryAfter(now)
+		}
+	}
+
+	ipBucket.Allow(now)
+	if typeBucket != nil {
+		typeBucket.Allow(now)
+	}
+	rl.allowed[typ]++
+	return true, 0
+}
+
+func (rl *RateLimiter) bucket(key string, spec RateSpec, now time.Time) *TokenBucket {
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = NewTokenBucket(spec, now)
+		rl.buckets[key] = b
+	}
+	return b
+}
+
+// prune forgets buckets that have refilled completely.
+func (rl *RateLimiter) prune(now time.Time) {
+	for key, b := range rl.buckets {
+		if b.idle(now) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// RateLimiterStats counts served and throttled RPCs by type.
+type RateLimiterStats struct {
+	Allowed   map[transport.RPCDescriptor]uint64
+	Throttled map[transport.RPCDescriptor]uint64
+	Peers     int
+}
+
+func (rl *RateLimiter) Stats() RateLimiterStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	stats := RateLimiterStats{
+		Allowed:   make(map[transport.RPCDescriptor]uint64, len(rl.allowed)),
+		Throttled: make(map[transport.RPCDescriptor]uint64, len(rl.throttled)),
+		Peers:     len(rl.buckets),
+	}
+	for typ, n := range rl.allowed {
+		stats.Allowed[typ] = n
+	}
+	for typ, n := range rl.throttled {
+		stats.Throttled[typ] = n
+	}
+	return stats
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/transport"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewTokenBucket(RateSpec{Rate: 2, Burst: 3}, now)
+
+	// burst goes through, then we are out
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Errorf("got false, wanted token %d of the burst", i)
+		}
+	}
+	if b.Allow(now) {
+		t.Errorf("got true, wanted bucket to be empty")
+	}
+
+	got := b.RetryAfter(now)
+	want := 500 * time.Millisecond
+	if got != want {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	// refills at 2 tokens per second
+	now = now.Add(500 * time.Millisecond)
+	if !b.Allow(now) {
+		t.Errorf("got false, wanted a refilled token")
+	}
+	if b.Allow(now) {
+		t.Errorf("got true, wanted bucket to be empty again")
+	}
+}
+
+func TestRateLimiterPerIPAndType(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limits := LimitsConfig{
+		PerIP: RateSpec{Rate: 1, Burst: 5},
+		PerType: map[transport.RPCDescriptor]RateSpec{
+			transport.RPCStore: {Rate: 1, Burst: 2},
+		},
+	}
+	rl := NewRateLimiter(limits)
+	rl.now = func() time.Time { return now }
+
+	// STOREs run out after 2
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Allow("10.0.0.1", transport.RPCStore); !ok {
+			t.Errorf("got throttled on STORE %d, wanted allowed", i)
+		}
+	}
+	if ok, retry := rl.Allow("10.0.0.1", transport.RPCStore); ok || retry <= 0 {
+		t.Errorf("got ok=%t retry=%v, wanted STORE throttled", ok, retry)
+	}
+
+	// ...but the refused STORE didn't eat into the per-IP budget: 3 pings left
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.Allow("10.0.0.1", transport.RPCPing); !ok {
+			t.Errorf("got throttled on PING %d, wanted allowed", i)
+		}
+	}
+	if ok, _ := rl.Allow("10.0.0.1", transport.RPCPing); ok {
+		t.Errorf("got allowed, wanted per-IP limit to kick in")
+	}
+
+	// other peers are unaffected
+	if ok, _ := rl.Allow("10.0.0.2", transport.RPCStore); !ok {
+		t.Errorf("got throttled, wanted a different IP to have its own bucket")
+	}
+
+	stats := rl.Stats()
+	if stats.Throttled[transport.RPCStore] != 1 || stats.Throttled[transport.RPCPing] != 1 {
+		t.Errorf("got throttled %v, wanted one STORE and one PING", stats.Throttled)
+	}
+	if stats.Allowed[transport.RPCStore] != 3 || stats.Allowed[transport.RPCPing] != 3 {
+		t.Errorf("got allowed %v, wanted 3 STOREs and 3 PINGs", stats.Allowed)
+	}
+}
+
+func TestFloodedStoresAreThrottled(t *testing.T) {
+	target := startHonestNode(t, 19701)
+	limits := DefaultLimitsConfig()
+	limits.PerType[transport.RPCStore] = RateSpec{Rate: 1, Burst: 5}
+	target.SetLimits(limits)
+
+	flooder, err := NewServer("127.0.0.1", 19702)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	throttled := 0
+	for i := 0; i < 10; i++ {
+		msg := flooder.newRPC(transport.RPCStore)
+		msg.Key = "flood"
+		msg.Value = []byte("x")
+		_, err := flooder.sendRPC(context.Background(), "127.0.0.1", 19701, msg, 2*time.Second)
+		if err != nil && strings.Contains(err.Error(), "rate limited") {
+			throttled++
+		}
+	}
+
+	if throttled < 4 {
+		t.Errorf("got %d throttled STOREs, wanted at least 4", throttled)
+	}
+	if got := target.Stats().RateLimits.Throttled[transport.RPCStore]; got < 4 {
+		t.Errorf("got %d throttled STOREs in stats, wanted at least 4", got)
+	}
+}
+
+func startSignedNode(t *testing.T, port int) *Server {
+	identity, _, err := node.GenerateIdentity("127.0.0.1", port, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	s, err := NewServerWithIdentity("127.0.0.1", port, identity, testPuzzle)
+	if err != nil {
+		t.Fatalf("NewServerWithIdentity: %v", err)
+	}
+	go s.Run()
+	return s
+}
+
+// storeAs sends target a STORE of key from from, claiming fromID and
+// publisher.
+func storeAs(t *testing.T, target, from *Server, fromID, publisher, key string) error {
+	ctx := context.Background()
+	token, err := from.writeToken(ctx, target.Self, node.KeyID(key))
+	if err != nil {
+		t.Fatalf("writeToken: %v", err)
+	}
+	msg := from.newRPC(transport.RPCStore)
+	msg.FromID = fromID
+	msg.Key = key
+	msg.Value = []byte("x")
+	msg.Publisher = publisher
+	msg.Token = token
+	_, err = from.sendRPC(ctx, target.Self.IP(), target.Self.Port(), msg, time.Second)
+	return err
+}
+
+func TestStoresAreChargedToTheSender(t *testing.T) {
+	target := startSignedNode(t, 21161)
+	defer target.Close()
+	victim := startSignedNode(t, 21162)
+	defer victim.Close()
+	attacker := startSignedNode(t, 21163)
+	defer attacker.Close()
+	limits := DefaultLimitsConfig()
+	limits.MaxKeysPerPublisher = 1
+	target.SetLimits(limits)
+
+	// a republish on the victim's behalf is the attacker's to pay for
+	if err := storeAs(t, target, attacker, attacker.Self.HexID(), victim.Self.HexID(), "republished"); err != nil {
+		t.Fatalf("republish: %v", err)
+	}
+	if item, _ := target.Store.Get("republished"); item.Publisher != victim.Self.HexID() || item.StoredBy != attacker.Self.HexID() {
+		t.Errorf("got publisher %s stored by %s, wanted the victim's value stored by the attacker", item.Publisher, item.StoredBy)
+	}
+	if err := storeAs(t, target, victim, victim.Self.HexID(), "", "own"); err != nil {
+		t.Errorf("got %v storing within the victim's own quota, wanted it accepted", err)
+	}
+	if err := storeAs(t, target, attacker, attacker.Self.HexID(), victim.Self.HexID(), "more"); err == nil {
+		t.Errorf("got nil error past the attacker's quota, wanted it refused")
+	}
+
+	// claiming the victim's ID without proof doesn't make it the victim's write
+	target.Store.Delete("republished")
+	if err := storeAs(t, target, attacker, victim.Self.HexID(), victim.Self.HexID(), "forged"); err != nil {
+		t.Fatalf("forged: %v", err)
+	}
+	if item, _ := target.Store.Get("forged"); item.Publisher == victim.Self.HexID() || item.StoredBy == victim.Self.HexID() {
+		t.Errorf("got publisher %s stored by %s, wanted nothing charged to the victim", item.Publisher, item.StoredBy)
+	}
+}
+
+func TestUnsignedSendersArePaidForByAddress(t *testing.T) {
+	target := startHonestNode(t, 21164)
+	defer target.Close()
+	first := startHonestNode(t, 21165)
+	defer first.Close()
+	// the same host on another port
+	second := startHonestNode(t, 21166)
+	defer second.Close()
+	limits := DefaultLimitsConfig()
+	limits.MaxKeysPerPublisher = 1
+	target.SetLimits(limits)
+
+	if err := storeAs(t, target, first, first.Self.HexID(), "", "one"); err != nil {
+		t.Fatalf("got %v, wanted the first store accepted", err)
+	}
+	if err := storeAs(t, target, second, second.Self.HexID(), "", "two"); err == nil {
+		t.Errorf("got nil error, wanted a second port of the same address to share its quota")
+	}
+	if item, _ := target.Store.Get("one"); item.Publisher != first.Self.HexID() || item.StoredBy != "ip:127.0.0.1" {
+		t.Errorf("got publisher %s stored by %s, wanted first's value charged to its address", item.Publisher, item.StoredBy)
+	}
+}
+
+func TestLimitsValidate(t *testing.T) {
+	if err := DefaultLimitsConfig().Validate(); err != nil {
+		t.Fatalf("got %v for the defaults, wanted them valid", err)
+	}
+	for name, breakIt := range map[string]func(l *LimitsConfig){
+		"per type": func(l *LimitsConfig) { l.PerType[transport.RPCStore] = RateSpec{Rate: -1} },
+		"handoff":  func(l *LimitsConfig) { l.Handoff.Burst = -1 },
+		"quota":    func(l *LimitsConfig) { l.MaxBytesPerPublisher = -1 },
+		"cap":      func(l *LimitsConfig) { l.MaxTombstones = -1 },
+	} {
+		limits := DefaultLimitsConfig()
+		breakIt(&limits)
+		if err := limits.Validate(); err == nil {
+			t.Errorf("%s: got nil error, wanted a negative limit refused", name)
+		}
+	}
+}
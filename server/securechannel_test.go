@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/transport"
+)
+
+func newEncryptedServer(t *testing.T, port int) *Server {
+	identity, _, err := node.GenerateIdentity("127.0.0.1", port, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	s, err := NewServerWithIdentity("127.0.0.1", port, identity, testPuzzle)
+	if err != nil {
+		t.Fatalf("NewServerWithIdentity: %v", err)
+	}
+	if err := s.EnableSecureChannel(); err != nil {
+		t.Fatalf("EnableSecureChannel: %v", err)
+	}
+	return s
+}
+
+func TestSecureChannelRoundTrip(t *testing.T) {
+	b := newEncryptedServer(t, 19501)
+	go b.Run()
+	b.StoreLocal("secret", []byte("attack at dawn"))
+
+	a := newEncryptedServer(t, 19502)
+	go a.Run()
+
+	a.PingBootstrap(context.Background(), "127.0.0.1", 19501)
+	if a.Router.IsNewNode(b.Self) {
+		t.Fatalf("ping over secure channel did not add peer to routing table")
+	}
+
+	value, _, err := a.FindValueOnce(context.Background(), "secret", "127.0.0.1", 19501)
+	if err != nil {
+		t.Fatalf("FindValueOnce: %v", err)
+	}
+	if string(value) != "attack at dawn" {
+		t.Errorf("got %q, wanted %q", value, "attack at dawn")
+	}
+
+	// the other direction reuses b's own session to a
+	if _, err := b.FindNodeOnce(context.Background(), a.Self.ID(), "127.0.0.1", 19502); err != nil {
+		t.Errorf("FindNodeOnce from b to a: %v", err)
+	}
+}
+
+func TestSecureChannelRejectsPlaintextPeer(t *testing.T) {
+	secure := newEncryptedServer(t, 19503)
+	go secure.Run()
+
+	plain, err := NewServer("127.0.0.1", 19504)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	_, err = plain.sendRPC(context.Background(), "127.0.0.1", 19503, plain.newRPC(transport.RPCPing), 2*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "secure channel required") {
+		t.Errorf("got %v, wanted a secure channel required error", err)
+	}
+}
+
+func TestSecureChannelRejectedByUnsupportingPeer(t *testing.T) {
+	identity, _, err := node.GenerateIdentity("127.0.0.1", 19505, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	unsupporting, err := NewServerWithIdentity("127.0.0.1", 19505, identity, testPuzzle)
+	if err != nil {
+		t.Fatalf("NewServerWithIdentity: %v", err)
+	}
+	go unsupporting.Run()
+
+	secure := newEncryptedServer(t, 19506)
+	_, err = secure.sendRPC(context.Background(), "127.0.0.1", 19505, secure.newRPC(transport.RPCPing), 2*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "secure channel not supported") {
+		t.Errorf("got %v, wanted a secure channel not supported error", err)
+	}
+}
@@ -0,0 +1,1044 @@
+// Package server runs a DHT node. Create one with New from a Config, join
+// the network with Start, use Put, Get and FindNode (or the richer
+// operations on Server) and release it with Close.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/routing"
+	"cs249-dht/storage"
+	"cs249-dht/transport"
+)
+
+// Server represents a running Kademlia node on this machine.
+// It owns:
+//   - a Node identity (ip/port/nodeID)
+//   - a UDPTransport (socket)
+//   - the routing table, the value store and the provider records
+type Server struct {
+	Self      node.Node
+	Transport *transport.UDPTransport
+	Router    *routing.Router
+	Store     *storage.ValueStore
+	Providers *ProviderStore
+
+	// S/Kademlia mode: when Identity is set every RPC we send is signed, and
+	// only peers with a valid signature + proof get a routing-table slot.
+	Identity *node.Identity
+	Puzzle   node.PuzzleDifficulty
+
+	// write tokens we hand out, and the ones peers handed us ("ip:port" -> token)
+	Tokens       *TokenManager
+	peerTokensMu sync.Mutex
+	peerTokens   map[string]peerToken
+
+	// k, alpha, timeouts and the other protocol parameters
+	Protocol ProtocolConfig
+
+	// per-peer rate limits; SetLimits changes them along with the quotas
+	Limiter *RateLimiter
+
+	// how many replicas reads and writes need
+	Quorum QuorumConfig
+
+	// RPC, lookup, routing table and store metrics for scraping
+	Metrics *Metrics
+	// where the node logs, with its ID attached; see SetLogger
+	Logger *slog.Logger
+
+	// Lamport clock for versioning the values we publish
+	clockMu sync.Mutex
+	clock   uint64
+
+	// new contacts waiting to be handed the keys they are now closest to,
+	// and the bucket pacing the STOREs we send them
+	handoffQueue  chan node.Node
+	handoffMu     sync.Mutex
+	handoffBucket *TokenBucket
+
+	// lifetime of the background loops, cancelled by Close
+	ctx       context.Context
+	stop      context.CancelFunc
+	closeOnce sync.Once
+
+	// nodes to join through on Start, from Config.Bootstrap
+	bootstrap []string
+
+	// closed when Run returns, nil until Run is called
+	runMu   sync.Mutex
+	runDone chan struct{}
+	loops   sync.WaitGroup
+
+	// what Shutdown does besides stopping, from Config
+	stateFile   string
+	notifyLeave bool
+}
+
+// NewServer builds a Node identity from (ip,port) and binds UDPTransport.
+func NewServer(ip string, port int) (*Server, error) {
+	// Derive the node ID from ip+port
+	selfNode, err := node.NewNodeFromIPAndport(ip, port)
+	if err != nil {
+		return nil, err
+	}
+
+	return newServer(selfNode)
+}
+
+// NewServerWithIdentity builds a Server whose node ID is bound to identity
+// (see GenerateIdentity). Peers must present proofs meeting diff.
+func NewServerWithIdentity(ip string, port int, identity *node.Identity, diff node.PuzzleDifficulty) (*Server, error) {
+	selfNode, err := node.NewNodeFromIPAndport(ip, port, identity.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	selfNode = selfNode.WithProof(&identity.NodeProof)
+
+	if err := node.VerifyNodeProof(selfNode, diff); err != nil {
+		return nil, fmt.Errorf("own identity does not satisfy puzzle: %w", err)
+	}
+
+	server, err := newServer(selfNode)
+	if err != nil {
+		return nil, err
+	}
+	server.Identity = identity
+	server.Puzzle = diff
+	return server, nil
+}
+
+func newServer(selfNode node.Node) (*Server, error) {
+	// Create the UDP transport on the same ip/port
+	transport, err := transport.NewUDPTransport(selfNode.IP(), selfNode.Port())
+	if err != nil {
+		return nil, err
+	}
+
+	protocol := DefaultProtocolConfig()
+	router := routing.NewRouter(selfNode, protocol.Routing)
+	limits := DefaultLimitsConfig()
+
+	server := &Server{
+		Self:       selfNode,
+		Transport:  transport,
+		Router:     &router,
+		Store:      storage.NewValueStore(limits.Quotas),
+		Providers:  NewProviderStore(MAX_PROVIDERS_PER_KEY, MAX_PROVIDER_RECORDS),
+		Tokens:     NewTokenManager(TOKEN_ROTATION),
+		peerTokens: make(map[string]peerToken),
+		Protocol:   protocol,
+		Limiter:    NewRateLimiter(limits),
+		Quorum:     DefaultQuorumConfig(),
+
+		handoffQueue:  make(chan node.Node, HANDOFF_QUEUE_SIZE),
+		handoffBucket: NewTokenBucket(limits.Handoff, time.Now()),
+	}
+	server.Metrics = newMetrics(server)
+	server.SetLogger(slog.New(slog.DiscardHandler))
+	server.ctx, server.stop = context.WithCancel(context.Background())
+	router.OnNewContact = server.queueHandoff
+	return server, nil
+}
+
+// SetProtocol replaces the protocol parameters. The routing table is rebuilt
+// for the new k and b, keeping the contacts it already has room for. Meant to
+// be called before Start.
+func (ln *Server) SetProtocol(protocol ProtocolConfig) {
+	contacts := ln.Router.Contacts()
+	router := routing.NewRouter(ln.Self, protocol.Routing)
+	router.LoadContacts(contacts)
+	router.OnNewContact = ln.queueHandoff
+	router.SetLogger(ln.Logger)
+
+	ln.Protocol = protocol
+	ln.Router = &router
+}
+
+// SetLimits replaces the rate limits and storage quotas. Rate limit state
+// starts over; values already stored are kept. It is safe to call while
+// the node is serving RPCs.
+func (ln *Server) SetLimits(limits LimitsConfig) {
+	ln.Limiter.Reconfigure(limits)
+	ln.Store.SetLimits(limits.Quotas)
+
+	ln.handoffMu.Lock()
+	ln.handoffBucket = NewTokenBucket(limits.Handoff, time.Now())
+	ln.handoffMu.Unlock()
+}
+
+// ServerStats is a snapshot of what the limits have been doing.
+type ServerStats struct {
+	RateLimits RateLimiterStats
+	Store      storage.StoreStats
+}
+
+func (ln *Server) Stats() ServerStats {
+	return ServerStats{
+		RateLimits: ln.Limiter.Stats(),
+		Store:      ln.Store.Stats(),
+	}
+}
+
+// EnableSecureChannel makes every RPC to and from this node go through an
+// authenticated, encrypted session (see SecureChannel). Requires an identity.
+func (ln *Server) EnableSecureChannel() error {
+	if ln.Identity == nil {
+		return fmt.Errorf("secure channel needs a node identity")
+	}
+	ln.Transport.EnableSecureChannel(ln.Identity, ln.Self, ln.Puzzle)
+	return nil
+}
+
+// newRPC returns a message of type t with our sender info filled in.
+func (ln *Server) newRPC(t transport.RPCDescriptor) *transport.RPCMessage {
+	return &transport.RPCMessage{
+		Type:     t,
+		FromID:   ln.Self.HexID(),
+		FromIP:   ln.Self.IP(),
+		FromPort: ln.Self.Port(),
+	}
+}
+
+// newReply returns a response of type t to req, echoing its RequestID so the
+// requester's transport can match it.
+func (ln *Server) newReply(req *transport.RPCMessage, t transport.RPCDescriptor) *transport.RPCMessage {
+	resp := ln.newRPC(t)
+	resp.RequestID = req.RequestID
+	return resp
+}
+
+// sign signs msg with our identity, if we have one.
+func (ln *Server) sign(msg *transport.RPCMessage) error {
+	if ln.Identity == nil {
+		return nil
+	}
+	return transport.SignRPC(ln.Identity, msg)
+}
+
+// checkSender verifies the sender of msg and returns it as a Node.
+// admit reports whether the sender may take a routing-table slot.
+// A non-nil error means the message must be dropped.
+func (ln *Server) checkSender(msg *transport.RPCMessage) (sender *node.Node, admit bool, err error) {
+	signed := len(msg.Signature) > 0
+	if signed {
+		if err := transport.VerifyRPCSignature(msg); err != nil {
+			return nil, false, err
+		}
+	} else if ln.Identity != nil {
+		return nil, false, fmt.Errorf("unsigned rpc from %s:%d", msg.FromIP, msg.FromPort)
+	}
+
+	sender, err = transport.NodeFromRPC(msg)
+	if err != nil {
+		// nothing to admit, but the message itself may still be served
+		return nil, false, nil
+	}
+
+	if ln.Identity == nil {
+		return sender, true, nil
+	}
+
+	if err := node.VerifyNodeProof(*sender, ln.Puzzle); err != nil {
+		ln.Logger.Warn("refusing routing slot", "peer", sender.HexID(), "err", err)
+		return sender, false, nil
+	}
+	return sender, true, nil
+}
+
+// admitContact adds n to the routing table, unless we run in S/Kademlia mode
+// and n cannot prove its identity.
+func (ln *Server) admitContact(n node.Node) {
+	if ln.Identity != nil {
+		if err := node.VerifyNodeProof(n, ln.Puzzle); err != nil {
+			return
+		}
+	}
+	ln.Router.AddContact(n)
+}
+
+// ErrTimeout is wrapped by the error of an RPC that got no reply in time.
+var ErrTimeout = errors.New("timed out")
+
+// sendRPC signs msg, sends it to ip:port and verifies the sender of the
+// response. It waits at most timeout, and returns ctx.Err() if ctx is done
+// first.
+func (ln *Server) sendRPC(ctx context.Context, ip string, port int, msg *transport.RPCMessage, timeout time.Duration) (*transport.RPCMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// the request id is covered by the signature, so pick it before signing
+	if msg.RequestID == "" {
+		msg.RequestID = transport.NewRequestID()
+	}
+	if err := ln.sign(msg); err != nil {
+		return nil, err
+	}
+
+	rpcCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ln.Metrics.sent(msg)
+	sent := time.Now()
+	resp, err := ln.Transport.SendRPC(rpcCtx, ip, port, msg)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			ln.Metrics.timedOut(msg)
+			return nil, fmt.Errorf("read from udp: %w after %v waiting for %s:%d", ErrTimeout, timeout, ip, port)
+		}
+		return nil, err
+	}
+	ln.Metrics.received(resp)
+	ln.Metrics.answered(msg, time.Since(sent))
+	if resp.Type == transport.RPCError {
+		return nil, fmt.Errorf("%s:%d answered with error: %s", ip, port, resp.Error)
+	}
+
+	if _, _, err := ln.checkSender(resp); err != nil {
+		return nil, fmt.Errorf("rejecting response from %s:%d: %w", ip, port, err)
+	}
+	return resp, nil
+}
+
+// ctxOr returns ctx.Err() if ctx is done and err otherwise, so a cancelled
+// operation reports the cancellation rather than whichever step it broke.
+func ctxOr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// HandleRPC is called whenever an RPCMessage is received over UDP.
+func (ln *Server) HandleRPC(msg *transport.RPCMessage, from *net.UDPAddr) {
+	log := ln.Logger.With("peer", from.String(), "rpc", msg.Type.String())
+	log.Debug("handling RPC")
+	ln.Metrics.received(msg)
+
+	// rate limit before doing anything expensive like checking signatures
+	if ok, retryAfter := ln.Limiter.Allow(from.IP.String(), msg.Type); !ok {
+		log.Debug("throttling RPC", "retry_after", retryAfter)
+		resp := ln.newReply(msg, transport.RPCError)
+		resp.Error = "rate limited"
+		resp.RetryAfterMs = retryAfter.Milliseconds() + 1
+		if err := ln.sendDirectRPC(resp, from); err != nil {
+			log.Warn("sending throttled response", "err", err)
+		}
+		return
+	}
+
+	remoteNode, admit, err := ln.checkSender(msg)
+	if err != nil {
+		log.Warn("dropping RPC", "err", err)
+		return
+	}
+	if admit {
+		ln.Router.AddContact(*remoteNode)
+	}
+
+	// Later: update routing table with msg.FromID/msg.FromIP/msg.FromPort
+
+	switch msg.Type {
+	case transport.RPCPing:
+		// Reply with Pong
+		pong := ln.newReply(msg, transport.RPCPong)
+		if err := ln.sendDirectRPC(pong, from); err != nil {
+			log.Warn("sending Pong", "err", err)
+		}
+
+	case transport.RPCPong:
+		log.Debug("unsolicited Pong", "peer_id", msg.FromID)
+
+	case transport.RPCFindNode:
+		ln.handleFindNodeRPC(msg, from)
+
+	case transport.RPCStore:
+		log.Debug("got STORE", "key", msg.Key)
+
+		if msg.Key == "" {
+			log.Warn("STORE with empty key, ignoring")
+			return
+		}
+		if !ln.Tokens.Validate(msg.Token, from) {
+			log.Warn("STORE without a valid token, rejecting", "key", msg.Key)
+			ln.sendErrorRPC(msg, from, "invalid or expired store token")
+			return
+		}
+		// the sender is charged for what it stores, see quotaOwner; only a
+		// proven sender may store on behalf of another publisher, as a
+		// republish
+		req := storeRequestFromRPC(msg)
+		sender := senderID(msg, from)
+		if req.Publisher == "" || !provenSender(msg, from) {
+			req.Publisher = sender
+		}
+		if req.Publisher == sender {
+			// the publisher itself is back, so its own tombstone no longer applies
+			ln.Store.ClearTombstone(req.Key, sender)
+		}
+		if err := ln.putStored(req, ln.quotaOwner(msg, from)); err != nil {
+			log.Info("STORE refused", "key", msg.Key, "err", err)
+			ln.sendErrorRPC(msg, from, err.Error())
+			return
+		}
+
+		// optional: send a simple ACK (not required by spec, but handy)
+		// TODO: what is this doing and what do we need it for?
+		ack := ln.newReply(msg, transport.RPCStore) // or define RPCStoreAck if you want
+		ack.Key = msg.Key
+		if err := ln.sendDirectRPC(ack, from); err != nil {
+			log.Warn("sending STORE ack", "err", err)
+		}
+
+	case transport.RPCFindValue:
+		ln.handleFindValueRPC(msg, from)
+
+	case transport.RPCAnnounce:
+		ln.handleAnnounceRPC(msg, from)
+
+	case transport.RPCGetProviders:
+		ln.handleGetProvidersRPC(msg, from)
+
+	case transport.RPCDelete:
+		ln.handleDeleteRPC(msg, from)
+
+	case transport.RPCLeave:
+		ln.handleLeaveRPC(msg, from)
+
+	default:
+		log.Warn("unknown RPC type")
+	}
+}
+
+func (ln *Server) sendDirectRPC(msg *transport.RPCMessage, to *net.UDPAddr) error {
+	if err := ln.sign(msg); err != nil {
+		return fmt.Errorf("sign rpc: %w", err)
+	}
+	ln.Metrics.sent(msg)
+	return ln.Transport.SendDirect(msg, to)
+}
+
+// sendErrorRPC answers req with an RPCError carrying reason.
+func (ln *Server) sendErrorRPC(req *transport.RPCMessage, to *net.UDPAddr, reason string) {
+	resp := ln.newReply(req, transport.RPCError)
+	resp.Error = reason
+	if err := ln.sendDirectRPC(resp, to); err != nil {
+		ln.Logger.Warn("sending error response", "peer", to.String(), "err", err)
+	}
+}
+
+// Run starts the main listening loop for this node and blocks until Close
+// or Shutdown. It returns once the requests already received have been
+// handled and the background loops have stopped.
+func (ln *Server) Run() {
+	done := make(chan struct{})
+	ln.runMu.Lock()
+	ln.runDone = done
+	ln.runMu.Unlock()
+	defer close(done)
+
+	ln.loops.Add(2)
+	go func() {
+		defer ln.loops.Done()
+		ln.maintenanceLoop()
+	}()
+	go func() {
+		defer ln.loops.Done()
+		ln.handoffLoop()
+	}()
+
+	ln.Transport.ListenRPC(func(msg *transport.RPCMessage, from *net.UDPAddr) {
+		ln.HandleRPC(msg, from)
+	})
+
+	// the listener only stops when we are going down
+	ln.stop()
+	ln.loops.Wait()
+}
+
+// Start runs the node in the background and joins the network through the
+// bootstrap nodes from its Config: it pings them and then looks up its own
+// ID to fill the routing table. It fails only if none of them answer, or
+// with ctx.Err() if ctx is done before joining finished. ctx only bounds the
+// join; the node keeps running until Close.
+func (ln *Server) Start(ctx context.Context) error {
+	go ln.Run()
+
+	if len(ln.bootstrap) == 0 {
+		return nil
+	}
+	var lastErr error
+	joined := 0
+	for _, addr := range ln.bootstrap {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			lastErr = fmt.Errorf("bootstrap address %q: %w", addr, err)
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			lastErr = fmt.Errorf("bootstrap address %q: bad port", addr)
+			continue
+		}
+		if err := ln.PingBootstrap(ctx, host, port); err != nil {
+			lastErr = err
+			continue
+		}
+		joined++
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if joined == 0 {
+		return fmt.Errorf("Start: no bootstrap node answered: %w", lastErr)
+	}
+
+	if _, err := ln.LookupNodes(ctx, ln.Self.ID()); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		ln.Logger.Warn("self lookup after joining", "err", err)
+	}
+	ln.Logger.Info("joined network", "contacts", ln.Router.Size())
+	return nil
+}
+
+// Close stops the background loops and closes the socket straight away,
+// which makes Run return. Shutdown stops more gracefully. It is safe to call
+// more than once.
+func (ln *Server) Close() error {
+	var err error
+	ln.closeOnce.Do(func() {
+		ln.stop()
+		err = ln.Transport.Close()
+	})
+	return err
+}
+
+// Put stores value under key on the k closest nodes, see StoreValue.
+func (ln *Server) Put(ctx context.Context, key string, value []byte) error {
+	_, err := ln.StoreValue(ctx, key, value)
+	return err
+}
+
+// Get looks up the value stored under key, see LookupValue.
+func (ln *Server) Get(ctx context.Context, key string) ([]byte, error) {
+	return ln.LookupValue(ctx, key)
+}
+
+// FindNode returns the k closest nodes to id in the network, see LookupNodes.
+func (ln *Server) FindNode(ctx context.Context, id *big.Int) ([]node.Node, error) {
+	return ln.LookupNodes(ctx, id)
+}
+
+// Ping sends a Ping RPC to ip:port and returns the node that answered and
+// how long the round trip took. The node is admitted to the routing table
+// like any other sender.
+func (ln *Server) Ping(ctx context.Context, ip string, port int) (node.Node, time.Duration, error) {
+	ping := ln.newRPC(transport.RPCPing)
+
+	sent := time.Now()
+	resp, err := ln.sendRPC(ctx, ip, port, ping, ln.Protocol.RPCTimeout)
+	if err != nil {
+		return node.Node{}, 0, ctxOr(ctx, fmt.Errorf("pinging %s:%d: %w", ip, port, err))
+	}
+	rtt := time.Since(sent)
+
+	ln.Logger.Debug("ping answered", "peer", peerAddr(ip, port), "peer_id", resp.FromID, "rtt", rtt)
+
+	// Build a Node for the responder and add to routing table.
+	peer, err := transport.NodeFromRPC(resp)
+	if err != nil {
+		return node.Node{}, 0, fmt.Errorf("%s:%d: %w", ip, port, err)
+	}
+	ln.admitContact(*peer)
+	return *peer, rtt, nil
+}
+
+// PingBootstrap sends a Ping RPC to a bootstrap node and waits for response.
+func (ln *Server) PingBootstrap(ctx context.Context, bootstrapIP string, bootstrapPort int) error {
+	if _, _, err := ln.Ping(ctx, bootstrapIP, bootstrapPort); err != nil {
+		ln.Logger.Warn("pinging bootstrap node", "peer", peerAddr(bootstrapIP, bootstrapPort), "err", err)
+		return fmt.Errorf("bootstrap: %w", err)
+	}
+	return nil
+}
+
+// Handle FindNode RPC by looking up closest nodes and replying.
+func (ln *Server) handleFindNodeRPC(msg *transport.RPCMessage, from *net.UDPAddr) {
+	if msg.TargetID == "" {
+		ln.Logger.Warn("FIND_NODE without a target", "peer", from.String())
+		return
+	}
+
+	// Parse target ID from hex
+	targetID := new(big.Int)
+	if _, ok := targetID.SetString(msg.TargetID, 16); !ok {
+		ln.Logger.Warn("FIND_NODE with a bad target", "peer", from.String(), "target", msg.TargetID)
+		return
+	}
+
+	// Make a dummy Node with this ID just to use Router.FindNeighbors
+	targetNode := node.NewNodeFromID(targetID)
+
+	// Use your routing table + kbuckets to find nearest neighbors
+	neighbors := ln.Router.FindNeighbors(targetNode, -1) // -1 => use KSIZE internally
+
+	// Convert to RPCNodeInfo for the wire
+	nodeInfos := make([]transport.RPCNodeInfo, 0, len(neighbors))
+	for _, n := range neighbors {
+		nodeInfos = append(nodeInfos, transport.NodeToRPC(n))
+	}
+
+	resp := ln.newReply(msg, transport.RPCFindNode) // or RPCFindNodeResp if you add a separate type
+	resp.TargetID = msg.TargetID
+	resp.Nodes = nodeInfos
+	resp.Token = ln.Tokens.Issue(from)
+
+	if err := ln.sendDirectRPC(resp, from); err != nil {
+		ln.Logger.Warn("sending FIND_NODE response", "peer", from.String(), "err", err)
+	}
+}
+
+// FindNodeOnce sends a single FindNode RPC to the given ip/port and returns the neighbors.
+func (ln *Server) FindNodeOnce(ctx context.Context, targetID *big.Int, ip string, port int) ([]node.Node, error) {
+	msg := ln.newRPC(transport.RPCFindNode)
+	msg.TargetID = node.NodeIDToHex(targetID)
+
+	resp, err := ln.sendRPC(ctx, ip, port, msg, ln.Protocol.RPCTimeout)
+	if err != nil {
+		return nil, ctxOr(ctx, fmt.Errorf("SendRPC FindNode: %w", err))
+	}
+	ln.rememberToken(ip, port, resp.Token)
+
+	neighbors := make([]node.Node, 0, len(resp.Nodes))
+	for _, info := range resp.Nodes {
+		n, err := transport.NodeFromInfo(info)
+		if err != nil {
+			continue
+		}
+
+		// Learn about this contact too
+		ln.admitContact(n)
+
+		neighbors = append(neighbors, n)
+	}
+
+	return neighbors, nil
+}
+
+// StoreLocal stores a key-value pair in the local node's storage.
+func (ln *Server) StoreLocal(key string, value []byte) {
+	if err := ln.Store.Put(key, value, ln.Self.HexID()); err != nil {
+		ln.Logger.Warn("storing locally", "key", key, "err", err)
+	}
+}
+
+// GetLocal retrieves a value by key from the local node's storage.
+func (ln *Server) GetLocal(key string) ([]byte, bool) {
+	v, ok := ln.Store.Get(key)
+	return v.Value, ok
+}
+
+func (ln *Server) handleFindValueRPC(msg *transport.RPCMessage, from *net.UDPAddr) {
+	if msg.Key == "" {
+		ln.Logger.Warn("FIND_VALUE without a key", "peer", from.String())
+		return
+	}
+
+	// 1) If we *have* the value locally, return it directly.
+	if stored, ok := ln.Store.Get(msg.Key); ok {
+		resp := ln.newReply(msg, transport.RPCFindValue)
+		resp.Key = msg.Key
+		resp.Value = stored.Value
+		resp.Mutable = stored.Mutable
+		resp.Immutable = stored.Immutable
+		resp.Erasure = stored.Erasure
+		resp.Fragment = stored.Fragment
+		resp.Publisher = stored.Publisher
+		if !stored.Timestamp.IsZero() {
+			resp.Timestamp = stored.Timestamp.UnixNano()
+		}
+		if stored.Version != (storage.Version{}) {
+			version := stored.Version
+			resp.Version = &version
+		}
+		resp.Token = ln.Tokens.Issue(from)
+		// Nodes can be empty when value is returned
+		if err := ln.sendDirectRPC(resp, from); err != nil {
+			ln.Logger.Warn("sending FIND_VALUE value", "peer", from.String(), "key", msg.Key, "err", err)
+		}
+		return
+	}
+
+	// 2) Otherwise, behave like FIND_NODE on the key’s ID.
+
+	// Derive an ID from the key (SHA-256 just like Node IDs), unless the
+	// requester is after a fragment, which sits elsewhere
+	keyID := node.KeyID(msg.Key)
+	if msg.TargetID != "" {
+		if targetID, ok := new(big.Int).SetString(msg.TargetID, 16); ok {
+			keyID = targetID
+		}
+	}
+
+	targetNode := node.NewNodeFromID(keyID)
+
+	neighbors := ln.Router.FindNeighbors(targetNode, -1)
+
+	nodeInfos := make([]transport.RPCNodeInfo, 0, len(neighbors))
+	for _, n := range neighbors {
+		nodeInfos = append(nodeInfos, transport.NodeToRPC(n))
+	}
+
+	resp := ln.newReply(msg, transport.RPCFindValue) // same type; distinguish by Value vs Nodes
+	resp.Key = msg.Key
+	resp.Nodes = nodeInfos
+	resp.Token = ln.Tokens.Issue(from)
+
+	if err := ln.sendDirectRPC(resp, from); err != nil {
+		ln.Logger.Warn("sending FIND_VALUE contacts", "peer", from.String(), "key", msg.Key, "err", err)
+	}
+}
+
+// storeRequest is the payload of a STORE, i.e. everything but routing info.
+type storeRequest struct {
+	Key       string
+	Value     []byte
+	Mutable   *storage.MutableMeta
+	Immutable bool
+	Erasure   bool
+	Fragment  bool
+	Publisher string    // original publisher when republishing, "" for the sender
+	Timestamp time.Time // publisher's clock when the value was written
+	Version   storage.Version
+}
+
+func storeRequestFromRPC(msg *transport.RPCMessage) storeRequest {
+	req := storeRequest{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Mutable:   msg.Mutable,
+		Immutable: msg.Immutable,
+		Erasure:   msg.Erasure,
+		Fragment:  msg.Fragment,
+		Publisher: msg.Publisher,
+	}
+	if msg.Timestamp != 0 {
+		req.Timestamp = time.Unix(0, msg.Timestamp)
+	}
+	if msg.Version != nil {
+		req.Version = *msg.Version
+	}
+	return req
+}
+
+// storeRequestFromStored rebuilds the STORE that put item here, for passing
+// it on to other nodes on behalf of its publisher.
+func storeRequestFromStored(item storage.StoredValue) storeRequest {
+	return storeRequest{
+		Key:       item.Key,
+		Value:     item.Value,
+		Mutable:   item.Mutable,
+		Immutable: item.Immutable,
+		Erasure:   item.Erasure,
+		Fragment:  item.Fragment,
+		Publisher: item.Publisher,
+		Timestamp: item.Timestamp,
+		Version:   item.Version,
+	}
+}
+
+func (req storeRequest) fill(msg *transport.RPCMessage) {
+	msg.Key = req.Key
+	msg.Value = req.Value
+	msg.Mutable = req.Mutable
+	msg.Immutable = req.Immutable
+	msg.Erasure = req.Erasure
+	msg.Fragment = req.Fragment
+	msg.Publisher = req.Publisher
+	if !req.Timestamp.IsZero() {
+		msg.Timestamp = req.Timestamp.UnixNano()
+	}
+	if req.Version != (storage.Version{}) {
+		version := req.Version
+		msg.Version = &version
+	}
+}
+
+// verify checks the integrity guarantees req claims for itself: a signature
+// for mutable items, the hash for content-addressed ones.
+func (req storeRequest) verify() error {
+	if req.Mutable != nil && req.Immutable {
+		return fmt.Errorf("item can't be both mutable and immutable")
+	}
+	if req.Erasure {
+		_, err := decodeErasureManifest(req.Value)
+		return err
+	}
+	if req.Immutable {
+		return storage.VerifyImmutable(req.Key, req.Value)
+	}
+	if req.Mutable != nil {
+		mutable := &storage.MutableItem{MutableMeta: *req.Mutable, Value: req.Value}
+		return mutable.Verify(req.Key)
+	}
+	return nil
+}
+
+// putStored writes req into our store, applying the checks for its kind of
+// value, and charges owner for it in the quotas. owner is the publisher too
+// if req names none.
+func (ln *Server) putStored(req storeRequest, owner string) error {
+	if err := req.verify(); err != nil {
+		return err
+	}
+	publisher := req.Publisher
+	if publisher == "" {
+		publisher = owner
+	}
+
+	item := storage.StoredValue{
+		Key:       req.Key,
+		Value:     req.Value,
+		Publisher: publisher,
+		StoredBy:  owner,
+		Timestamp: req.Timestamp,
+		Version:   req.Version,
+		Immutable: req.Immutable,
+		Erasure:   req.Erasure,
+		Fragment:  req.Fragment,
+	}
+	if err := ln.observeVersion(req.Version); err != nil {
+		return err
+	}
+	if req.Mutable == nil {
+		// mutable items are ordered by their signed seq instead
+		return ln.Store.PutItem(item, func(old storage.StoredValue, exists bool) error {
+			return storage.CheckVersion(req.Version, old, exists)
+		})
+	}
+
+	mutable := &storage.MutableItem{MutableMeta: *req.Mutable, Value: req.Value}
+	meta := *req.Mutable
+	meta.CAS = nil
+	item.Mutable = &meta
+
+	return ln.Store.PutItem(item, func(old storage.StoredValue, exists bool) error {
+		return storage.CheckMutableReplace(mutable, old, exists)
+	})
+}
+
+var errNoStoreTargets = errors.New("StoreValue: no known nodes to store to")
+
+// StoreValue stores value under key on the k closest nodes to it, found
+// with an iterative lookup, and keeps a copy here. The value gets the next
+// version from our logical clock, and replicas holding a newer version
+// refuse it. It fails with a *QuorumError unless the write quorum of
+// replicas acked.
+func (ln *Server) StoreValue(ctx context.Context, key string, value []byte) (StoreResult, error) {
+	return ln.replicate(ctx, storeRequest{Key: key, Value: value, Version: ln.nextVersion(key)})
+}
+
+// replicate looks up the k closest nodes to req.Key, sends req to the
+// Protocol.Replication closest of them in parallel and keeps a copy
+// locally. The result holds every replica's outcome; the error is a
+// *QuorumError if too few acked.
+func (ln *Server) replicate(ctx context.Context, req storeRequest) (StoreResult, error) {
+	// Hash the key into an ID in the same space as node IDs
+	keyID := node.KeyID(req.Key)
+	if req.Publisher == "" && req.Timestamp.IsZero() {
+		req.Timestamp = time.Now()
+	}
+
+	nodes, err := ln.LookupNodes(ctx, keyID)
+	if ctx.Err() != nil {
+		return StoreResult{}, ctx.Err()
+	}
+	if err != nil || len(nodes) == 0 {
+		return StoreResult{}, errNoStoreTargets
+	}
+	if len(nodes) > ln.Protocol.replicas() {
+		nodes = nodes[:ln.Protocol.replicas()]
+	}
+
+	result := StoreResult{
+		Replicas: make([]ReplicaOutcome, len(nodes)),
+		Quorum:   ln.Quorum.writeQuorum(len(nodes)),
+	}
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n node.Node) {
+			defer wg.Done()
+			result.Replicas[i] = ReplicaOutcome{Node: n, Err: ln.storeToNode(ctx, n, keyID, req)}
+		}(i, n)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	for _, r := range result.Replicas {
+		if r.Err != nil {
+			// not fatal by itself; some nodes may be down
+			ln.Logger.Info("storing to replica", "peer", peerAddr(r.Node.IP(), r.Node.Port()), "key", req.Key, "err", r.Err)
+			continue
+		}
+		result.Acks++
+	}
+
+	// Optionally also store locally
+	if req.Publisher == "" {
+		ln.Store.ClearTombstone(req.Key, ln.Self.HexID())
+	}
+	if err := ln.putStored(req, ln.Self.HexID()); err != nil {
+		ln.Logger.Warn("storing locally", "key", req.Key, "err", err)
+	}
+
+	if result.Acks < result.Quorum {
+		return result, &QuorumError{Result: result}
+	}
+	return result, nil
+}
+
+// storeToNode sends a STORE for req to n, presenting a write token.
+func (ln *Server) storeToNode(ctx context.Context, n node.Node, keyID *big.Int, req storeRequest) error {
+	token, err := ln.writeToken(ctx, n, keyID)
+	if err != nil {
+		return err
+	}
+
+	msg := ln.newRPC(transport.RPCStore)
+	req.fill(msg)
+	msg.Token = token
+
+	_, err = ln.sendRPC(ctx, n.IP(), n.Port(), msg, ln.Protocol.StoreTimeout)
+	if err != nil {
+		// the token may have expired on their side, don't reuse it
+		ln.forgetToken(n.IP(), n.Port())
+	}
+	return err
+}
+
+// writeToken returns a write token from n. If we hold no fresh one we get
+// one with a FIND_NODE for keyID first.
+func (ln *Server) writeToken(ctx context.Context, n node.Node, keyID *big.Int) (string, error) {
+	token, ok := ln.tokenFor(n.IP(), n.Port())
+	if !ok {
+		if _, err := ln.FindNodeOnce(ctx, keyID, n.IP(), n.Port()); err != nil {
+			return "", fmt.Errorf("fetching store token: %w", err)
+		}
+		if token, ok = ln.tokenFor(n.IP(), n.Port()); !ok {
+			return "", fmt.Errorf("node did not hand out a store token")
+		}
+	}
+	return token, nil
+}
+
+// rememberToken records a write token handed out by ip:port.
+func (ln *Server) rememberToken(ip string, port int, token string) {
+	if token == "" {
+		return
+	}
+	ln.peerTokensMu.Lock()
+	defer ln.peerTokensMu.Unlock()
+
+	ln.peerTokens[fmt.Sprintf("%s:%d", ip, port)] = peerToken{
+		token:     token,
+		expiresAt: time.Now().Add(TOKEN_ROTATION),
+	}
+}
+
+// tokenFor returns a write token from ip:port that should still be valid.
+func (ln *Server) tokenFor(ip string, port int) (string, bool) {
+	ln.peerTokensMu.Lock()
+	defer ln.peerTokensMu.Unlock()
+
+	pt, ok := ln.peerTokens[fmt.Sprintf("%s:%d", ip, port)]
+	if !ok || time.Now().After(pt.expiresAt) {
+		return "", false
+	}
+	return pt.token, true
+}
+
+// expireTokens drops the write tokens received more than two rotations ago,
+// which no peer accepts any more, and returns how many it dropped.
+func (ln *Server) expireTokens() int {
+	ln.peerTokensMu.Lock()
+	defer ln.peerTokensMu.Unlock()
+
+	now := time.Now()
+	dropped := 0
+	for addr, pt := range ln.peerTokens {
+		if now.After(pt.expiresAt.Add(TOKEN_ROTATION)) {
+			delete(ln.peerTokens, addr)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (ln *Server) forgetToken(ip string, port int) {
+	ln.peerTokensMu.Lock()
+	defer ln.peerTokensMu.Unlock()
+
+	delete(ln.peerTokens, fmt.Sprintf("%s:%d", ip, port))
+}
+
+func (ln *Server) FindValueOnce(ctx context.Context, key string, ip string, port int) (value []byte, nodes []node.Node, err error) {
+	resp, nodes, err := ln.findValueOnce(ctx, key, nil, ip, port)
+	if err != nil || resp == nil {
+		return nil, nodes, err
+	}
+	return resp.Value, nil, nil
+}
+
+// findValueOnce is FindValueOnce returning the whole response when it carries
+// a value, so callers can check metadata like Mutable. A non-nil targetID
+// asks for the contacts closest to it rather than to KeyID(key).
+func (ln *Server) findValueOnce(ctx context.Context, key string, targetID *big.Int, ip string, port int) (*transport.RPCMessage, []node.Node, error) {
+	msg := ln.newRPC(transport.RPCFindValue)
+	msg.Key = key
+	if targetID != nil {
+		msg.TargetID = node.NodeIDToHex(targetID)
+	}
+
+	resp, err := ln.sendRPC(ctx, ip, port, msg, ln.Protocol.RPCTimeout)
+	if err != nil {
+		return nil, nil, ctxOr(ctx, fmt.Errorf("SendRPC FindValue: %w", err))
+	}
+	ln.rememberToken(ip, port, resp.Token)
+
+	// If the value field is non-empty, we’re done, as long as it is intact.
+	if len(resp.Value) > 0 {
+		if err := storeRequestFromRPC(resp).verify(); err != nil {
+			return nil, nil, fmt.Errorf("FindValue from %s:%d: %w", ip, port, err)
+		}
+		return resp, nil, nil
+	}
+
+	// Otherwise, convert resp.Nodes to []Node (just like FindNodeOnce)
+	out := make([]node.Node, 0, len(resp.Nodes))
+	for _, info := range resp.Nodes {
+		n, err := transport.NodeFromInfo(info)
+		if err != nil {
+			continue
+		}
+		ln.admitContact(n)
+		out = append(out, n)
+	}
+
+	return nil, out, nil
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"cs249-dht/storage"
+)
+
+// setting is one Config field that can be set from a config file, the
+// environment or a flag. In a file it is called name, in the environment
+// DHT_NAME, and as a flag -name with dashes for underscores.
+type setting struct {
+	name  string
+	usage string
+	field func(cfg *Config) any // pointer to the field in cfg
+}
+
+// settings lists every tunable field of Config.
+var settings = []setting{
+	{"ip", "address to bind and advertise", func(c *Config) any { return &c.IP }},
+	{"port", "port to bind", func(c *Config) any { return &c.Port }},
+	{"bootstrap", "comma separated ip:port of nodes to join through, empty for the first node", func(c *Config) any { return &c.Bootstrap }},
+	{"secure", "use S/Kademlia identities (signed RPCs, crypto puzzle node IDs)", func(c *Config) any { return &c.Secure }},
+	{"static_difficulty", "static crypto puzzle difficulty in bits", func(c *Config) any { return &c.Puzzle.Static }},
+	{"dynamic_difficulty", "dynamic crypto puzzle difficulty in bits", func(c *Config) any { return &c.Puzzle.Dynamic }},
+	{"encrypt", "encrypt all RPC traffic over an authenticated secure channel (requires secure)", func(c *Config) any { return &c.Encrypt }},
+
+	{"k", "bucket size, and how many closest nodes a lookup returns", func(c *Config) any { return &c.Protocol.Routing.K }},
+	{"split_bits", "b: buckets that don't cover our own ID are only split at depths that are a multiple of this", func(c *Config) any { return &c.Protocol.Routing.B }},
+	{"replacement_factor", "each bucket keeps up to k times this many replacement contacts", func(c *Config) any { return &c.Protocol.Routing.ReplacementFactor }},
+	{"alpha", "parallel RPCs per lookup step", func(c *Config) any { return &c.Protocol.Alpha }},
+	{"replication", "how many of the k closest nodes a value is stored on, 0 = all k", func(c *Config) any { return &c.Protocol.Replication }},
+	{"rpc_timeout", "how long to wait for a reply to a lookup RPC", func(c *Config) any { return &c.Protocol.RPCTimeout }},
+	{"store_timeout", "how long to wait for a STORE, ANNOUNCE or DELETE to be acked", func(c *Config) any { return &c.Protocol.StoreTimeout }},
+	{"maintenance_interval", "how often expiry, tombstone GC and republishing run", func(c *Config) any { return &c.Protocol.MaintenanceInterval }},
+	{"republish_interval", "how long a value goes without being stored before it is republished", func(c *Config) any { return &c.Protocol.RepublishInterval }},
+
+	{"rate", "RPCs per second allowed from a single IP", func(c *Config) any { return &c.Limits.PerIP.Rate }},
+	{"burst", "RPCs a single IP may send at once", func(c *Config) any { return &c.Limits.PerIP.Burst }},
+	{"handoff_rate", "STOREs per second sent handing keys to new nodes", func(c *Config) any { return &c.Limits.Handoff.Rate }},
+	{"handoff_burst", "STOREs sent at once handing keys to new nodes", func(c *Config) any { return &c.Limits.Handoff.Burst }},
+	{"max_keys_per_publisher", "keys one publisher may store here, 0 = unlimited", func(c *Config) any { return &c.Limits.MaxKeysPerPublisher }},
+	{"max_bytes_per_publisher", "bytes one publisher may store here, 0 = unlimited", func(c *Config) any { return &c.Limits.MaxBytesPerPublisher }},
+	{"max_store_keys", "global cap on stored keys, 0 = unlimited", func(c *Config) any { return &c.Limits.MaxStoreKeys }},
+	{"max_store_bytes", "global cap on stored bytes, 0 = unlimited", func(c *Config) any { return &c.Limits.MaxStoreBytes }},
+	{"max_tombstones", "global cap on tombstones of deleted keys, 0 = unlimited", func(c *Config) any { return &c.Limits.MaxTombstones }},
+	{"eviction", "what to do when the store is full: oldest or none", func(c *Config) any { return &c.Limits.Eviction }},
+
+	{"write_quorum", "STORE acks needed for a write to succeed, 0 = majority of the replicas", func(c *Config) any { return &c.Quorum.Write }},
+	{"read_quorum", "replicas a read gathers values from before resolving conflicts", func(c *Config) any { return &c.Quorum.Read }},
+	{"conflict", "how reads resolve differing replicas: version, latest, seq or majority", func(c *Config) any { return &c.Quorum.Conflict }},
+	{"read_repair", "push the winning value of a read to stale replicas", func(c *Config) any { return &c.Quorum.ReadRepair }},
+
+	{"state_file", "file to save stored values and contacts to on shutdown, and restore them from on start", func(c *Config) any { return &c.StateFile }},
+	{"notify_leave", "tell contacts we are leaving on shutdown", func(c *Config) any { return &c.NotifyLeave }},
+
+	{"log_level", "least severe log records written to stderr: debug, info, warn, error or off", func(c *Config) any { return &c.Log.Level }},
+	{"log_format", "log as text or json", func(c *Config) any { return &c.Log.Format }},
+}
+
+func lookupSetting(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// set parses value into the setting's field in cfg.
+func (s setting) set(cfg *Config, value string) error {
+	var err error
+	switch field := s.field(cfg).(type) {
+	case *string:
+		*field = value
+	case *int:
+		*field, err = strconv.Atoi(value)
+	case *float64:
+		*field, err = strconv.ParseFloat(value, 64)
+	case *bool:
+		*field, err = strconv.ParseBool(value)
+	case *time.Duration:
+		*field, err = time.ParseDuration(value)
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	case *ConflictPolicy:
+		*field, err = ParseConflictPolicy(value)
+	case *storage.EvictionPolicy:
+		*field, err = storage.ParseEvictionPolicy(value)
+	case *slog.Level:
+		*field, err = ParseLogLevel(value)
+	default:
+		panic(fmt.Sprintf("setting %s has unsupported type %T", s.name, field))
+	}
+	return err
+}
+
+// get formats the setting's field in cfg the way set parses it.
+func (s setting) get(cfg *Config) string {
+	switch field := s.field(cfg).(type) {
+	case *string:
+		return *field
+	case *int:
+		return strconv.Itoa(*field)
+	case *float64:
+		return strconv.FormatFloat(*field, 'g', -1, 64)
+	case *bool:
+		return strconv.FormatBool(*field)
+	case *time.Duration:
+		return field.String()
+	case *[]string:
+		return strings.Join(*field, ",")
+	case *ConflictPolicy:
+		return field.String()
+	case *storage.EvictionPolicy:
+		return field.String()
+	case *slog.Level:
+		return FormatLogLevel(*field)
+	}
+	panic(fmt.Sprintf("setting %s has unsupported type", s.name))
+}
+
+// flagName is the command line flag a setting is read from.
+func (s setting) flagName() string {
+	return strings.ReplaceAll(s.name, "_", "-")
+}
+
+// ConfigFlags collects the settings given on the command line.
+type ConfigFlags map[string]string
+
+// settingFlag is the flag.Value of one setting. It only records the value;
+// LoadConfig applies it on top of the file and the environment.
+type settingFlag struct {
+	setting
+	def   string
+	given ConfigFlags
+}
+
+func (f *settingFlag) String() string { return f.def }
+
+func (f *settingFlag) Set(value string) error {
+	var scratch Config
+	if err := f.setting.set(&scratch, value); err != nil {
+		return err
+	}
+	f.given[f.name] = value
+	return nil
+}
+
+func (f *settingFlag) IsBoolFlag() bool {
+	_, ok := f.field(&Config{}).(*bool)
+	return ok
+}
+
+// BindConfigFlags defines a flag on fs for every Config setting and returns
+// where the ones given are collected, to pass to LoadConfig after fs.Parse.
+func BindConfigFlags(fs *flag.FlagSet) ConfigFlags {
+	given := make(ConfigFlags)
+	defaults := DefaultConfig()
+	for _, s := range settings {
+		fs.Var(&settingFlag{setting: s, def: s.get(&defaults), given: given}, s.flagName(), s.usage)
+	}
+	return given
+}
+
+// LoadConfig builds a Config from DefaultConfig, then the file at path (if
+// not empty), then DHT_* variables in environ, then flags, each overriding
+// the ones before. The file may be JSON, YAML or TOML, chosen by extension,
+// and holds settings by name at the top level. Unknown settings are an
+// error, and so is a result that doesn't pass Validate.
+func LoadConfig(path string, environ []string, flags ConfigFlags) (Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := applySettings(&cfg, path, values); err != nil {
+			return cfg, err
+		}
+	}
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, "DHT_") {
+			continue
+		}
+		env[strings.ToLower(strings.TrimPrefix(name, "DHT_"))] = value
+	}
+	if err := applySettings(&cfg, "environment", env); err != nil {
+		return cfg, err
+	}
+
+	if err := applySettings(&cfg, "flags", flags); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// applySettings sets every named setting in values on cfg, in a stable
+// order so errors are reproducible. source names where values came from.
+func applySettings(cfg *Config, source string, values map[string]string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s, ok := lookupSetting(name)
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", source, name)
+		}
+		if err := s.set(cfg, values[name]); err != nil {
+			return fmt.Errorf("%s: %s: %w", source, name, err)
+		}
+	}
+	return nil
+}
+
+// readConfigFile decodes a JSON, YAML or TOML file into setting values.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	raw := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config %s: unknown format %q, wanted .json, .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case map[string]any:
+			return nil, fmt.Errorf("config %s: %s: wanted a value, got a section", path, name)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
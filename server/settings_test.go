@@ -0,0 +1,158 @@
+package server
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cs249-dht/storage"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig("", nil, nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Errorf("got %+v, wanted the defaults", cfg)
+	}
+	if cfg.Protocol.Routing.K != 20 || cfg.Protocol.Alpha != 3 || cfg.Protocol.Routing.B != 5 {
+		t.Errorf("got k=%d alpha=%d b=%d, wanted the paper's 20, 3 and 5",
+			cfg.Protocol.Routing.K, cfg.Protocol.Alpha, cfg.Protocol.Routing.B)
+	}
+}
+
+func TestLoadConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"node.json": `{"port": 9001, "k": 8, "replication": 4, "bootstrap": ["10.0.0.1:8090", "10.0.0.2:8090"],
+			"rpc_timeout": "2s", "rate": 12.5, "max_store_bytes": 268435456, "conflict": "majority", "eviction": "none"}`,
+		"node.yaml": "port: 9001\nk: 8\nreplication: 4\nbootstrap: [10.0.0.1:8090, 10.0.0.2:8090]\n" +
+			"rpc_timeout: 2s\nrate: 12.5\nmax_store_bytes: 268435456\nconflict: majority\neviction: none\n",
+		"node.toml": "port = 9001\nk = 8\nreplication = 4\nbootstrap = [\"10.0.0.1:8090\", \"10.0.0.2:8090\"]\n" +
+			"rpc_timeout = \"2s\"\nrate = 12.5\nmax_store_bytes = 268435456\nconflict = \"majority\"\neviction = \"none\"\n",
+	}
+	for name, content := range files {
+		cfg, err := LoadConfig(writeConfigFile(t, name, content), nil, nil)
+		if err != nil {
+			t.Errorf("%s: LoadConfig: %v", name, err)
+			continue
+		}
+		if cfg.Port != 9001 || cfg.Protocol.Routing.K != 8 || cfg.Protocol.Replication != 4 {
+			t.Errorf("%s: got port=%d k=%d replication=%d, wanted 9001, 8 and 4",
+				name, cfg.Port, cfg.Protocol.Routing.K, cfg.Protocol.Replication)
+		}
+		if !reflect.DeepEqual(cfg.Bootstrap, []string{"10.0.0.1:8090", "10.0.0.2:8090"}) {
+			t.Errorf("%s: got bootstrap %v, wanted both addresses", name, cfg.Bootstrap)
+		}
+		if cfg.Protocol.RPCTimeout != 2*time.Second || cfg.Limits.PerIP.Rate != 12.5 || cfg.Limits.MaxStoreBytes != 256<<20 {
+			t.Errorf("%s: got timeout=%v rate=%v max=%d, wanted 2s, 12.5 and 256MiB",
+				name, cfg.Protocol.RPCTimeout, cfg.Limits.PerIP.Rate, cfg.Limits.MaxStoreBytes)
+		}
+		if cfg.Quorum.Conflict != Majority || cfg.Limits.Eviction != storage.EvictNone {
+			t.Errorf("%s: got conflict=%v eviction=%v, wanted majority and none", name, cfg.Quorum.Conflict, cfg.Limits.Eviction)
+		}
+		// untouched settings keep their defaults
+		if cfg.Protocol.Alpha != ALPHA || cfg.IP != "127.0.0.1" {
+			t.Errorf("%s: got alpha=%d ip=%s, wanted the defaults", name, cfg.Protocol.Alpha, cfg.IP)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "node.yaml", "port: 9001\nk: 8\nalpha: 2\n")
+	environ := []string{"DHT_K=10", "DHT_ALPHA=4", "HOME=/root"}
+
+	fs := flag.NewFlagSet("dht", flag.ContinueOnError)
+	flags := BindConfigFlags(fs)
+	if err := fs.Parse([]string{"-alpha=5", "-read-repair"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	cfg, err := LoadConfig(path, environ, flags)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Port != 9001 {
+		t.Errorf("got port %d, wanted 9001 from the file", cfg.Port)
+	}
+	if cfg.Protocol.Routing.K != 10 {
+		t.Errorf("got k=%d, wanted 10 from the environment over the file", cfg.Protocol.Routing.K)
+	}
+	if cfg.Protocol.Alpha != 5 || !cfg.Quorum.ReadRepair {
+		t.Errorf("got alpha=%d read_repair=%v, wanted 5 and true from the flags", cfg.Protocol.Alpha, cfg.Quorum.ReadRepair)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		file    string
+		content string
+		environ []string
+		wanted  string
+	}{
+		{"node.yaml", "kay: 8\n", nil, `unknown setting "kay"`},
+		{"node.yaml", "routing:\n  k: 8\n", nil, "section"},
+		{"node.ini", "k = 8\n", nil, "unknown format"},
+		{"node.json", `{"k": "many"}`, nil, "k:"},
+		{"node.toml", "k = 0\n", nil, "k must be at least 1"},
+		{"node.toml", "replication = 30\n", nil, "replication must be between 0 and k=20"},
+		{"node.toml", "rpc_timeout = \"-1s\"\n", nil, "rpc timeout must be positive"},
+		{"node.toml", "encrypt = true\n", nil, "encrypt requires secure"},
+		{"node.toml", "bootstrap = [\"nowhere\"]\n", nil, "bootstrap address"},
+		{"node.toml", "", []string{"DHT_ALPA=3"}, `environment: unknown setting "alpa"`},
+		{"node.toml", "", []string{"DHT_CONFLICT=loudest"}, "unknown conflict policy"},
+	}
+	for _, test := range tests {
+		_, err := LoadConfig(writeConfigFile(t, test.file, test.content), test.environ, nil)
+		if err == nil || !strings.Contains(err.Error(), test.wanted) {
+			t.Errorf("%s %q %v: got %v, wanted an error containing %q", test.file, test.content, test.environ, err, test.wanted)
+		}
+	}
+}
+
+func TestConfigFlagsRejectBadValues(t *testing.T) {
+	fs := flag.NewFlagSet("dht", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	BindConfigFlags(fs)
+	if err := fs.Parse([]string{"-rpc-timeout=soon"}); err == nil {
+		t.Errorf("got nil error for a bad duration flag, wanted error")
+	}
+}
+
+func TestSetProtocolKeepsContacts(t *testing.T) {
+	s, err := NewServer("127.0.0.1", 20901)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer s.Close()
+	peer, err := NewServer("127.0.0.1", 20902)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer peer.Close()
+	s.Router.AddContact(peer.Self)
+
+	protocol := DefaultProtocolConfig()
+	protocol.Routing.K = 4
+	protocol.Replication = 4
+	s.SetProtocol(protocol)
+
+	if !containsNode(s.Router.Contacts(), peer.Self) {
+		t.Errorf("wanted the contact kept across SetProtocol")
+	}
+	if s.Protocol.Routing.K != 4 {
+		t.Errorf("got k=%d, wanted 4", s.Protocol.Routing.K)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cs249-dht/node"
+	"cs249-dht/storage"
+	"cs249-dht/transport"
+)
+
+// Shutdown stops the node gracefully. With NotifyLeave set it first tells
+// its contacts it is leaving. Then it stops taking requests and stops the
+// background loops, waits for the requests already received to be handled,
+// closes the socket and, with a StateFile, saves the store and routing table
+// there. If ctx is done before the handlers finish it stops waiting, still
+// closes and saves, and returns ctx.Err().
+func (ln *Server) Shutdown(ctx context.Context) error {
+	if ln.notifyLeave {
+		ln.notifyLeaving(ctx)
+	}
+
+	ln.Transport.StopListening()
+	ln.stop()
+
+	ln.runMu.Lock()
+	done := ln.runDone
+	ln.runMu.Unlock()
+
+	var waitErr error
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
+		}
+	}
+
+	if err := ln.Close(); err != nil {
+		return fmt.Errorf("Shutdown: %w", err)
+	}
+	if ln.stateFile != "" {
+		if err := ln.SaveState(ln.stateFile); err != nil {
+			return fmt.Errorf("Shutdown: %w", err)
+		}
+	}
+	return waitErr
+}
+
+// notifyLeaving sends a LEAVE to every contact in parallel and waits for
+// their acks, or for ctx.
+func (ln *Server) notifyLeaving(ctx context.Context) {
+	contacts := ln.Router.Contacts()
+
+	var wg sync.WaitGroup
+	for _, n := range contacts {
+		wg.Add(1)
+		go func(n node.Node) {
+			defer wg.Done()
+			msg := ln.newRPC(transport.RPCLeave)
+			if _, err := ln.sendRPC(ctx, n.IP(), n.Port(), msg, LEAVE_TIMEOUT); err != nil {
+				ln.Logger.Info("telling contact we leave", "peer", peerAddr(n.IP(), n.Port()), "err", err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	ln.Logger.Info("told contacts we are leaving", "contacts", len(contacts))
+}
+
+// handleLeaveRPC drops a departing node from the routing table. Only the
+// node itself may say it leaves.
+func (ln *Server) handleLeaveRPC(msg *transport.RPCMessage, from *net.UDPAddr) {
+	if !provenSender(msg, from) {
+		ln.sendErrorRPC(msg, from, "leave needs a signed rpc or a sender ID matching its address")
+		return
+	}
+
+	leaving, err := transport.NodeFromRPC(msg)
+	if err != nil {
+		ln.sendErrorRPC(msg, from, err.Error())
+		return
+	}
+	ln.Router.RemoveContact(*leaving)
+	ln.forgetToken(leaving.IP(), leaving.Port())
+	ln.Logger.Info("dropped leaving contact", "peer", from.String(), "peer_id", leaving.HexID())
+
+	ack := ln.newReply(msg, transport.RPCLeave)
+	if err := ln.sendDirectRPC(ack, from); err != nil {
+		ln.Logger.Warn("sending LEAVE ack", "peer", from.String(), "err", err)
+	}
+}
+
+// nodeState is what SaveState writes: our stored values and tombstones, and
+// our contacts, so a restarted node picks up where it left off.
+type nodeState struct {
+	Store    storage.StoreSnapshot
+	Contacts []transport.RPCNodeInfo
+}
+
+// SaveState writes the store and routing table to path. The file is
+// replaced atomically, so a crash mid-write leaves the previous state.
+func (ln *Server) SaveState(path string) error {
+	state := nodeState{Store: ln.Store.Snapshot()}
+	for _, n := range ln.Router.Contacts() {
+		state.Contacts = append(state.Contacts, transport.NodeToRPC(&n))
+	}
+
+	encoded, err := json.Marshal(&state)
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	return nil
+}
+
+// LoadState restores the store and routing table saved by SaveState. Meant
+// to be called before Start; New does so for Config.StateFile. Contacts that
+// can't prove their identity in S/Kademlia mode are left out.
+func (ln *Server) LoadState(path string) error {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("loading state: %w", err)
+	}
+	var state nodeState
+	if err := json.Unmarshal(encoded, &state); err != nil {
+		return fmt.Errorf("loading state from %s: %w", path, err)
+	}
+
+	values := ln.Store.Restore(state.Store)
+
+	contacts := make([]node.Node, 0, len(state.Contacts))
+	for _, info := range state.Contacts {
+		n, err := transport.NodeFromInfo(info)
+		if err != nil {
+			continue
+		}
+		if ln.Identity != nil && node.VerifyNodeProof(n, ln.Puzzle) != nil {
+			continue
+		}
+		contacts = append(contacts, n)
+	}
+	ln.Router.LoadContacts(contacts)
+
+	ln.Logger.Info("restored state", "values", values, "contacts", len(contacts), "file", path)
+	return nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShutdownNotifiesAndSavesState(t *testing.T) {
+	stay := startHonestNode(t, 20801)
+	defer stay.Close()
+
+	cfg := DefaultConfig()
+	cfg.Port = 20802
+	cfg.Bootstrap = []string{"127.0.0.1:20801"}
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	cfg.NotifyLeave = true
+
+	leave, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := leave.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	leave.StoreLocal("k", []byte("v"))
+	waitForRouting()
+
+	if !containsNode(stay.Router.Contacts(), leave.Self) {
+		t.Fatalf("wanted the joining node in the routing table before it leaves")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := leave.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if containsNode(stay.Router.Contacts(), leave.Self) {
+		t.Errorf("got the leaving node still in the routing table, wanted it dropped")
+	}
+
+	// the socket is released, and the same node comes back with its state
+	back, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New after Shutdown: %v", err)
+	}
+	defer back.Close()
+	if value, ok := back.GetLocal("k"); !ok || string(value) != "v" {
+		t.Errorf("got %q, %v, wanted the stored value restored", value, ok)
+	}
+	if !containsNode(back.Router.Contacts(), stay.Self) {
+		t.Errorf("wanted the routing table restored")
+	}
+}
+
+func TestShutdownWithoutRun(t *testing.T) {
+	s, err := NewServer("127.0.0.1", 20803)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+}
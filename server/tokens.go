@@ -0,0 +1,93 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+)
+
+// TokenManager hands out BEP 5-style write tokens: an HMAC over the
+// requester's address with a secret that rotates every TOKEN_ROTATION.
+// Tokens made with the current or the previous secret are accepted, so a
+// token stays valid for between one and two rotation periods.
+type TokenManager struct {
+	mu          sync.Mutex
+	secret      []byte
+	prevSecret  []byte
+	rotatedAt   time.Time
+	rotateEvery time.Duration
+	now         func() time.Time // swapped out by tests
+}
+
+func NewTokenManager(rotateEvery time.Duration) *TokenManager {
+	tm := &TokenManager{
+		rotateEvery: rotateEvery,
+		now:         time.Now,
+	}
+	tm.secret = newTokenSecret()
+	tm.prevSecret = tm.secret
+	tm.rotatedAt = tm.now()
+	return tm
+}
+
+func newTokenSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return secret
+}
+
+// rotate swaps in a new secret if the current one is old enough.
+// Caller must hold tm.mu.
+func (tm *TokenManager) rotate() {
+	now := tm.now()
+	for now.Sub(tm.rotatedAt) >= tm.rotateEvery {
+		tm.prevSecret = tm.secret
+		tm.secret = newTokenSecret()
+		tm.rotatedAt = tm.rotatedAt.Add(tm.rotateEvery)
+	}
+}
+
+// Issue returns the token for a requester at addr.
+func (tm *TokenManager) Issue(addr *net.UDPAddr) string {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.rotate()
+	return tokenFor(tm.secret, addr)
+}
+
+// Validate reports whether token was issued to addr recently enough.
+func (tm *TokenManager) Validate(token string, addr *net.UDPAddr) bool {
+	if token == "" {
+		return false
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.rotate()
+	for _, secret := range [][]byte{tm.secret, tm.prevSecret} {
+		if hmac.Equal([]byte(token), []byte(tokenFor(secret, addr))) {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenFor(secret []byte, addr *net.UDPAddr) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(addr.String()))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// peerToken is a write token some other node gave us.
+type peerToken struct {
+	token     string
+	expiresAt time.Time
+}
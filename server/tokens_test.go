@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cs249-dht/transport"
+)
+
+func TestTokenRotation(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tm := NewTokenManager(time.Minute)
+	tm.now = func() time.Time { return now }
+	tm.rotatedAt = now
+
+	alice := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4001}
+	bob := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4002}
+
+	token := tm.Issue(alice)
+
+	if !tm.Validate(token, alice) {
+		t.Errorf("got false, wanted fresh token to be valid")
+	}
+	if tm.Validate(token, bob) {
+		t.Errorf("got true, wanted token to be bound to the requester's address")
+	}
+	if tm.Validate("", alice) {
+		t.Errorf("got true, wanted empty token to be invalid")
+	}
+
+	// one rotation later the previous secret still counts
+	now = now.Add(time.Minute + time.Second)
+	if !tm.Validate(token, alice) {
+		t.Errorf("got false, wanted token to survive one rotation")
+	}
+
+	// two rotations later it is gone
+	now = now.Add(time.Minute)
+	if tm.Validate(token, alice) {
+		t.Errorf("got true, wanted token to expire after two rotations")
+	}
+}
+
+func TestStoreRequiresToken(t *testing.T) {
+	storer := startHonestNode(t, 19601)
+
+	client, err := NewServer("127.0.0.1", 19602)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	// a bare STORE with no token is refused
+	msg := client.newRPC(transport.RPCStore)
+	msg.Key = "spoofed"
+	msg.Value = []byte("junk")
+	_, err = client.sendRPC(context.Background(), "127.0.0.1", 19601, msg, 2*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "token") {
+		t.Errorf("got %v, wanted token error", err)
+	}
+
+	// a token issued to someone else is refused too
+	other := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 19603}
+	msg = client.newRPC(transport.RPCStore)
+	msg.Key = "spoofed"
+	msg.Value = []byte("junk")
+	msg.Token = storer.Tokens.Issue(other)
+	_, err = client.sendRPC(context.Background(), "127.0.0.1", 19601, msg, 2*time.Second)
+	if err == nil {
+		t.Errorf("got nil error for stolen token, wanted error")
+	}
+
+	waitForRouting()
+	if _, ok := storer.GetLocal("spoofed"); ok {
+		t.Errorf("spoofed STORE was written")
+	}
+
+	// StoreValue fetches a token first and succeeds
+	client.PingBootstrap(context.Background(), "127.0.0.1", 19601)
+	if _, err := client.StoreValue(context.Background(), "hello", []byte("world")); err != nil {
+		t.Fatalf("StoreValue: %v", err)
+	}
+
+	waitForRouting()
+	got, ok := storer.GetLocal("hello")
+	if !ok || string(got) != "world" {
+		t.Errorf("got %q (found=%t), wanted %q", got, ok, "world")
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"cs249-dht/node"
+	"cs249-dht/routing"
+)
+
+// LookupTrace records what an iterative lookup did, round by round: every
+// node it queried and what came back, the heap of closest candidates after
+// each round and how close it had got to the target. A lookup records into
+// the trace carried by its context, see WithLookupTrace. Distances are XOR
+// distances to the target in hex, with their bit length alongside so the
+// convergence is easy to read off.
+type LookupTrace struct {
+	mu sync.Mutex
+
+	// the node that ran the lookup
+	Origin TraceNode `json:"origin"`
+	Target string    `json:"target"`
+	// node or value
+	Kind           string       `json:"kind"`
+	Started        time.Time    `json:"started"`
+	DurationMillis float64      `json:"duration_ms"`
+	Rounds         []TraceRound `json:"rounds"`
+	// what the lookup returned, closest first
+	Result []TraceNode `json:"result"`
+}
+
+// TraceNode is a node as a trace reports it.
+type TraceNode struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr,omitempty"`
+	// XOR distance to the target, empty for the origin
+	Distance     string `json:"distance,omitempty"`
+	DistanceBits int    `json:"distance_bits,omitempty"`
+}
+
+// TraceRound is one batch of up to alpha queries of one lookup path.
+type TraceRound struct {
+	// lookup path, always 0 unless the lookup is disjoint
+	Path  int `json:"path"`
+	Round int `json:"round"`
+	// queries in the order they were sent
+	Queries []TraceQuery `json:"queries"`
+	// the candidates after the round, closest first
+	Heap []TraceCandidate `json:"heap"`
+	// distance of the closest candidate after the round
+	Closest     string `json:"closest"`
+	ClosestBits int    `json:"closest_bits"`
+}
+
+// TraceQuery is one FIND_NODE or FIND_VALUE sent during a lookup.
+type TraceQuery struct {
+	TraceNode
+	RTTMillis float64 `json:"rtt_ms"`
+	// answered, timeout, error, or skipped when another disjoint path owns the node
+	Outcome string `json:"outcome"`
+	Err     string `json:"error,omitempty"`
+	// the contacts it answered with
+	Returned []TraceNode `json:"returned,omitempty"`
+	// it answered a FIND_VALUE with the value
+	Value bool `json:"value,omitempty"`
+}
+
+// TraceCandidate is a node in a lookup's heap.
+type TraceCandidate struct {
+	TraceNode
+	Contacted bool `json:"contacted"`
+}
+
+type lookupTraceKey struct{}
+
+// WithLookupTrace returns a context under which LookupNodes,
+// LookupNodesDisjoint and value lookups record into trace. The first
+// lookup run under it fills in the target; a trace is meant for one lookup.
+func WithLookupTrace(ctx context.Context, trace *LookupTrace) context.Context {
+	return context.WithValue(ctx, lookupTraceKey{}, trace)
+}
+
+// lookupTraceFrom returns the trace carried by ctx, or nil. All the
+// recording methods do nothing on a nil trace.
+func lookupTraceFrom(ctx context.Context) *LookupTrace {
+	trace, _ := ctx.Value(lookupTraceKey{}).(*LookupTrace)
+	return trace
+}
+
+// TraceLookup runs LookupNodes for targetID and returns its trace with the
+// result, which is also in the trace. The trace is returned even if the
+// lookup fails.
+func (ln *Server) TraceLookup(ctx context.Context, targetID *big.Int) (*LookupTrace, []node.Node, error) {
+	trace := &LookupTrace{}
+	nodes, err := ln.LookupNodes(WithLookupTrace(ctx, trace), targetID)
+	return trace, nodes, err
+}
+
+// traceNode describes n at its distance from target.
+func traceNode(target, n *node.Node) TraceNode {
+	dist := target.GetXorDistance(n)
+	return TraceNode{
+		ID:           n.HexID(),
+		Addr:         peerAddr(n.IP(), n.Port()),
+		Distance:     node.NodeIDToHex(dist),
+		DistanceBits: dist.BitLen(),
+	}
+}
+
+// start fills in the lookup unless an earlier one already did.
+func (t *LookupTrace) start(self node.Node, kind string, target *node.Node) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Target != "" {
+		return
+	}
+	t.Origin = TraceNode{ID: self.HexID(), Addr: peerAddr(self.IP(), self.Port())}
+	t.Target = target.HexID()
+	t.Kind = kind
+	t.Started = time.Now()
+}
+
+// addRound appends round, snapshotting heap as it is now.
+func (t *LookupTrace) addRound(round TraceRound, target *node.Node, heap *routing.BoundedNodeHeap) {
+	if t == nil {
+		return
+	}
+	for _, n := range heap.Closest() {
+		round.Heap = append(round.Heap, TraceCandidate{TraceNode: traceNode(target, n), Contacted: heap.Contacted(n)})
+	}
+	if len(round.Heap) > 0 {
+		round.Closest = round.Heap[0].Distance
+		round.ClosestBits = round.Heap[0].DistanceBits
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Rounds = append(t.Rounds, round)
+}
+
+// finish records the lookup's result and how long it took.
+func (t *LookupTrace) finish(target *node.Node, nodes []node.Node) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.DurationMillis = float64(time.Since(t.Started).Microseconds()) / 1000
+	t.Result = make([]TraceNode, 0, len(nodes))
+	for i := range nodes {
+		t.Result = append(t.Result, traceNode(target, &nodes[i]))
+	}
+}
+
+// traceQuery describes the query of n that took rtt and ended in err.
+func traceQuery(target, n *node.Node, rtt time.Duration, err error) TraceQuery {
+	q := TraceQuery{TraceNode: traceNode(target, n), RTTMillis: float64(rtt.Microseconds()) / 1000, Outcome: "answered"}
+	if err != nil {
+		q.Outcome, q.Err = "error", err.Error()
+		if errors.Is(err, ErrTimeout) {
+			q.Outcome = "timeout"
+		}
+	}
+	return q
+}
+
+// WriteDOT writes the trace as a Graphviz graph: the origin and every
+// queried node, each with an edge to the contacts it returned, ranked left
+// to right by round. Nodes are named by the first 8 hex digits of their ID
+// and labelled with their distance in bits; the result is drawn with a
+// double border, timeouts in red and errors in orange. Render it with
+// `dot -Tsvg`.
+func (t *LookupTrace) WriteDOT(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	short := func(id string) string {
+		if len(id) > 8 {
+			return id[:8]
+		}
+		return id
+	}
+	inResult := make(map[string]bool)
+	for _, n := range t.Result {
+		inResult[n.ID] = true
+	}
+
+	// the first query of each node decides how it is drawn
+	type drawn struct {
+		TraceNode
+		outcome string
+		round   int
+	}
+	nodes := make(map[string]*drawn)
+	var order []string
+	see := func(n TraceNode, outcome string, round int) {
+		d, ok := nodes[n.ID]
+		if !ok {
+			d = &drawn{TraceNode: n, round: round}
+			nodes[n.ID] = d
+			order = append(order, n.ID)
+		}
+		if d.outcome == "" && outcome != "" {
+			d.outcome, d.round = outcome, round
+		}
+	}
+	for _, r := range t.Rounds {
+		for _, q := range r.Queries {
+			see(q.TraceNode, q.Outcome, r.Round)
+			for _, n := range q.Returned {
+				see(n, "", r.Round+1)
+			}
+		}
+	}
+	for _, n := range t.Result {
+		see(n, "", 0)
+	}
+
+	var err error
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("digraph lookup {\n")
+	printf("\trankdir=LR;\n")
+	printf("\tlabel=%q;\n", fmt.Sprintf("%s lookup of %s, %d rounds, %.1fms", t.Kind, short(t.Target), len(t.Rounds), t.DurationMillis))
+	printf("\tnode [shape=box, fontname=monospace];\n")
+	printf("\t%q [label=%q, shape=ellipse];\n", short(t.Origin.ID), "origin\n"+short(t.Origin.ID)+"\n"+t.Origin.Addr)
+
+	// rank the nodes by the round they were first queried or seen in
+	byRound := make(map[int][]string)
+	for _, id := range order {
+		d := nodes[id]
+		if id == t.Origin.ID {
+			continue
+		}
+		attrs := ""
+		switch d.outcome {
+		case "answered":
+			attrs = ", style=filled, fillcolor=palegreen"
+		case "timeout":
+			attrs = ", style=filled, fillcolor=lightcoral"
+		case "error":
+			attrs = ", style=filled, fillcolor=orange"
+		case "skipped", "":
+			attrs = ", style=dashed"
+		}
+		if inResult[id] {
+			attrs += ", peripheries=2"
+		}
+		printf("\t%q [label=%q%s];\n", short(id), fmt.Sprintf("%s\n%s\nd=%d bits", short(id), d.Addr, d.DistanceBits), attrs)
+		byRound[d.round] = append(byRound[d.round], short(id))
+	}
+	rounds := make([]int, 0, len(byRound))
+	for r := range byRound {
+		rounds = append(rounds, r)
+	}
+	sort.Ints(rounds)
+	for _, r := range rounds {
+		printf("\t{ rank=same;")
+		for _, id := range byRound[r] {
+			printf(" %q;", id)
+		}
+		printf(" }\n")
+	}
+
+	// nodes queried before anyone returned them came from the origin's own
+	// routing table; contacts pointing back at the origin are left out
+	returned := make(map[string]bool)
+	for _, r := range t.Rounds {
+		for _, q := range r.Queries {
+			if !returned[q.ID] {
+				printf("\t%q -> %q [label=%q];\n", short(t.Origin.ID), short(q.ID), fmt.Sprintf("r%d", r.Round))
+			}
+			for _, n := range q.Returned {
+				if n.ID != t.Origin.ID {
+					printf("\t%q -> %q;\n", short(q.ID), short(n.ID))
+				}
+			}
+		}
+		for _, q := range r.Queries {
+			for _, n := range q.Returned {
+				returned[n.ID] = true
+			}
+		}
+	}
+	printf("}\n")
+	return err
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+)
+
+func TestTraceLookup(t *testing.T) {
+	a := startHonestNode(t, 21131)
+	defer a.Close()
+	b := startHonestNode(t, 21132)
+	defer b.Close()
+	c := startHonestNode(t, 21133)
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := b.PingBootstrap(ctx, "127.0.0.1", 21131); err != nil {
+		t.Fatalf("PingBootstrap: %v", err)
+	}
+	if err := c.PingBootstrap(ctx, "127.0.0.1", 21131); err != nil {
+		t.Fatalf("PingBootstrap: %v", err)
+	}
+	// nobody listens here
+	c.Protocol.RPCTimeout = 200 * time.Millisecond
+	dead := node.NewNode(big.NewInt(7), "127.0.0.1", 21134)
+	c.Router.AddContact(dead)
+
+	// next to b: nodes don't return the node a FIND_NODE is for
+	targetID := new(big.Int).Xor(b.Self.ID(), big.NewInt(1))
+	trace, nodes, err := c.TraceLookup(ctx, targetID)
+	if err != nil {
+		t.Fatalf("TraceLookup: %v", err)
+	}
+	if trace.Target != node.NodeIDToHex(targetID) || trace.Kind != "node" || trace.Origin.ID != c.Self.HexID() {
+		t.Errorf("got target %s kind %s origin %s, wanted a node lookup next to b from c", trace.Target, trace.Kind, trace.Origin.ID)
+	}
+	if len(trace.Result) != len(nodes) || len(nodes) == 0 || trace.Result[0].ID != b.Self.HexID() || trace.Result[0].DistanceBits != 1 {
+		t.Errorf("got result %+v, wanted b first at distance 1", trace.Result)
+	}
+
+	outcomes := make(map[string]string)
+	for _, round := range trace.Rounds {
+		for _, q := range round.Queries {
+			outcomes[q.Addr] = q.Outcome
+		}
+		if len(round.Heap) == 0 || round.Closest != round.Heap[0].Distance {
+			t.Errorf("round %d: got closest %s for heap %+v", round.Round, round.Closest, round.Heap)
+		}
+	}
+	if outcomes["127.0.0.1:21131"] != "answered" || outcomes["127.0.0.1:21134"] != "timeout" {
+		t.Errorf("got outcomes %v, wanted a answered and the dead node timed out", outcomes)
+	}
+	last := trace.Rounds[len(trace.Rounds)-1]
+	if last.ClosestBits != 1 {
+		t.Errorf("got the lookup ending %d bits away, wanted it to converge on b", last.ClosestBits)
+	}
+
+	// survives a round trip through JSON, as the control API sends it
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded LookupTrace
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(decoded.Rounds) != len(trace.Rounds) || decoded.Rounds[0].Queries[0].Addr != trace.Rounds[0].Queries[0].Addr {
+		t.Errorf("got %s back, wanted the same rounds", data)
+	}
+
+	var dot strings.Builder
+	if err := trace.WriteDOT(&dot); err != nil {
+		t.Fatalf("WriteDOT: %v", err)
+	}
+	for _, want := range []string{
+		"digraph lookup {",
+		`"` + c.Self.HexID()[:8] + `" -> "` + a.Self.HexID()[:8] + `"`,
+		`"` + a.Self.HexID()[:8] + `" -> "` + b.Self.HexID()[:8] + `"`,
+		"fillcolor=lightcoral",
+		"peripheries=2",
+	} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("got no %s in\n%s", want, dot.String())
+		}
+	}
+}
+
+func TestLookupWithoutTraceRecordsNothing(t *testing.T) {
+	var trace *LookupTrace
+	trace.start(node.NewNodeFromInt(1), "node", nil)
+	trace.finish(nil, nil)
+	if got := lookupTraceFrom(context.Background()); got != nil {
+		t.Errorf("got %v, wanted no trace", got)
+	}
+}
+
+func TestFailedLookupFinishesTrace(t *testing.T) {
+	// knows nobody
+	a := startHonestNode(t, 21135)
+	defer a.Close()
+
+	trace, _, err := a.TraceLookup(context.Background(), big.NewInt(1))
+	if err == nil {
+		t.Fatalf("got no error, wanted the lookup to fail on an empty routing table")
+	}
+	if trace.Target == "" || trace.Result == nil {
+		t.Errorf("got target %q result %v, wanted the trace started and finished", trace.Target, trace.Result)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"math"
+
+	"cs249-dht/storage"
+)
+
+// observeVersion moves our Lamport clock past v. A clock more than
+// MAX_CLOCK_SKEW ahead of ours is refused and leaves our clock alone: it
+// would win every comparison for its key and run our clock out.
+func (ln *Server) observeVersion(v storage.Version) error {
+	ln.clockMu.Lock()
+	defer ln.clockMu.Unlock()
+
+	if v.Clock > ln.clock && v.Clock-ln.clock > MAX_CLOCK_SKEW {
+		return fmt.Errorf("version clock %d is too far ahead of ours (%d)", v.Clock, ln.clock)
+	}
+	if v.Clock > ln.clock {
+		ln.clock = v.Clock
+	}
+	return nil
+}
+
+// nextVersion returns the version for our next write to key: after
+// everything we've seen, including what we hold for key ourselves.
+func (ln *Server) nextVersion(key string) storage.Version {
+	if stored, ok := ln.Store.Get(key); ok {
+		ln.observeVersion(stored.Version)
+	}
+
+	ln.clockMu.Lock()
+	defer ln.clockMu.Unlock()
+
+	// saturate rather than wrap to 0, which would lose to everything
+	if ln.clock < math.MaxUint64 {
+		ln.clock++
+	}
+	return storage.Version{Clock: ln.clock, Publisher: ln.Self.HexID()}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"cs249-dht/storage"
+)
+
+func TestStoreKeepsNewerVersion(t *testing.T) {
+	ln, err := NewServer("127.0.0.1", 20501)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	put := func(value string, v storage.Version) error {
+		return ln.putStored(storeRequest{Key: "k", Value: []byte(value), Version: v}, v.Publisher)
+	}
+	if err := put("two", storage.Version{Clock: 2, Publisher: "a"}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := put("one", storage.Version{Clock: 1, Publisher: "b"}); !errors.Is(err, storage.ErrStaleVersion) {
+		t.Errorf("got %v, wanted %v", err, storage.ErrStaleVersion)
+	}
+	if got, _ := ln.GetLocal("k"); string(got) != "two" {
+		t.Errorf("got %q, wanted the newer value kept", got)
+	}
+
+	// whatever we publish next comes after what we've seen
+	if v := ln.nextVersion("k"); v.Clock <= 2 || v.Publisher != ln.Self.HexID() {
+		t.Errorf("got %v, wanted clock past 2 and our own ID", v)
+	}
+}
+
+func TestConcurrentWritesConverge(t *testing.T) {
+	first, err := NewServer("127.0.0.1", 20502)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	second, err := NewServer("127.0.0.1", 20503)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	// two publishers wrote at the same clock without seeing each other
+	alice := storeRequest{Key: "k", Value: []byte("alice"), Version: storage.Version{Clock: 1, Publisher: "alice"}}
+	bob := storeRequest{Key: "k", Value: []byte("bob"), Version: storage.Version{Clock: 1, Publisher: "bob"}}
+
+	// the replicas get them in opposite orders
+	first.putStored(alice, "alice")
+	first.putStored(bob, "bob")
+	second.putStored(bob, "bob")
+	second.putStored(alice, "alice")
+
+	for _, s := range []*Server{first, second} {
+		if got, _ := s.GetLocal("k"); string(got) != "bob" {
+			t.Errorf("got %q on %d, wanted both replicas to keep %q", got, s.Self.Port(), "bob")
+		}
+	}
+}
+
+func TestReadDetectsConcurrentVersions(t *testing.T) {
+	storers := []*Server{startHonestNode(t, 20506), startHonestNode(t, 20507)}
+	client := startHonestNode(t, 20508)
+	for _, s := range storers {
+		client.PingBootstrap(context.Background(), "127.0.0.1", s.Self.Port())
+	}
+	waitForRouting()
+
+	// a partial write left each replica with a different concurrent version
+	for i, who := range []string{"a", "b"} {
+		req := storeRequest{Key: "k", Value: []byte(who), Version: storage.Version{Clock: 3, Publisher: who}}
+		if err := storers[i].putStored(req, who); err != nil {
+			t.Fatalf("putStored: %v", err)
+		}
+	}
+
+	client.Quorum.Read = 2
+	result, err := client.ReadValue(context.Background(), "k")
+	if err != nil {
+		t.Fatalf("ReadValue: %v", err)
+	}
+	if !result.Conflicting {
+		t.Errorf("got Conflicting=false, wanted concurrent versions detected")
+	}
+	if string(result.Value) != "b" || result.Winner.Version.Publisher != "b" {
+		t.Errorf("got %q (%v), wanted the tie broken towards publisher b", result.Value, result.Winner.Version)
+	}
+}
+
+func TestHugeClockIsRefused(t *testing.T) {
+	ln, err := NewServer("127.0.0.1", 20509)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer ln.Close()
+
+	huge := storage.Version{Clock: math.MaxUint64 - 1, Publisher: "evil"}
+	if err := ln.putStored(storeRequest{Key: "k", Value: []byte("evil"), Version: huge}, "evil"); err == nil {
+		t.Errorf("got nil error for a clock near the maximum, wanted it refused")
+	}
+	if _, ok := ln.Store.Get("k"); ok {
+		t.Errorf("got the value stored, wanted it refused")
+	}
+	if v := ln.nextVersion("k"); v.Clock != 1 {
+		t.Errorf("got clock %d, wanted ours unaffected", v.Clock)
+	}
+
+	// our own clock stops at the maximum instead of wrapping
+	ln.clock = math.MaxUint64
+	if v := ln.nextVersion("other"); v.Clock != math.MaxUint64 {
+		t.Errorf("got clock %d, wanted it to saturate", v.Clock)
+	}
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	ErrHashMismatch   = errors.New("value does not hash to its key")
+	ErrKeyIsImmutable = errors.New("key holds a content-addressed item")
+)
+
+// ImmutableKey is the key a content-addressed value is stored under: the hex
+// SHA-256 of the value itself.
+func ImmutableKey(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyImmutable checks that value is what key addresses.
+func VerifyImmutable(key string, value []byte) error {
+	if ImmutableKey(value) != key {
+		return ErrHashMismatch
+	}
+	return nil
+}
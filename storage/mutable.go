@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrBadMutableSignature = errors.New("invalid mutable item signature")
+	ErrMutableKeyMismatch  = errors.New("key is not the hash of public key and salt")
+	ErrSeqTooLow           = errors.New("sequence number not newer than stored item")
+	ErrCASMismatch         = errors.New("compare-and-swap: stored sequence number differs")
+	ErrKeyIsMutable        = errors.New("key holds a signed mutable item")
+)
+
+// MutableMeta is what turns a plain value into a BEP 44-style mutable item.
+// The value lives under MutableKey(PublicKey, Salt) and only a higher Seq
+// signed by the same key can replace it.
+type MutableMeta struct {
+	PublicKey []byte `json:"k"`
+	Salt      []byte `json:"salt,omitempty"`
+	Seq       int64  `json:"seq"`
+	Signature []byte `json:"sig"`
+
+	// CAS is only set on STORE: the sequence number the writer expects to be
+	// replacing. Never stored.
+	CAS *int64 `json:"cas,omitempty"`
+}
+
+// MutableItem is a mutable value together with its metadata.
+type MutableItem struct {
+	MutableMeta
+	Value []byte
+}
+
+// MutableKey is the storage key of items signed by pub under salt.
+func MutableKey(pub ed25519.PublicKey, salt []byte) string {
+	h := sha256.New()
+	h.Write(pub)
+	h.Write(salt)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SignMutable creates a mutable item for value at sequence number seq.
+func SignMutable(priv ed25519.PrivateKey, salt []byte, seq int64, value []byte) *MutableItem {
+	return &MutableItem{
+		MutableMeta: MutableMeta{
+			PublicKey: priv.Public().(ed25519.PublicKey),
+			Salt:      salt,
+			Seq:       seq,
+			Signature: ed25519.Sign(priv, mutableSigningBytes(salt, seq, value)),
+		},
+		Value: value,
+	}
+}
+
+// Key returns the key this item is stored under.
+func (m *MutableItem) Key() string {
+	return MutableKey(m.PublicKey, m.Salt)
+}
+
+// Verify checks the signature, and that key is where the item belongs.
+func (m *MutableItem) Verify(key string) error {
+	if len(m.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: %d", len(m.PublicKey))
+	}
+	if key != m.Key() {
+		return ErrMutableKeyMismatch
+	}
+	if !ed25519.Verify(m.PublicKey, mutableSigningBytes(m.Salt, m.Seq, m.Value), m.Signature) {
+		return ErrBadMutableSignature
+	}
+	return nil
+}
+
+// the signed buffer follows BEP 44: the bencoded salt, seq and v entries
+func mutableSigningBytes(salt []byte, seq int64, value []byte) []byte {
+	var buf bytes.Buffer
+	if len(salt) > 0 {
+		buf.WriteString("4:salt")
+		buf.WriteString(strconv.Itoa(len(salt)))
+		buf.WriteByte(':')
+		buf.Write(salt)
+	}
+	buf.WriteString("3:seqi")
+	buf.WriteString(strconv.FormatInt(seq, 10))
+	buf.WriteString("e1:v")
+	buf.WriteString(strconv.Itoa(len(value)))
+	buf.WriteByte(':')
+	buf.Write(value)
+	return buf.Bytes()
+}
+
+// CheckMutableReplace decides whether item may replace what is stored under
+// its key. Run under the store lock, so compare-and-swap is atomic.
+func CheckMutableReplace(item *MutableItem, old StoredValue, exists bool) error {
+	if !exists {
+		if item.CAS != nil && *item.CAS != 0 {
+			return ErrCASMismatch
+		}
+		return nil
+	}
+	if old.Mutable == nil {
+		// a plain value squatting on the key: the signed item wins
+		return nil
+	}
+
+	if item.CAS != nil && *item.CAS != old.Mutable.Seq {
+		return ErrCASMismatch
+	}
+	if item.Seq < old.Mutable.Seq {
+		return ErrSeqTooLow
+	}
+	if item.Seq == old.Mutable.Seq && !bytes.Equal(item.Value, old.Value) {
+		return ErrSeqTooLow
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func newMutableKey(t *testing.T) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return priv
+}
+
+func TestMutableSignVerify(t *testing.T) {
+	priv := newMutableKey(t)
+	item := SignMutable(priv, []byte("salt"), 1, []byte("hello"))
+
+	if err := item.Verify(item.Key()); err != nil {
+		t.Errorf("got %v, wanted valid item", err)
+	}
+	if err := item.Verify(MutableKey(item.PublicKey, nil)); !errors.Is(err, ErrMutableKeyMismatch) {
+		t.Errorf("got %v, wanted %v for key without salt", err, ErrMutableKeyMismatch)
+	}
+
+	item.Value = []byte("tampered")
+	if err := item.Verify(item.Key()); !errors.Is(err, ErrBadMutableSignature) {
+		t.Errorf("got %v, wanted %v", err, ErrBadMutableSignature)
+	}
+}
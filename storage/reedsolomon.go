@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTooFewShards = errors.New("too few shards to reconstruct")
+
+// GF(2^8) with the usual 0x11d reduction polynomial. Addition is xor.
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	// doubled so gfMul can skip the mod 255
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// ReedSolomon is a systematic Reed-Solomon code over GF(2^8): the first
+// data shards are the input itself, the rest parity, and any data shards
+// out of the total are enough to get the input back. The parity rows of the
+// encoding matrix form a Cauchy matrix, which keeps every square submatrix
+// of [identity; parity] invertible.
+type ReedSolomon struct {
+	data   int
+	total  int
+	matrix [][]byte // total x data
+}
+
+func NewReedSolomon(data, total int) (*ReedSolomon, error) {
+	if data < 1 || total < data || total > 256 {
+		return nil, fmt.Errorf("invalid shard counts: %d data of %d total (want 1 <= data <= total <= 256)", data, total)
+	}
+
+	matrix := make([][]byte, total)
+	for r := range matrix {
+		matrix[r] = make([]byte, data)
+		if r < data {
+			matrix[r][r] = 1
+			continue
+		}
+		// x_r = r and y_c = c never meet since r >= data > c
+		for c := 0; c < data; c++ {
+			matrix[r][c] = gfInv(byte(r) ^ byte(c))
+		}
+	}
+	return &ReedSolomon{data: data, total: total, matrix: matrix}, nil
+}
+
+// ShardSize is the size of every shard Encode makes from size bytes.
+func (rs *ReedSolomon) ShardSize(size int) int {
+	s := (size + rs.data - 1) / rs.data
+	if s == 0 {
+		s = 1
+	}
+	return s
+}
+
+// Encode splits value into data shards, zero-padding the last one, and
+// appends the parity shards.
+func (rs *ReedSolomon) Encode(value []byte) [][]byte {
+	size := rs.ShardSize(len(value))
+	padded := make([]byte, size*rs.data)
+	copy(padded, value)
+
+	shards := make([][]byte, rs.total)
+	for c := 0; c < rs.data; c++ {
+		shards[c] = padded[c*size : (c+1)*size]
+	}
+	for r := rs.data; r < rs.total; r++ {
+		shards[r] = rs.combine(rs.matrix[r], shards[:rs.data], size)
+	}
+	return shards
+}
+
+// Reconstruct returns the value shards were made from, given at least data
+// of them (missing shards are nil) and the original value size.
+func (rs *ReedSolomon) Reconstruct(shards [][]byte, size int) ([]byte, error) {
+	if len(shards) != rs.total {
+		return nil, fmt.Errorf("got %d shards, want %d", len(shards), rs.total)
+	}
+	shardSize := rs.ShardSize(size)
+
+	var rows [][]byte
+	var inputs [][]byte
+	for i, s := range shards {
+		if s == nil {
+			continue
+		}
+		if len(s) != shardSize {
+			return nil, fmt.Errorf("shard %d is %d bytes, want %d", i, len(s), shardSize)
+		}
+		rows = append(rows, rs.matrix[i])
+		inputs = append(inputs, s)
+		if len(rows) == rs.data {
+			break
+		}
+	}
+	if len(rows) < rs.data {
+		return nil, fmt.Errorf("%w: have %d of %d", ErrTooFewShards, len(rows), rs.data)
+	}
+
+	decode, err := gfInvert(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, shardSize*rs.data)
+	for c := 0; c < rs.data; c++ {
+		out = append(out, rs.combine(decode[c], inputs, shardSize)...)
+	}
+	return out[:size], nil
+}
+
+// combine returns the sum over i of coeffs[i] * shards[i].
+func (rs *ReedSolomon) combine(coeffs []byte, shards [][]byte, size int) []byte {
+	out := make([]byte, size)
+	for i, coeff := range coeffs {
+		if coeff == 0 {
+			continue
+		}
+		for pos, b := range shards[i] {
+			out[pos] ^= gfMul(coeff, b)
+		}
+	}
+	return out
+}
+
+// gfInvert inverts a square matrix over GF(2^8) by Gauss-Jordan elimination.
+func gfInvert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for r := range m {
+		work[r] = make([]byte, 2*n)
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot == -1 {
+			return nil, errors.New("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+		for c := range work[col] {
+			work[col][c] = gfMul(work[col][c], scale)
+		}
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			factor := work[r][col]
+			for c := range work[r] {
+				work[r][c] ^= gfMul(factor, work[col][c])
+			}
+		}
+	}
+
+	inv := make([][]byte, n)
+	for r := range work {
+		inv[r] = work[r][n:]
+	}
+	return inv, nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestReedSolomonAnyDataShards(t *testing.T) {
+	rs, err := NewReedSolomon(3, 5)
+	if err != nil {
+		t.Fatalf("NewReedSolomon: %v", err)
+	}
+
+	value := make([]byte, 1000)
+	rand.Read(value)
+	shards := rs.Encode(value)
+
+	if !bytes.Equal(bytes.Join(shards[:3], nil)[:len(value)], value) {
+		t.Errorf("got data shards that don't spell out the value, wanted a systematic code")
+	}
+
+	// every way of losing two shards
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			partial := make([][]byte, len(shards))
+			copy(partial, shards)
+			partial[a], partial[b] = nil, nil
+
+			got, err := rs.Reconstruct(partial, len(value))
+			if err != nil {
+				t.Fatalf("Reconstruct without %d,%d: %v", a, b, err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Errorf("got a different value back without shards %d,%d", a, b)
+			}
+		}
+	}
+
+	partial := [][]byte{shards[0], nil, nil, nil, shards[4]}
+	if _, err := rs.Reconstruct(partial, len(value)); !errors.Is(err, ErrTooFewShards) {
+		t.Errorf("got %v, wanted %v", err, ErrTooFewShards)
+	}
+}
+
+func TestReedSolomonBadCounts(t *testing.T) {
+	for _, c := range [][2]int{{0, 3}, {4, 3}, {10, 300}} {
+		if _, err := NewReedSolomon(c[0], c[1]); err == nil {
+			t.Errorf("got nil error for %d of %d shards, wanted error", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,515 @@
+// Package storage is the local value store of a node: stored values with
+// their TTLs, quotas and tombstones, plus the signed mutable items,
+// content-addressed immutable items, versions and Reed-Solomon coding
+// that values build on.
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy decides what happens when the store hits its global cap.
+type EvictionPolicy int
+
+const (
+	EvictOldest EvictionPolicy = iota // drop the values stored longest ago
+	EvictNone                         // refuse new values until space frees up
+)
+
+// ParseEvictionPolicy maps a policy name (oldest or none) to its
+// EvictionPolicy.
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "oldest":
+		return EvictOldest, nil
+	case "none":
+		return EvictNone, nil
+	}
+	return 0, fmt.Errorf("unknown eviction policy: %s", name)
+}
+
+func (p EvictionPolicy) String() string {
+	if p == EvictNone {
+		return "none"
+	}
+	return "oldest"
+}
+
+// Quotas are the limits a ValueStore enforces, 0 = unlimited. A
+// publisher's tombstones count against MaxKeysPerPublisher separately from
+// its values.
+type Quotas struct {
+	MaxKeysPerPublisher  int
+	MaxBytesPerPublisher int
+	MaxStoreKeys         int
+	MaxStoreBytes        int
+	MaxTombstones        int
+	Eviction             EvictionPolicy
+}
+
+func DefaultQuotas() Quotas {
+	return Quotas{
+		MaxKeysPerPublisher:  1000,
+		MaxBytesPerPublisher: 8 << 20,
+		MaxStoreKeys:         100000,
+		MaxStoreBytes:        256 << 20,
+		MaxTombstones:        100000,
+		Eviction:             EvictOldest,
+	}
+}
+
+var (
+	ErrQuotaExceeded = errors.New("publisher storage quota exceeded")
+	ErrStoreFull     = errors.New("store is full")
+	ErrDeleted       = errors.New("key was deleted by its publisher")
+	ErrNotPublisher  = errors.New("only the publisher may delete a value")
+)
+
+// StoredValue is a value plus what we know about where it came from.
+type StoredValue struct {
+	Key       string
+	Value     []byte
+	Publisher string    // hex ID of the node that first published the value
+	StoredBy  string    // who is charged for it in the quotas: the storing node's ID or address; Publisher if empty
+	Timestamp time.Time // publisher's clock when the value was written
+	Version   Version   // logical version, zero for unversioned values
+	StoredAt  time.Time // our clock when it was last stored here
+
+	Mutable   *MutableMeta // set for signed mutable items
+	Immutable bool         // set for content-addressed items, Key is the hash of Value
+	Erasure   bool         // set when Value is an ErasureManifest
+	Fragment  bool         // set for erasure-coded fragments, repaired by the manifest's holders
+}
+
+// Tombstone marks a key its publisher deleted. It blocks that publisher's
+// value from coming back through stale replicas until ExpiresAt. Each
+// publisher has its own tombstone on a key, so one can't lift another's.
+type Tombstone struct {
+	Key       string
+	Publisher string
+	DeletedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (v *StoredValue) size() int {
+	return len(v.Key) + len(v.Value)
+}
+
+// quotaOwner is whose per-publisher quota the value counts against.
+func (v *StoredValue) quotaOwner() string {
+	if v.StoredBy != "" {
+		return v.StoredBy
+	}
+	return v.Publisher
+}
+
+type tombstoneKey struct {
+	key       string
+	publisher string
+}
+
+type publisherUsage struct {
+	keys  int
+	bytes int
+}
+
+// ValueStore is a node's local key/value storage. It enforces per-publisher
+// quotas and a global size cap, evicting according to its EvictionPolicy.
+type ValueStore struct {
+	mu         sync.RWMutex
+	limits     Quotas
+	items      map[string]*list.Element // key -> element holding *StoredValue
+	order      *list.List               // oldest store at the front
+	bytes      int
+	publishers map[string]*publisherUsage
+	tombstones map[tombstoneKey]Tombstone
+	// live tombstones per publisher
+	tombstonesBy map[string]int
+	now          func() time.Time
+
+	evictions  uint64
+	rejections uint64
+}
+
+func NewValueStore(limits Quotas) *ValueStore {
+	return &ValueStore{
+		limits:       limits,
+		items:        make(map[string]*list.Element),
+		order:        list.New(),
+		publishers:   make(map[string]*publisherUsage),
+		tombstones:   make(map[tombstoneKey]Tombstone),
+		tombstonesBy: make(map[string]int),
+		now:          time.Now,
+	}
+}
+
+// SetLimits changes the quotas. Values already stored are not evicted
+// until the next Put needs the space.
+func (s *ValueStore) SetLimits(limits Quotas) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+}
+
+// Now returns the time according to the store's clock.
+func (s *ValueStore) Now() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.now()
+}
+
+// SetClock replaces the clock the store stamps and expires entries by.
+func (s *ValueStore) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// Put stores value under key on behalf of publisher, replacing any previous value.
+func (s *ValueStore) Put(key string, value []byte, publisher string) error {
+	return s.PutItem(StoredValue{Key: key, Value: value, Publisher: publisher}, nil)
+}
+
+// PutItem stores item, replacing any previous value under item.Key. If check
+// is given it runs under the store lock with whatever is stored now, and a
+// non-nil error refuses the write. Plain values never replace mutable or
+// content-addressed items, and a publisher's values stay out while it has a
+// tombstone on the key.
+func (s *ValueStore) PutItem(newItem StoredValue, check func(old StoredValue, exists bool) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := &newItem
+	item.StoredAt = s.now()
+	key := item.Key
+	publisher := item.Publisher
+
+	if ts, ok := s.tombstones[tombstoneKey{key, publisher}]; ok && item.StoredAt.Before(ts.ExpiresAt) {
+		s.rejections++
+		return ErrDeleted
+	}
+
+	old, hadOld := s.items[key]
+
+	var current StoredValue
+	if hadOld {
+		current = *old.Value.(*StoredValue)
+	}
+	if hadOld && current.Mutable != nil && item.Mutable == nil {
+		return ErrKeyIsMutable
+	}
+	if hadOld && current.Immutable && !item.Immutable {
+		return ErrKeyIsImmutable
+	}
+	if check != nil {
+		if err := check(current, hadOld); err != nil {
+			return err
+		}
+	}
+
+	// take the old value out of the accounting first, put it back if we refuse
+	if hadOld {
+		s.remove(old)
+	}
+	restore := func() {
+		if hadOld {
+			s.insert(old.Value.(*StoredValue))
+		}
+	}
+
+	usage := s.publishers[item.quotaOwner()]
+	if usage == nil {
+		usage = &publisherUsage{}
+	}
+	if (s.limits.MaxKeysPerPublisher > 0 && usage.keys+1 > s.limits.MaxKeysPerPublisher) ||
+		(s.limits.MaxBytesPerPublisher > 0 && usage.bytes+item.size() > s.limits.MaxBytesPerPublisher) {
+		restore()
+		s.rejections++
+		return ErrQuotaExceeded
+	}
+
+	if s.limits.MaxStoreBytes > 0 && item.size() > s.limits.MaxStoreBytes {
+		restore()
+		s.rejections++
+		return ErrStoreFull
+	}
+
+	for s.overCap(item) {
+		if s.limits.Eviction != EvictOldest || s.order.Len() == 0 {
+			restore()
+			s.rejections++
+			return ErrStoreFull
+		}
+		s.remove(s.order.Front())
+		s.evictions++
+	}
+
+	s.insert(item)
+	return nil
+}
+
+// overCap reports whether adding item would break the global cap.
+func (s *ValueStore) overCap(item *StoredValue) bool {
+	if s.limits.MaxStoreKeys > 0 && len(s.items)+1 > s.limits.MaxStoreKeys {
+		return true
+	}
+	return s.limits.MaxStoreBytes > 0 && s.bytes+item.size() > s.limits.MaxStoreBytes
+}
+
+func (s *ValueStore) insert(item *StoredValue) {
+	s.items[item.Key] = s.order.PushBack(item)
+	s.bytes += item.size()
+
+	usage := s.publishers[item.quotaOwner()]
+	if usage == nil {
+		usage = &publisherUsage{}
+		s.publishers[item.quotaOwner()] = usage
+	}
+	usage.keys++
+	usage.bytes += item.size()
+}
+
+func (s *ValueStore) remove(elem *list.Element) {
+	item := elem.Value.(*StoredValue)
+	s.order.Remove(elem)
+	delete(s.items, item.Key)
+	s.bytes -= item.size()
+
+	if usage := s.publishers[item.quotaOwner()]; usage != nil {
+		usage.keys--
+		usage.bytes -= item.size()
+		if usage.keys <= 0 {
+			delete(s.publishers, item.quotaOwner())
+		}
+	}
+}
+
+// Get returns a copy of what is stored under key.
+func (s *ValueStore) Get(key string) (StoredValue, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return StoredValue{}, false
+	}
+	return *elem.Value.(*StoredValue), true
+}
+
+// Delete removes key, reporting whether it was there.
+func (s *ValueStore) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if ok {
+		s.remove(elem)
+	}
+	return ok
+}
+
+// Tombstone deletes key on behalf of publisher and keeps a tombstone for it
+// for ttl. A value stored under key by anyone else is left alone and
+// ErrNotPublisher returned. A new tombstone counts against the publisher's
+// key quota and the global tombstone cap. Reports whether a value was
+// removed.
+func (s *ValueStore) Tombstone(key, publisher string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if ok && elem.Value.(*StoredValue).Publisher != publisher {
+		return false, ErrNotPublisher
+	}
+
+	id := tombstoneKey{key, publisher}
+	if _, exists := s.tombstones[id]; !exists {
+		if s.limits.MaxKeysPerPublisher > 0 && s.tombstonesBy[publisher]+1 > s.limits.MaxKeysPerPublisher {
+			s.rejections++
+			return false, ErrQuotaExceeded
+		}
+		if s.limits.MaxTombstones > 0 && len(s.tombstones)+1 > s.limits.MaxTombstones {
+			s.rejections++
+			return false, ErrStoreFull
+		}
+	}
+	if ok {
+		s.remove(elem)
+	}
+
+	now := s.now()
+	s.putTombstone(Tombstone{
+		Key:       key,
+		Publisher: publisher,
+		DeletedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	return ok, nil
+}
+
+func (s *ValueStore) putTombstone(ts Tombstone) {
+	id := tombstoneKey{ts.Key, ts.Publisher}
+	if _, exists := s.tombstones[id]; !exists {
+		s.tombstonesBy[ts.Publisher]++
+	}
+	s.tombstones[id] = ts
+}
+
+func (s *ValueStore) dropTombstone(id tombstoneKey) {
+	if _, exists := s.tombstones[id]; !exists {
+		return
+	}
+	delete(s.tombstones, id)
+	if s.tombstonesBy[id.publisher]--; s.tombstonesBy[id.publisher] <= 0 {
+		delete(s.tombstonesBy, id.publisher)
+	}
+}
+
+// ClearTombstone lifts publisher's tombstone on key, so it can publish there again.
+func (s *ValueStore) ClearTombstone(key, publisher string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropTombstone(tombstoneKey{key, publisher})
+}
+
+// GetTombstone returns publisher's live tombstone on key, if any.
+func (s *ValueStore) GetTombstone(key, publisher string) (Tombstone, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ts, ok := s.tombstones[tombstoneKey{key, publisher}]
+	if !ok || !s.now().Before(ts.ExpiresAt) {
+		return Tombstone{}, false
+	}
+	return ts, true
+}
+
+// ExpireTombstones garbage-collects tombstones past their expiry and
+// returns how many went.
+func (s *ValueStore) ExpireTombstones() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	expired := 0
+	for id, ts := range s.tombstones {
+		if !now.Before(ts.ExpiresAt) {
+			s.dropTombstone(id)
+			expired++
+		}
+	}
+	return expired
+}
+
+// StoredBefore returns copies of the values last stored before t.
+func (s *ValueStore) StoredBefore(t time.Time) []StoredValue {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []StoredValue
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		if item := elem.Value.(*StoredValue); item.StoredAt.Before(t) {
+			out = append(out, *item)
+		}
+	}
+	return out
+}
+
+// StoreSnapshot is the content of a ValueStore, for saving it across restarts.
+type StoreSnapshot struct {
+	Values     []StoredValue
+	Tombstones []Tombstone
+}
+
+// Snapshot returns copies of every stored value, oldest first, and of the
+// tombstones.
+func (s *ValueStore) Snapshot() StoreSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := StoreSnapshot{Values: make([]StoredValue, 0, len(s.items))}
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		snap.Values = append(snap.Values, *elem.Value.(*StoredValue))
+	}
+	for _, ts := range s.tombstones {
+		snap.Tombstones = append(snap.Tombstones, ts)
+	}
+	return snap
+}
+
+// Restore loads a snapshot into the store. Values keep their StoredAt, so
+// republishing carries on where it left off; keys already present and
+// values that don't fit under the quotas are skipped. Returns how many
+// values were restored.
+func (s *ValueStore) Restore(snap StoreSnapshot) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, ts := range snap.Tombstones {
+		if now.Before(ts.ExpiresAt) {
+			s.putTombstone(ts)
+		}
+	}
+
+	restored := 0
+	for i := range snap.Values {
+		item := snap.Values[i]
+		if _, exists := s.items[item.Key]; exists {
+			continue
+		}
+		usage := s.publishers[item.quotaOwner()]
+		if usage == nil {
+			usage = &publisherUsage{}
+		}
+		if (s.limits.MaxKeysPerPublisher > 0 && usage.keys+1 > s.limits.MaxKeysPerPublisher) ||
+			(s.limits.MaxBytesPerPublisher > 0 && usage.bytes+item.size() > s.limits.MaxBytesPerPublisher) ||
+			s.overCap(&item) {
+			continue
+		}
+		s.insert(&item)
+		restored++
+	}
+	return restored
+}
+
+// Keys returns every stored key, oldest first.
+func (s *ValueStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.items))
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*StoredValue).Key)
+	}
+	return keys
+}
+
+// StoreStats is a snapshot of the store's size and how often limits kicked in.
+type StoreStats struct {
+	Keys       int
+	Bytes      int
+	Publishers int
+	Tombstones int
+	Evictions  uint64
+	Rejections uint64
+}
+
+func (s *ValueStore) Stats() StoreStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return StoreStats{
+		Keys:       len(s.items),
+		Bytes:      s.bytes,
+		Publishers: len(s.publishers),
+		Tombstones: len(s.tombstones),
+		Evictions:  s.evictions,
+		Rejections: s.rejections,
+	}
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPublisherQuota(t *testing.T) {
+	store := NewValueStore(Quotas{MaxKeysPerPublisher: 2, MaxBytesPerPublisher: 20})
+
+	if err := store.Put("a", []byte("1"), "alice"); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+	if err := store.Put("b", []byte("2"), "alice"); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+	if err := store.Put("c", []byte("3"), "alice"); err != ErrQuotaExceeded {
+		t.Errorf("got %v, wanted %v", err, ErrQuotaExceeded)
+	}
+
+	// overwriting an existing key doesn't count as a new key
+	if err := store.Put("a", []byte("one"), "alice"); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+
+	// too many bytes
+	if err := store.Put("x", []byte("0123456789012345678901234"), "bob"); err != ErrQuotaExceeded {
+		t.Errorf("got %v, wanted %v", err, ErrQuotaExceeded)
+	}
+
+	// other publishers have their own quota
+	if err := store.Put("c", []byte("3"), "bob"); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+
+	stats := store.Stats()
+	if stats.Keys != 3 || stats.Publishers != 2 || stats.Rejections != 2 {
+		t.Errorf("got %+v, wanted 3 keys, 2 publishers, 2 rejections", stats)
+	}
+}
+
+func TestQuotaChargedToStoredBy(t *testing.T) {
+	store := NewValueStore(Quotas{MaxKeysPerPublisher: 1})
+
+	// carol stores alice's value: carol pays
+	if err := store.PutItem(StoredValue{Key: "a", Value: []byte("1"), Publisher: "alice", StoredBy: "carol"}, nil); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+	if err := store.Put("b", []byte("2"), "alice"); err != nil {
+		t.Errorf("got %v for alice's own key, wanted nil", err)
+	}
+	if err := store.PutItem(StoredValue{Key: "c", Value: []byte("3"), Publisher: "alice", StoredBy: "carol"}, nil); err != ErrQuotaExceeded {
+		t.Errorf("got %v, wanted %v", err, ErrQuotaExceeded)
+	}
+
+	// removing the value gives carol her quota back
+	store.Delete("a")
+	if err := store.PutItem(StoredValue{Key: "c", Value: []byte("3"), Publisher: "alice", StoredBy: "carol"}, nil); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+}
+
+func TestEvictOldest(t *testing.T) {
+	store := NewValueStore(Quotas{MaxStoreKeys: 2, Eviction: EvictOldest})
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { now = now.Add(time.Second); return now }
+
+	store.Put("first", []byte("1"), "alice")
+	store.Put("second", []byte("2"), "alice")
+	if err := store.Put("third", []byte("3"), "bob"); err != nil {
+		t.Fatalf("got %v, wanted nil", err)
+	}
+
+	if _, ok := store.Get("first"); ok {
+		t.Errorf("oldest key still stored, wanted it evicted")
+	}
+	got := store.Keys()
+	want := []string{"second", "third"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	if store.Stats().Evictions != 1 {
+		t.Errorf("got %d evictions, wanted 1", store.Stats().Evictions)
+	}
+}
+
+func TestEvictNone(t *testing.T) {
+	store := NewValueStore(Quotas{MaxStoreBytes: 10, Eviction: EvictNone})
+
+	if err := store.Put("k1", []byte("12345"), "alice"); err != nil {
+		t.Errorf("got %v, wanted nil", err)
+	}
+	if err := store.Put("k2", []byte("12345"), "alice"); err != ErrStoreFull {
+		t.Errorf("got %v, wanted %v", err, ErrStoreFull)
+	}
+
+	// growing k1 past the cap is refused, and the old value survives
+	if err := store.Put("k1", []byte("123456789"), "alice"); err != ErrStoreFull {
+		t.Errorf("got %v, wanted %v", err, ErrStoreFull)
+	}
+	v, _ := store.Get("k1")
+	if string(v.Value) != "12345" {
+		t.Errorf("got %q, wanted %q", v.Value, "12345")
+	}
+	if store.Stats().Bytes != 7 {
+		t.Errorf("got %d bytes, wanted 7", store.Stats().Bytes)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewValueStore(Quotas{})
+	s.now = func() time.Time { return now }
+
+	if err := s.Put("k", []byte("v"), "alice"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := s.Tombstone("k", "mallory", time.Hour); !errors.Is(err, ErrNotPublisher) {
+		t.Errorf("got %v, wanted %v", err, ErrNotPublisher)
+	}
+	if removed, err := s.Tombstone("k", "alice", time.Hour); err != nil || !removed {
+		t.Fatalf("got removed=%t err=%v, wanted value removed", removed, err)
+	}
+
+	// alice's value can't come back through a stale replica...
+	if err := s.Put("k", []byte("v"), "alice"); !errors.Is(err, ErrDeleted) {
+		t.Errorf("got %v, wanted %v", err, ErrDeleted)
+	}
+	// ...but the tombstone doesn't lock the key for everyone
+	if err := s.Put("k", []byte("other"), "bob"); err != nil {
+		t.Errorf("got %v, wanted other publishers to be unaffected", err)
+	}
+	s.Delete("k")
+
+	now = now.Add(time.Hour)
+	if got := s.ExpireTombstones(); got != 1 {
+		t.Errorf("got %d expired, wanted 1", got)
+	}
+	if err := s.Put("k", []byte("v"), "alice"); err != nil {
+		t.Errorf("got %v, wanted value accepted after the tombstone expired", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewValueStore(DefaultQuotas())
+	store.SetClock(func() time.Time { return now })
+
+	store.Put("a", []byte("1"), "alice")
+	store.Put("b", []byte("2"), "bob")
+	store.Tombstone("c", "carol", time.Hour)
+
+	restored := NewValueStore(Quotas{MaxStoreKeys: 2})
+	restored.SetClock(func() time.Time { return now.Add(time.Minute) })
+	restored.Put("x", []byte("9"), "xavier")
+
+	// only room for one more key
+	if got := restored.Restore(store.Snapshot()); got != 1 {
+		t.Errorf("got %d values restored, wanted 1", got)
+	}
+	item, ok := restored.Get("a")
+	if !ok || string(item.Value) != "1" {
+		t.Fatalf("got %q, %v, wanted the oldest value restored first", item.Value, ok)
+	}
+	if !item.StoredAt.Equal(now) {
+		t.Errorf("got StoredAt %v, wanted it kept at %v", item.StoredAt, now)
+	}
+	if _, ok := restored.GetTombstone("c", "carol"); !ok {
+		t.Errorf("got no tombstone on c, wanted it restored")
+	}
+}
+
+func TestTombstonesPerPublisher(t *testing.T) {
+	s := NewValueStore(Quotas{MaxKeysPerPublisher: 1, MaxTombstones: 2})
+
+	if _, err := s.Tombstone("k", "alice", time.Hour); err != nil {
+		t.Fatalf("Tombstone: %v", err)
+	}
+	// bob deleting the absent key doesn't lift alice's tombstone
+	if _, err := s.Tombstone("k", "bob", time.Hour); err != nil {
+		t.Fatalf("Tombstone: %v", err)
+	}
+	if err := s.Put("k", []byte("v"), "alice"); !errors.Is(err, ErrDeleted) {
+		t.Errorf("got %v, wanted %v", err, ErrDeleted)
+	}
+
+	// each publisher's tombstones count against its quota...
+	if _, err := s.Tombstone("other", "alice", time.Hour); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("got %v, wanted %v", err, ErrQuotaExceeded)
+	}
+	// ...and all of them against the global cap
+	if _, err := s.Tombstone("other", "carol", time.Hour); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("got %v, wanted %v", err, ErrStoreFull)
+	}
+	// renewing a tombstone needs no room
+	if _, err := s.Tombstone("k", "alice", time.Hour); err != nil {
+		t.Errorf("got %v, wanted the tombstone renewed", err)
+	}
+
+	s.ClearTombstone("k", "alice")
+	if _, ok := s.GetTombstone("k", "bob"); !ok {
+		t.Errorf("got bob's tombstone cleared along with alice's")
+	}
+	if got := s.Stats().Tombstones; got != 1 {
+		t.Errorf("got %d tombstones, wanted 1", got)
+	}
+}
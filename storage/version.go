@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrStaleVersion = errors.New("a newer version of the value is already stored")
+
+// Version orders writes to a key: a Lamport clock, with the publisher's ID
+// breaking ties between writes made at the same clock value, which are
+// concurrent.
+type Version struct {
+	Clock     uint64 `json:"clock"`
+	Publisher string `json:"publisher"`
+}
+
+// Newer reports whether v supersedes o.
+func (v Version) Newer(o Version) bool {
+	if v.Clock != o.Clock {
+		return v.Clock > o.Clock
+	}
+	return v.Publisher > o.Publisher
+}
+
+// ConcurrentWith reports whether v and o were written without either
+// publisher having seen the other's write.
+func (v Version) ConcurrentWith(o Version) bool {
+	return v.Clock == o.Clock && v.Publisher != o.Publisher
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d@%s", v.Clock, v.Publisher)
+}
+
+// CheckVersion refuses a plain value older than the one it would replace.
+func CheckVersion(v Version, old StoredValue, exists bool) error {
+	if exists && old.Version.Newer(v) {
+		return ErrStaleVersion
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestVersionOrder(t *testing.T) {
+	a1 := Version{Clock: 1, Publisher: "a"}
+	b1 := Version{Clock: 1, Publisher: "b"}
+	a2 := Version{Clock: 2, Publisher: "a"}
+
+	if !a2.Newer(b1) || b1.Newer(a2) {
+		t.Errorf("got %v not newer than %v, wanted higher clock to win", a2, b1)
+	}
+	if !b1.Newer(a1) || a1.Newer(b1) {
+		t.Errorf("got %v not newer than %v, wanted publisher ID to break ties", b1, a1)
+	}
+	if !a1.ConcurrentWith(b1) || a1.ConcurrentWith(a2) {
+		t.Errorf("got wrong concurrency for %v, %v, %v", a1, b1, a2)
+	}
+}
@@ -0,0 +1,9 @@
+package transport
+
+import "time"
+
+const UDP_BUFFER_SIZE = 65507                // largest UDP payload, so bigger node lists / values fit
+const INCOMING_QUEUE_SIZE = 256              // requests buffered between the socket reader and ListenRPC
+const REPLAY_WINDOW = 64                     // sealed RPC counters remembered per session for replay protection
+const MAX_SECURE_SESSIONS = 10000            // sessions kept at once, the least recently used goes first
+const SECURE_SESSION_IDLE = 30 * time.Minute // sessions unused this long are dropped by ExpireSessions
@@ -0,0 +1,279 @@
+// Package transport sends and receives RPC messages over UDP, matching
+// replies to requests, and implements RPC signing and the encrypted secure
+// channel.
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// UDPTransport owns a single UDP socket that a node uses
+// for both sending and receiving messages.
+//
+// A single reader goroutine owns the socket. Packets answering one of our
+// outstanding requests (matched by RequestID) are handed to the waiting
+// SendRPC call; everything else is queued for ListenRPC. This lets several
+// lookups have RPCs in flight while the node is also serving requests.
+type UDPTransport struct {
+	conn *net.UDPConn // underlying socket
+	addr *net.UDPAddr // local address (IP + port)
+
+	mu       sync.Mutex
+	closed   bool
+	deaf     bool                        // StopListening was called, incoming is closed
+	pending  map[string]chan *RPCMessage // request id -> waiting SendRPC
+	incoming chan inboundRPC             // requests waiting for ListenRPC
+	secure   *SecureChannel              // nil unless EnableSecureChannel was called
+
+	logger atomic.Pointer[slog.Logger] // nil until SetLogger, then used by the reader
+}
+
+type inboundRPC struct {
+	msg  *RPCMessage
+	from *net.UDPAddr
+}
+
+// NewUDPTransport creates a UDP socket bound to listenIP:port.
+// This will be the single socket used for both send + receive.
+func NewUDPTransport(listenIP string, port int) (*UDPTransport, error) {
+	localAddr := &net.UDPAddr{
+		IP:   net.ParseIP(listenIP),
+		Port: port,
+	}
+
+	conn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+
+	t := &UDPTransport{
+		conn:     conn,
+		addr:     localAddr,
+		pending:  make(map[string]chan *RPCMessage),
+		incoming: make(chan inboundRPC, INCOMING_QUEUE_SIZE),
+	}
+	go t.readLoop()
+
+	return t, nil
+}
+
+// SetLogger makes the transport log dropped and malformed packets to logger.
+func (t *UDPTransport) SetLogger(logger *slog.Logger) {
+	t.logger.Store(logger)
+}
+
+// log returns the logger set by SetLogger, or one that discards everything.
+func (t *UDPTransport) log() *slog.Logger {
+	if logger := t.logger.Load(); logger != nil {
+		return logger
+	}
+	return slog.New(slog.DiscardHandler)
+}
+
+// readLoop is the only reader of the socket. It routes responses to their
+// pending SendRPC call and queues everything else for ListenRPC.
+func (t *UDPTransport) readLoop() {
+	buf := make([]byte, UDP_BUFFER_SIZE)
+
+	for {
+		n, remoteAddr, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			if t.isClosed() {
+				// lets ListenRPC return
+				t.StopListening()
+				return
+			}
+			t.log().Warn("reading UDP packet", "err", err)
+			continue
+		}
+
+		var raw RPCMessage
+		if err := json.Unmarshal(buf[:n], &raw); err != nil {
+			t.log().Debug("dropping malformed RPC", "peer", remoteAddr.String(), "err", err)
+			continue
+		}
+
+		// handshakes and sealed envelopes are dealt with here
+		msg, ok := t.unwrap(&raw, remoteAddr)
+		if !ok {
+			continue
+		}
+
+		if msg.RequestID != "" {
+			t.mu.Lock()
+			waiter, ok := t.pending[msg.RequestID]
+			if ok {
+				delete(t.pending, msg.RequestID)
+			}
+			t.mu.Unlock()
+
+			if ok {
+				waiter <- msg
+				continue
+			}
+		}
+
+		t.mu.Lock()
+		if !t.deaf {
+			select {
+			case t.incoming <- inboundRPC{msg: msg, from: remoteAddr}:
+			default:
+				t.log().Warn("incoming RPC queue full, dropping RPC", "peer", remoteAddr.String(), "rpc", msg.Type)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// StopListening stops taking new requests, dropping any that arrive from now
+// on. ListenRPC returns once those already queued have been handled, while
+// responses to our own requests keep being delivered until Close.
+func (t *UDPTransport) StopListening() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.deaf {
+		t.deaf = true
+		close(t.incoming)
+	}
+}
+
+// Close closes the socket. ListenRPC returns once the requests already
+// queued have been handled, and SendRPC calls fail from now on.
+func (t *UDPTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	return t.conn.Close()
+}
+
+func (t *UDPTransport) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// ListenRPC passes every incoming request to handler, one at a time, until
+// the transport is closed.
+func (t *UDPTransport) ListenRPC(handler func(msg *RPCMessage, from *net.UDPAddr)) {
+	t.log().Info("listening for RPCs", "addr", t.addr.String())
+
+	for in := range t.incoming {
+		// Hand off to higher-level handler (Node logic)
+		handler(in.msg, in.from)
+	}
+}
+
+// NewRequestID returns a random id used to match a response to its request.
+func NewRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// SendRPC sends an RPCMessage as JSON to addr:port and waits for the response
+// carrying the same RequestID, until ctx is done. If msg has no RequestID one
+// is assigned. With a secure channel enabled the message is sealed first.
+func (t *UDPTransport) SendRPC(ctx context.Context, addr string, port int, msg *RPCMessage) (*RPCMessage, error) {
+	remoteStr := fmt.Sprintf("%s:%d", addr, port)
+	remoteAddr, err := net.ResolveUDPAddr("udp", remoteStr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp addr: %w", err)
+	}
+
+	if msg.RequestID == "" {
+		msg.RequestID = NewRequestID()
+	}
+
+	sc := t.secureChannel()
+	if sc == nil {
+		return t.roundTrip(ctx, msg, remoteAddr)
+	}
+
+	sealed, err := sc.sealRequest(ctx, t, msg, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTrip(ctx, sealed, remoteAddr)
+	if err != nil {
+		// a peer that lost our session (e.g. restarted) can only say so in
+		// plaintext, which we don't trust; handshake again next time
+		sc.forget(remoteAddr, sealed.Session)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// roundTrip writes msg to remote and waits for the response with its
+// RequestID. If ctx is done first it returns ctx.Err().
+func (t *UDPTransport) roundTrip(ctx context.Context, msg *RPCMessage, remote *net.UDPAddr) (*RPCMessage, error) {
+	// Register before sending so a fast response isn't mistaken for a request
+	waiter := make(chan *RPCMessage, 1)
+	t.mu.Lock()
+	t.pending[msg.RequestID] = waiter
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, msg.RequestID)
+		t.mu.Unlock()
+	}()
+
+	// Send bytes via our single shared socket
+	if err := t.writeRPC(msg, remote); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-waiter:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *UDPTransport) isPending(requestID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.pending[requestID]
+	return ok
+}
+
+// SendDirect sends msg to addr without waiting for anything back,
+// e.g. a response to a request we received.
+func (t *UDPTransport) SendDirect(msg *RPCMessage, to *net.UDPAddr) error {
+	if sc := t.secureChannel(); sc != nil {
+		sealed, err := sc.sealResponse(msg, to)
+		if err != nil {
+			return err
+		}
+		msg = sealed
+	}
+	return t.writeRPC(msg, to)
+}
+
+func (t *UDPTransport) writeRPC(msg *RPCMessage, to *net.UDPAddr) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal rpc: %w", err)
+	}
+	if _, err := t.conn.WriteToUDP(payload, to); err != nil {
+		return fmt.Errorf("write to udp: %w", err)
+	}
+	return nil
+}
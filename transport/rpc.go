@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"fmt"
+	"math/big"
+
+	"cs249-dht/node"
+	"cs249-dht/storage"
+)
+
+type RPCDescriptor int
+
+const (
+	RPCPing RPCDescriptor = iota
+	RPCPong
+	RPCFindNode
+	RPCFindNodeResp
+	RPCStore
+	RPCFindValue
+	RPCFindValueResp
+	RPCHandshake
+	RPCHandshakeResp
+	RPCSealed
+	RPCError
+	RPCAnnounce
+	RPCGetProviders
+	RPCDelete
+	RPCLeave
+)
+
+var stateName = map[RPCDescriptor]string{
+	RPCPing:          "Ping",
+	RPCPong:          "Pong",
+	RPCFindNode:      "Find Node",
+	RPCFindNodeResp:  "Find Node Response",
+	RPCStore:         "Store",
+	RPCFindValue:     "Find Value",
+	RPCFindValueResp: "Find Value Response",
+	RPCHandshake:     "Handshake",
+	RPCHandshakeResp: "Handshake Response",
+	RPCSealed:        "Sealed",
+	RPCError:         "Error",
+	RPCAnnounce:      "Announce",
+	RPCGetProviders:  "Get Providers",
+	RPCDelete:        "Delete",
+	RPCLeave:         "Leave",
+}
+
+func (d RPCDescriptor) String() string {
+	if name, ok := stateName[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("RPCDescriptor(%d)", int(d))
+}
+
+// Node info that we send over the wire (simplified)
+type RPCNodeInfo struct {
+	ID   string `json:"id"`
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+
+	// S/Kademlia identity proof, so receivers can check before admitting the node
+	PublicKey []byte `json:"public_key,omitempty"`
+	Nonce     []byte `json:"nonce,omitempty"`
+}
+
+// RPCProvider is a provider record on the wire. TTLSeconds is what is left
+// of its lifetime, so nodes don't need synchronised clocks.
+type RPCProvider struct {
+	ID         string `json:"id"`
+	IP         string `json:"ip"`
+	Port       int    `json:"port"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+// RPCMessage is what we send over the wire as JSON.
+type RPCMessage struct {
+	Type     RPCDescriptor `json:"type"`
+	FromID   string        `json:"from_id"` // hex node id, or dummy for now
+	FromIP   string        `json:"from_ip"`
+	FromPort int           `json:"from_port"`
+
+	// Random id chosen by the requester and echoed in the response
+	RequestID string `json:"request_id,omitempty"`
+
+	// For FIND_NODE / FIND_VALUE
+	TargetID string        `json:"target_id,omitempty"`
+	Nodes    []RPCNodeInfo `json:"nodes,omitempty"`
+
+	// For STORE / FIND_VALUE
+	Key   string `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+
+	// Set when Value is a signed mutable item (BEP 44)
+	Mutable *storage.MutableMeta `json:"mutable,omitempty"`
+	// Set when Key is the SHA-256 of Value
+	Immutable bool `json:"immutable,omitempty"`
+	// Hex ID of the original publisher, set when a replica republishes
+	Publisher string `json:"publisher,omitempty"`
+	// Publisher's clock (unix nanoseconds) when the value was written
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Logical version of the value, for ordering concurrent writes
+	Version *storage.Version `json:"version,omitempty"`
+	// Set when Value is an ErasureManifest, or one fragment it lists
+	Erasure  bool `json:"erasure,omitempty"`
+	Fragment bool `json:"fragment,omitempty"`
+
+	// For ANNOUNCE (requested lifetime) / GET_PROVIDERS (records we hold)
+	TTLSeconds int64         `json:"ttl_seconds,omitempty"`
+	Providers  []RPCProvider `json:"providers,omitempty"`
+
+	// Write token: handed out in FIND_NODE / FIND_VALUE responses, required by STORE
+	Token string `json:"token,omitempty"`
+
+	// S/Kademlia identity of the sender; Signature covers every other field
+	PublicKey []byte `json:"public_key,omitempty"`
+	Nonce     []byte `json:"nonce,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+
+	// Secure channel: handshake key, and the sealed envelope around a real RPC
+	EphemeralKey []byte `json:"ephemeral_key,omitempty"`
+	Session      string `json:"session,omitempty"`
+	Counter      uint64 `json:"counter,omitempty"`
+	Sealed       []byte `json:"sealed,omitempty"`
+
+	// For RPCError
+	Error        string `json:"error,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"` // set when throttled
+}
+
+// Convert our internal Node to wire format
+func NodeToRPC(n *node.Node) RPCNodeInfo {
+	info := RPCNodeInfo{
+		ID:   n.HexID(),
+		IP:   n.IP(),
+		Port: n.Port(),
+	}
+	if proof := n.Proof(); proof != nil {
+		info.PublicKey = proof.PublicKey
+		info.Nonce = proof.Nonce
+	}
+	return info
+}
+
+// Convert wire format back into a Node
+func NodeFromInfo(info RPCNodeInfo) (node.Node, error) {
+	id := new(big.Int)
+	if _, ok := id.SetString(info.ID, 16); !ok {
+		return node.Node{}, fmt.Errorf("invalid node ID hex: %s", info.ID)
+	}
+	n := node.NewNode(id, info.IP, info.Port)
+	if len(info.PublicKey) > 0 {
+		n = n.WithProof(&node.NodeProof{PublicKey: info.PublicKey, Nonce: info.Nonce})
+	}
+	return n, nil
+}
+
+// Convert the RPC sender info back into a Node
+func NodeFromRPC(msg *RPCMessage) (*node.Node, error) {
+	if msg.FromID == "" {
+		return nil, fmt.Errorf("RPCMessage.FromID is empty")
+	}
+	id := new(big.Int)
+	_, ok := id.SetString(msg.FromID, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid FromID hex: %s", msg.FromID)
+	}
+	n := node.NewNode(id, msg.FromIP, msg.FromPort)
+	if len(msg.PublicKey) > 0 {
+		n = n.WithProof(&node.NodeProof{PublicKey: msg.PublicKey, Nonce: msg.Nonce})
+	}
+	return &n, nil
+}
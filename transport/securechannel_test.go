@@ -0,0 +1,261 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"cs249-dht/node"
+)
+
+// sessionPair derives both ends of a session without going over the network
+func sessionPair(t *testing.T) (*secureSession, *secureSession) {
+	initEph, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	respEph, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	ib, rb := initEph.PublicKey().Bytes(), respEph.PublicKey().Bytes()
+
+	initiator, err := deriveSession(initEph, rb, ib, rb, "aa", "bb", true)
+	if err != nil {
+		t.Fatalf("deriveSession: %v", err)
+	}
+	responder, err := deriveSession(respEph, ib, ib, rb, "aa", "bb", false)
+	if err != nil {
+		t.Fatalf("deriveSession: %v", err)
+	}
+	initiator.id, initiator.peerID = "s1", "bb"
+	responder.id, responder.peerID = "s1", "aa"
+	return initiator, responder
+}
+
+func TestSealOpen(t *testing.T) {
+	initiator, responder := sessionPair(t)
+
+	self, _ := node.NewNodeFromIPAndport("127.0.0.1", 1)
+	sender := &SecureChannel{self: self}
+	receiver := &SecureChannel{sessions: map[string]*secureSession{"s1": responder}}
+	responder.peerID = sender.self.HexID()
+
+	msg := &RPCMessage{Type: RPCStore, FromID: self.HexID(), RequestID: "r1", Key: "k", Value: []byte("v")}
+	envelope, err := sender.seal(initiator, msg)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if envelope.Key != "" || len(envelope.Value) > 0 {
+		t.Errorf("envelope leaks the plaintext key/value")
+	}
+
+	got, err := receiver.open(envelope)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if got.Key != "k" || string(got.Value) != "v" {
+		t.Errorf("got %q=%q, wanted %q=%q", got.Key, got.Value, "k", "v")
+	}
+
+	// replaying the same envelope must fail
+	if _, err := receiver.open(envelope); err == nil {
+		t.Errorf("got nil error for replayed envelope, wanted error")
+	}
+
+	// tampering must fail
+	tampered, _ := sender.seal(initiator, msg)
+	tampered.Sealed[0] ^= 0xff
+	if _, err := receiver.open(tampered); err == nil {
+		t.Errorf("got nil error for tampered envelope, wanted error")
+	}
+
+	// a different request id in the header must fail too
+	moved, _ := sender.seal(initiator, msg)
+	moved.RequestID = "r2"
+	if _, err := receiver.open(moved); err == nil {
+		t.Errorf("got nil error for envelope with swapped header, wanted error")
+	}
+
+	// the sender can't speak for another node inside its session
+	other, _ := node.NewNodeFromIPAndport("127.0.0.1", 2)
+	posing, _ := sender.seal(initiator, &RPCMessage{Type: RPCStore, FromID: other.HexID(), RequestID: "r3"})
+	if _, err := receiver.open(posing); err == nil {
+		t.Errorf("got nil error for an inner FromID other than the session's peer, wanted error")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	s := &secureSession{}
+
+	steps := []struct {
+		counter uint64
+		want    bool
+	}{
+		{1, true},
+		{2, true},
+		{3, true},
+		{2, false}, // replay
+		{0, false}, // never valid
+		{70, true},
+		{5, false}, // fell out of the window
+		{69, true}, // out of order but fresh
+		{69, false},
+		{10, true}, // 60 behind, still inside the window
+	}
+
+	for _, step := range steps {
+		got := s.acceptCounter(step.counter)
+		if got != step.want {
+			t.Errorf("counter %d: got %t, wanted %t", step.counter, got, step.want)
+		}
+	}
+}
+
+// startSecureTransport binds a transport with a fresh S/Kademlia identity
+// that answers every RPC with a pong.
+func startSecureTransport(t *testing.T, port int) (*UDPTransport, *node.Identity) {
+	diff := node.PuzzleDifficulty{Static: 4, Dynamic: 4}
+	identity, self, err := node.GenerateIdentity("127.0.0.1", port, diff)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+	tr, err := NewUDPTransport("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("NewUDPTransport: %v", err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	tr.EnableSecureChannel(identity, self, diff)
+	go tr.ListenRPC(func(msg *RPCMessage, from *net.UDPAddr) {
+		tr.SendDirect(&RPCMessage{Type: RPCPong, FromID: self.HexID(), RequestID: msg.RequestID}, from)
+	})
+	return tr, identity
+}
+
+func TestReplayedHelloKeepsSession(t *testing.T) {
+	a, aIdentity := startSecureTransport(t, 21151)
+	b, _ := startSecureTransport(t, 21152)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := a.SendRPC(ctx, "127.0.0.1", 21152, &RPCMessage{Type: RPCPing, FromID: a.secure.self.HexID()}); err != nil {
+		t.Fatalf("SendRPC: %v", err)
+	}
+
+	// an attacker resends a's signed hello for the session in use
+	bAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 21152}
+	a.secure.mu.Lock()
+	sessionID := a.secure.outbound[bAddr.String()].id
+	a.secure.mu.Unlock()
+	ephemeral, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	hello := a.secure.newHandshake(RPCHandshake, sessionID)
+	hello.EphemeralKey = ephemeral.PublicKey().Bytes()
+	if err := SignRPC(aIdentity, hello); err != nil {
+		t.Fatalf("SignRPC: %v", err)
+	}
+	attacker := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 21153}
+	if err := b.secure.accept(b, hello, attacker); err == nil {
+		t.Errorf("got nil error for a replayed hello, wanted it rejected")
+	}
+
+	if _, err := a.SendRPC(ctx, "127.0.0.1", 21152, &RPCMessage{Type: RPCPing, FromID: a.secure.self.HexID()}); err != nil {
+		t.Errorf("got %v after the replay, wanted the session to keep working", err)
+	}
+}
+
+func TestUnsealedErrorOnlyAnswersHello(t *testing.T) {
+	a, _ := startSecureTransport(t, 21154)
+	startSecureTransport(t, 21155)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := a.SendRPC(ctx, "127.0.0.1", 21155, &RPCMessage{Type: RPCPing, FromID: a.secure.self.HexID()}); err != nil {
+		t.Fatalf("SendRPC: %v", err)
+	}
+	bAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 21155}
+
+	// someone who saw a sealed request go by answers it in plaintext
+	a.mu.Lock()
+	a.pending["r1"] = make(chan *RPCMessage, 1)
+	a.mu.Unlock()
+	forged := &RPCMessage{Type: RPCError, RequestID: "r1", Error: errNoSecureSession}
+	if _, ok := a.unwrap(forged, bAddr); ok {
+		t.Errorf("got a forged unsealed error accepted, wanted it dropped")
+	}
+	forged = &RPCMessage{Type: RPCPong, RequestID: "r1"}
+	if _, ok := a.unwrap(forged, bAddr); ok {
+		t.Errorf("got a forged unsealed response accepted, wanted it dropped")
+	}
+
+	// while our hello is out, its rejection comes unsealed
+	a.secure.mu.Lock()
+	a.secure.hellos["h1"] = bAddr.String()
+	a.secure.mu.Unlock()
+	rejected := &RPCMessage{Type: RPCError, RequestID: "h1", Error: errHandshakeRejected}
+	if _, ok := a.unwrap(rejected, bAddr); !ok {
+		t.Errorf("got the answer to our hello dropped, wanted it accepted")
+	}
+	if _, ok := a.unwrap(rejected, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 21156}); ok {
+		t.Errorf("got the answer to our hello accepted from another address")
+	}
+}
+
+func TestIdleSessionsExpire(t *testing.T) {
+	a, _ := startSecureTransport(t, 21157)
+	startSecureTransport(t, 21158)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := a.SendRPC(ctx, "127.0.0.1", 21158, &RPCMessage{Type: RPCPing, FromID: a.secure.self.HexID()}); err != nil {
+		t.Fatalf("SendRPC: %v", err)
+	}
+	if got := a.ExpireSessions(); got != 0 {
+		t.Errorf("got %d sessions expired, wanted the fresh one kept", got)
+	}
+
+	a.secure.mu.Lock()
+	for _, sess := range a.secure.sessions {
+		sess.lastUsed = time.Now().Add(-SECURE_SESSION_IDLE - time.Minute)
+	}
+	a.secure.mu.Unlock()
+	if got := a.ExpireSessions(); got != 1 {
+		t.Errorf("got %d sessions expired, wanted 1", got)
+	}
+	a.secure.mu.Lock()
+	left := len(a.secure.sessions) + len(a.secure.outbound) + len(a.secure.handshakes)
+	a.secure.mu.Unlock()
+	if left != 0 {
+		t.Errorf("got %d entries left, wanted the session, its address and handshake lock gone", left)
+	}
+
+	if _, err := a.SendRPC(ctx, "127.0.0.1", 21158, &RPCMessage{Type: RPCPing, FromID: a.secure.self.HexID()}); err != nil {
+		t.Errorf("got %v, wanted a new handshake after the session expired", err)
+	}
+}
+
+func TestSessionCap(t *testing.T) {
+	sc := &SecureChannel{
+		sessions: make(map[string]*secureSession),
+		outbound: make(map[string]*secureSession),
+		inbound:  make(map[string]*secureSession),
+	}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < MAX_SECURE_SESSIONS-1; i++ {
+		id := NewRequestID()
+		sc.sessions[id] = &secureSession{id: id, lastUsed: start.Add(time.Duration(i+1) * time.Second)}
+	}
+	oldest := &secureSession{id: "oldest", lastUsed: start}
+	sc.sessions[oldest.id] = oldest
+	sc.inbound["127.0.0.1:1"] = oldest
+
+	sc.mu.Lock()
+	sc.addSessionLocked(&secureSession{id: "new"})
+	sc.mu.Unlock()
+
+	if _, ok := sc.sessions["oldest"]; ok {
+		t.Errorf("got the least recently used session kept, wanted it evicted")
+	}
+	if _, ok := sc.inbound["127.0.0.1:1"]; ok {
+		t.Errorf("got the evicted session still answering its peer")
+	}
+	if _, ok := sc.sessions["new"]; !ok || len(sc.sessions) != MAX_SECURE_SESSIONS {
+		t.Errorf("got %d sessions, wanted the new one added at %d", len(sc.sessions), MAX_SECURE_SESSIONS)
+	}
+}
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"cs249-dht/node"
+)
+
+// SignRPC attaches the proof of id to msg and signs it with its private key.
+// Must be called after every other field of msg is set.
+func SignRPC(id *node.Identity, msg *RPCMessage) error {
+	msg.PublicKey = id.PublicKey
+	msg.Nonce = id.Nonce
+	msg.Signature = nil
+
+	payload, err := rpcSigningBytes(msg)
+	if err != nil {
+		return err
+	}
+	msg.Signature = ed25519.Sign(id.PrivateKey, payload)
+	return nil
+}
+
+// VerifyRPCSignature checks msg was signed by the public key it carries.
+// It says nothing about whether that key is bound to FromID, see VerifyNodeProof.
+func VerifyRPCSignature(msg *RPCMessage) error {
+	if len(msg.Signature) == 0 {
+		return errors.New("rpc is not signed")
+	}
+	if len(msg.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: %d", len(msg.PublicKey))
+	}
+
+	payload, err := rpcSigningBytes(msg)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(msg.PublicKey), payload, msg.Signature) {
+		return errors.New("invalid rpc signature")
+	}
+	return nil
+}
+
+// the signature covers the JSON encoding of the message without the signature itself
+func rpcSigningBytes(msg *RPCMessage) ([]byte, error) {
+	unsigned := *msg
+	unsigned.Signature = nil
+	payload, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("marshal rpc for signing: %w", err)
+	}
+	return payload, nil
+}
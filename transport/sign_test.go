@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"testing"
+
+	"cs249-dht/node"
+)
+
+var testPuzzle = node.PuzzleDifficulty{Static: 4, Dynamic: 4}
+
+func TestSignAndVerifyRPC(t *testing.T) {
+	identity, n, err := node.GenerateIdentity("127.0.0.1", 4001, testPuzzle)
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+
+	msg := &RPCMessage{
+		Type:     RPCStore,
+		FromID:   n.HexID(),
+		FromIP:   "127.0.0.1",
+		FromPort: 4001,
+		Key:      "hello",
+		Value:    []byte("world"),
+	}
+	if err := SignRPC(identity, msg); err != nil {
+		t.Fatalf("SignRPC: %v", err)
+	}
+
+	if err := VerifyRPCSignature(msg); err != nil {
+		t.Errorf("got %v, wanted valid signature", err)
+	}
+
+	// tamper with the payload
+	msg.Value = []byte("evil")
+	if err := VerifyRPCSignature(msg); err == nil {
+		t.Errorf("got valid signature for tampered message, wanted error")
+	}
+}